@@ -11,6 +11,8 @@ import (
 	logging "github.com/ipfs/go-log/v2"
 	"github.com/ipni/ipni-cli"
 	"github.com/ipni/ipni-cli/pkg/ads"
+	"github.com/ipni/ipni-cli/pkg/bench"
+	"github.com/ipni/ipni-cli/pkg/dh"
 	"github.com/ipni/ipni-cli/pkg/find"
 	"github.com/ipni/ipni-cli/pkg/provider"
 	"github.com/ipni/ipni-cli/pkg/random"
@@ -30,6 +32,8 @@ func main() {
 		Version: ipnicli.Version,
 		Commands: []*cli.Command{
 			ads.AdsCmd,
+			bench.BenchCmd,
+			dh.DHCmd,
 			find.FindCmd,
 			provider.ProviderCmd,
 			random.RandomCmd,
@@ -2,6 +2,7 @@ package ads
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
 	"github.com/ipfs/go-cid"
@@ -11,24 +12,35 @@ import (
 )
 
 var adsDistSubCmd = &cli.Command{
-	Name:        "dist",
-	Usage:       "Determine the distance between two advertisements in a chain",
-	Description: "Sepcify the start and optional end advertisement CIDs. If end CID is not specified use the latest advertisement",
-	Flags:       adsDistFlags,
-	Action:      adsDistAction,
+	Name:  "dist",
+	Usage: "Determine the distance between two advertisements in a chain",
+	Description: `Sepcify the start and optional end advertisement CIDs. If end CID is not specified use the latest advertisement.
+
+Multiple start CIDs may be specified to get the distance to each of them from the end advertisement. The chain is walked only once for all of them.
+
+If the start advertisement is not an ancestor of the end advertisement, then this is reported when the beginning of the chain is reached.
+
+The --common-ancestor flag treats the start and end CIDs as the heads of two chains, such as an old and a new head after a publisher reset, and finds the newest advertisement common to both chains along with the distance from each head to it.`,
+	Flags:  adsDistFlags,
+	Action: adsDistAction,
 }
 
 var adsDistFlags = []cli.Flag{
 	addrInfoFlag,
-	&cli.StringFlag{
+	&cli.StringSliceFlag{
 		Name:     "start",
-		Usage:    "CID of earliest advertisement in chain",
+		Usage:    "CID of earliest advertisement in chain, multiple OK",
 		Required: true,
 	},
 	&cli.StringFlag{
 		Name:  "end",
 		Usage: "CID of advertisement later in chain. If not specified, use the latest advertisement",
 	},
+	&cli.BoolFlag{
+		Name:    "common-ancestor",
+		Usage:   "Find the newest advertisement common to the chains starting at start and end, and the distance from each",
+		Aliases: []string{"ca"},
+	},
 	&cli.BoolFlag{
 		Name:    "quiet",
 		Usage:   "Output only the distance",
@@ -36,7 +48,7 @@ var adsDistFlags = []cli.Flag{
 	},
 	&cli.Int64Flag{
 		Name:    "dist-limit",
-		Usage:   "Limit the amount of distance to traverse",
+		Usage:   "Limit the amount of distance to traverse. 0 for unlimited.",
 		Aliases: []string{"dl"},
 		Value:   5000,
 	},
@@ -48,15 +60,20 @@ func adsDistAction(ctx context.Context, cmd *cli.Command) error {
 		return fmt.Errorf("bad pub-addr-info: %w", err)
 	}
 
-	startCid, err := cid.Decode(cmd.String("start"))
-	if err != nil {
-		return fmt.Errorf("bad start cid: %w", err)
+	startArgs := cmd.StringSlice("start")
+	startCids := make([]cid.Cid, len(startArgs))
+	for i, startStr := range startArgs {
+		startCids[i], err = cid.Decode(startStr)
+		if err != nil {
+			return fmt.Errorf("bad start cid: %w", err)
+		}
 	}
 
 	adDist, err := dtrack.NewAdDistance(dtrack.WithDepthLimit(cmd.Int64("dist-limit")))
 	if err != nil {
 		return err
 	}
+	defer adDist.Close()
 
 	var endStr string
 	var endCid cid.Cid
@@ -70,15 +87,90 @@ func adsDistAction(ctx context.Context, cmd *cli.Command) error {
 		endStr = "head"
 	}
 
-	adCount, _, err := adDist.Get(ctx, *addrInfo, startCid, endCid)
+	if cmd.Bool("common-ancestor") {
+		if len(startCids) != 1 {
+			return errors.New("--common-ancestor requires exactly one start cid")
+		}
+		return showCommonAncestor(ctx, cmd, adDist, *addrInfo, startCids[0], endCid)
+	}
+
+	if len(startCids) > 1 {
+		return showManyDist(ctx, cmd, adDist, *addrInfo, startCids, endCid, endStr)
+	}
+	startCid := startCids[0]
+
+	dist, err := adDist.Get(ctx, *addrInfo, startCid, endCid)
 	if err != nil {
+		if errors.Is(err, dtrack.ErrNotAncestor) {
+			return fmt.Errorf("start %s is not an ancestor of %s: reached start of chain at %s after %d advertisements",
+				startCid, endStr, dist.StopCid, dist.Count)
+		}
 		return err
 	}
 
 	if cmd.Bool("quiet") {
-		fmt.Println(adCount)
+		if dist.Exceeded {
+			fmt.Printf(">=%d %s\n", dist.Count, dist.StopCid)
+		} else {
+			fmt.Println(dist.Count)
+		}
+	} else if dist.Exceeded {
+		fmt.Printf("Distance from %s to %s is at least %d\n", startCid, endStr, dist.Count)
+		fmt.Println("Distance limit reached at advertisement", dist.StopCid)
+		fmt.Println("Start may not be an ancestor of end. Use --common-ancestor to check.")
 	} else {
-		fmt.Printf("Distance from %s to %s is %d\n", startCid, endStr, adCount)
+		fmt.Printf("Distance from %s to %s is %d\n", startCid, endStr, dist.Count)
+	}
+	return nil
+}
+
+func showManyDist(ctx context.Context, cmd *cli.Command, adDist *dtrack.AdDistance, addrInfo peer.AddrInfo, startCids []cid.Cid, endCid cid.Cid, endStr string) error {
+	dists, err := adDist.GetMany(ctx, addrInfo, startCids, endCid)
+	if err != nil {
+		return err
+	}
+
+	quiet := cmd.Bool("quiet")
+	if !quiet {
+		fmt.Printf("Distances to %s (%s):\n", endStr, dists[0].Head)
+	}
+	for i, dist := range dists {
+		if quiet {
+			switch {
+			case dist.NotAncestor:
+				fmt.Println(startCids[i], "none")
+			case dist.Exceeded:
+				fmt.Printf("%s >=%d %s\n", startCids[i], dist.Count, dist.StopCid)
+			default:
+				fmt.Println(startCids[i], dist.Count)
+			}
+			continue
+		}
+		fmt.Printf("  %s: %s\n", startCids[i], dist)
+	}
+	return nil
+}
+
+func showCommonAncestor(ctx context.Context, cmd *cli.Command, adDist *dtrack.AdDistance, addrInfo peer.AddrInfo, startCid, endCid cid.Cid) error {
+	fork, err := adDist.CommonAncestor(ctx, addrInfo, startCid, endCid)
+	if err != nil {
+		return err
+	}
+
+	if fork.Ancestor == cid.Undef {
+		if fork.Disjoint {
+			return fmt.Errorf("chains from %s and %s have no common advertisement", fork.HeadA, fork.HeadB)
+		}
+		return fmt.Errorf("no common advertisement within limit %d: walked %d from %s and %d from %s",
+			cmd.Int64("dist-limit"), fork.DistA, fork.HeadA, fork.DistB, fork.HeadB)
+	}
+
+	if cmd.Bool("quiet") {
+		fmt.Println(fork.Ancestor, fork.DistA, fork.DistB)
+		return nil
 	}
+	fmt.Println("Common ancestor:", fork.Ancestor)
+	fmt.Printf("  Distance from start %s: %d\n", fork.HeadA, fork.DistA)
+	fmt.Printf("  Distance from end   %s: %d\n", fork.HeadB, fork.DistB)
 	return nil
 }
@@ -0,0 +1,13 @@
+package bench
+
+import (
+	"github.com/urfave/cli/v3"
+)
+
+var BenchCmd = &cli.Command{
+	Name:  "bench",
+	Usage: "Benchmark indexer query performance",
+	Commands: []*cli.Command{
+		benchFindSubCmd,
+	},
+}
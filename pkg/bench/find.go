@@ -0,0 +1,457 @@
+package bench
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ipni/go-libipni/apierror"
+	"github.com/ipni/go-libipni/find/client"
+	"github.com/ipni/go-libipni/find/model"
+	"github.com/ipni/ipni-cli/pkg/find"
+	"github.com/montanaflynn/stats"
+	"github.com/multiformats/go-multihash"
+	"github.com/urfave/cli/v3"
+)
+
+var benchFindSubCmd = &cli.Command{
+	Name:  "find",
+	Usage: "Measure find query performance of one or more indexers",
+	Description: `Replay a list of CIDs or multihashes as find queries against each indexer, and report latency percentiles, errors, hit/miss ratio, and throughput. Keys are read from the file given by --input, or from stdin, one per line.
+
+Each indexer is benchmarked in turn, using the same find clients as the find command. Each worker looks up one key at a time, and all workers share the rate limit. If --duration is given, the key list is replayed until the duration has elapsed. Otherwise each key is looked up once.
+
+Example usage:
+	ipni bench find -i https://cid.contact -i https://indexer.example.com --input cids.txt --concurrency 16 --rate 500 --duration 1m`,
+	Flags:  benchFindFlags,
+	Action: benchFindAction,
+}
+
+var benchFindFlags = []cli.Flag{
+	&cli.StringSliceFlag{
+		Name:     "indexer",
+		Usage:    "URL of indexer to benchmark, multiple OK",
+		Aliases:  []string{"i"},
+		Required: true,
+	},
+	&cli.StringFlag{
+		Name:    "dhstore",
+		Usage:   "URL of double-hashed (reader-private) store to benchmark, using indexers as provider info sources - assumes --private.",
+		Aliases: []string{"dhs"},
+	},
+	&cli.BoolFlag{
+		Name:  "private",
+		Usage: "Use reader-privacy for queries",
+	},
+	&cli.StringFlag{
+		Name:    "input",
+		Usage:   "File to read CIDs or multihashes from, one per line. Reads stdin if not specified.",
+		Aliases: []string{"f"},
+	},
+	&cli.IntFlag{
+		Name:  "concurrency",
+		Usage: "Number of concurrent workers",
+		Value: 8,
+	},
+	&cli.Float64Flag{
+		Name:  "rate",
+		Usage: "Maximum number of queries per second. 0 for unlimited.",
+	},
+	&cli.DurationFlag{
+		Name:    "duration",
+		Usage:   "Replay keys until this duration has elapsed. 0 to look up each key once.",
+		Aliases: []string{"d"},
+	},
+	&cli.DurationFlag{
+		Name:  "timeout",
+		Usage: "HTTP timeout for each query",
+		Value: 30 * time.Second,
+	},
+	&cli.BoolFlag{
+		Name:  "json",
+		Usage: "Output report as JSON",
+	},
+}
+
+// Report is the result of benchmarking one indexer.
+type Report struct {
+	Indexer     string         `json:"indexer"`
+	Private     bool           `json:"private"`
+	Concurrency int            `json:"concurrency"`
+	Rate        float64        `json:"rate"`
+	ElapsedSec  float64        `json:"elapsed_s"`
+	Queries     int            `json:"queries"`
+	Hits        int            `json:"hits"`
+	Misses      int            `json:"misses"`
+	Errors      int            `json:"errors"`
+	HitRatio    float64        `json:"hit_ratio"`
+	Throughput  float64        `json:"queries_per_second"`
+	Latency     LatencyReport  `json:"latency_ms"`
+	ErrorKinds  map[string]int `json:"error_kinds,omitempty"`
+}
+
+// LatencyReport summarizes query latencies in milliseconds.
+type LatencyReport struct {
+	Min  float64 `json:"min"`
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P95  float64 `json:"p95"`
+	P99  float64 `json:"p99"`
+	Max  float64 `json:"max"`
+}
+
+// benchConfig is the benchmark configuration given by the command flags.
+type benchConfig struct {
+	indexers    []string
+	dhstore     string
+	private     bool
+	concurrency int
+	rate        float64
+	duration    time.Duration
+	timeout     time.Duration
+}
+
+func newBenchConfig(cmd *cli.Command) benchConfig {
+	return benchConfig{
+		indexers:    cmd.StringSlice("indexer"),
+		dhstore:     cmd.String("dhstore"),
+		private:     cmd.Bool("private") || cmd.String("dhstore") != "",
+		concurrency: max(cmd.Int("concurrency"), 1),
+		rate:        cmd.Float64("rate"),
+		duration:    cmd.Duration("duration"),
+		timeout:     cmd.Duration("timeout"),
+	}
+}
+
+// recorder records the outcome of every query made through a finder.
+type recorder struct {
+	client.Finder
+	ctx        context.Context
+	limiter    *find.RateLimiter
+	mutex      sync.Mutex
+	latencies  []float64
+	hits       int
+	misses     int
+	errorKinds map[string]int
+}
+
+func (r *recorder) Find(ctx context.Context, mh multihash.Multihash) (*model.FindResponse, error) {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	resp, err := r.Finder.Find(ctx, mh)
+	elapsed := time.Since(start)
+
+	if r.ctx.Err() != nil {
+		// Benchmark ended while query in progress, so do not count it.
+		return resp, err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.latencies = append(r.latencies, float64(elapsed)/float64(time.Millisecond))
+	switch {
+	case err != nil:
+		var ae *apierror.Error
+		if errors.As(err, &ae) && ae.Status() == http.StatusNotFound {
+			r.misses++
+			return resp, err
+		}
+		r.errorKinds[errorKind(err)]++
+	case resp == nil || len(resp.MultihashResults) == 0:
+		r.misses++
+	default:
+		r.hits++
+	}
+	return resp, err
+}
+
+// statusError is the status of an unsuccessful HTTP response.
+type statusError struct {
+	status int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%d %s", e.status, http.StatusText(e.status))
+}
+
+// statusTransport returns an unsuccessful HTTP response as a statusError, so
+// that query errors can be grouped by status code. The find clients do not
+// include the status code in the errors they return. A not found response is
+// returned to the clients, since they treat it as no results.
+type statusTransport struct {
+	http.RoundTripper
+}
+
+func (t statusTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.RoundTripper.RoundTrip(req)
+	if err != nil || resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusNotFound {
+		return resp, err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return nil, &statusError{status: resp.StatusCode}
+}
+
+// errorKind returns a short description of the kind of error for grouping.
+func errorKind(err error) string {
+	var status int
+	var ae *apierror.Error
+	var se *statusError
+	switch {
+	case errors.As(err, &se):
+		status = se.status
+	case errors.As(err, &ae):
+		status = ae.Status()
+	}
+	if status != 0 {
+		return fmt.Sprintf("http status %d %s", status, http.StatusText(status))
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return "timeout"
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return "timeout"
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return "connection refused"
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return "network: " + opErr.Op
+	}
+	return "other"
+}
+
+func benchFindAction(ctx context.Context, cmd *cli.Command) error {
+	cfg := newBenchConfig(cmd)
+	mhs, err := readKeys(cmd.String("input"))
+	if err != nil {
+		return err
+	}
+	if len(mhs) == 0 {
+		return errors.New("no keys to look up")
+	}
+
+	var targets []string
+	if cfg.dhstore != "" {
+		targets = []string{cfg.dhstore}
+	} else {
+		targets = cfg.indexers
+	}
+
+	reports := make([]*Report, 0, len(targets))
+	for _, target := range targets {
+		if !cmd.Bool("json") {
+			fmt.Fprintln(os.Stderr, "Benchmarking", target, "...")
+		}
+		report, err := benchFind(ctx, cfg, target, mhs)
+		if err != nil {
+			return fmt.Errorf("cannot benchmark %s: %w", target, err)
+		}
+		reports = append(reports, report)
+	}
+
+	if cmd.Bool("json") {
+		data, err := json.MarshalIndent(reports, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+	for _, report := range reports {
+		printReport(report)
+	}
+	return nil
+}
+
+func newFinder(cfg benchConfig, target string) (client.Finder, error) {
+	httpClient := client.WithClient(&http.Client{
+		Timeout:   cfg.timeout,
+		Transport: statusTransport{http.DefaultTransport},
+	})
+	if cfg.private {
+		providersURLs := cfg.indexers
+		if cfg.dhstore == "" {
+			providersURLs = []string{target}
+		}
+		return client.NewDHashClient(
+			httpClient,
+			client.WithProvidersURL(providersURLs...),
+			client.WithDHStoreURL(target),
+		)
+	}
+	return client.New(target, httpClient)
+}
+
+func benchFind(ctx context.Context, cfg benchConfig, target string, mhs []multihash.Multihash) (*Report, error) {
+	finder, err := newFinder(cfg, target)
+	if err != nil {
+		return nil, err
+	}
+
+	duration := cfg.duration
+
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if duration > 0 {
+		runCtx, cancel = context.WithTimeout(runCtx, duration)
+		defer cancel()
+	}
+
+	rec := &recorder{
+		Finder:     finder,
+		ctx:        runCtx,
+		limiter:    find.NewRateLimiter(cfg.rate),
+		errorKinds: make(map[string]int),
+	}
+	defer rec.limiter.Stop()
+
+	keys := make(chan multihash.Multihash)
+	go func() {
+		defer close(keys)
+		for {
+			for _, mh := range mhs {
+				select {
+				case keys <- mh:
+				case <-runCtx.Done():
+					return
+				}
+			}
+			if duration == 0 {
+				return
+			}
+		}
+	}()
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for range cfg.concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for mh := range keys {
+				if runCtx.Err() != nil {
+					return
+				}
+				rec.Find(runCtx, mh)
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	// Only an interrupt, not the end of the benchmark duration, is an error.
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	return makeReport(cfg, target, rec, elapsed), nil
+}
+
+func makeReport(cfg benchConfig, target string, rec *recorder, elapsed time.Duration) *Report {
+	report := &Report{
+		Indexer:     target,
+		Private:     cfg.private,
+		Concurrency: cfg.concurrency,
+		Rate:        cfg.rate,
+		ElapsedSec:  elapsed.Seconds(),
+		Queries:     len(rec.latencies),
+		Hits:        rec.hits,
+		Misses:      rec.misses,
+		ErrorKinds:  rec.errorKinds,
+	}
+	for _, n := range rec.errorKinds {
+		report.Errors += n
+	}
+	if answered := report.Hits + report.Misses; answered != 0 {
+		report.HitRatio = float64(report.Hits) / float64(answered)
+	}
+	if elapsed > 0 {
+		report.Throughput = float64(report.Queries) / elapsed.Seconds()
+	}
+
+	if len(rec.latencies) != 0 {
+		data := stats.Float64Data(rec.latencies)
+		report.Latency.Min, _ = data.Min()
+		report.Latency.Mean, _ = data.Mean()
+		report.Latency.P50, _ = data.Percentile(50)
+		report.Latency.P90, _ = data.Percentile(90)
+		report.Latency.P95, _ = data.Percentile(95)
+		report.Latency.P99, _ = data.Percentile(99)
+		report.Latency.Max, _ = data.Max()
+	}
+	return report
+}
+
+func printReport(r *Report) {
+	fmt.Println("Indexer:", r.Indexer)
+	if r.Private {
+		fmt.Println("  🔒 Reader privacy enabled")
+	}
+	fmt.Printf("  Concurrency: %d, Rate limit: %s\n", r.Concurrency, rateString(r.Rate))
+	fmt.Printf("  Queries: %d in %.1fs (%.1f/s)\n", r.Queries, r.ElapsedSec, r.Throughput)
+	fmt.Printf("  Hits: %d, Misses: %d, Errors: %d, Hit ratio: %.1f%%\n", r.Hits, r.Misses, r.Errors, 100*r.HitRatio)
+	fmt.Println("  Latency (ms):")
+	fmt.Printf("    min: %.1f, mean: %.1f, max: %.1f\n", r.Latency.Min, r.Latency.Mean, r.Latency.Max)
+	fmt.Printf("    p50: %.1f, p90: %.1f, p95: %.1f, p99: %.1f\n", r.Latency.P50, r.Latency.P90, r.Latency.P95, r.Latency.P99)
+	if len(r.ErrorKinds) != 0 {
+		fmt.Println("  Errors:")
+		for _, kind := range slices.Sorted(maps.Keys(r.ErrorKinds)) {
+			fmt.Printf("    %s: %d\n", kind, r.ErrorKinds[kind])
+		}
+	}
+}
+
+func rateString(rate float64) string {
+	if rate <= 0 {
+		return "none"
+	}
+	return fmt.Sprintf("%g/s", rate)
+}
+
+// readKeys reads CIDs or multihashes, one per line, from the named file or
+// from stdin if no file is named.
+func readKeys(fileName string) ([]multihash.Multihash, error) {
+	r, err := find.OpenInput(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var mhs []multihash.Multihash
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			// Skip empty lines.
+			continue
+		}
+		m, err := find.ParseKey(line)
+		if err != nil {
+			return nil, err
+		}
+		mhs = append(mhs, m)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return mhs, nil
+}
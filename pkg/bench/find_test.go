@@ -0,0 +1,173 @@
+package bench
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/ipni/go-libipni/apierror"
+	"github.com/ipni/go-libipni/find/model"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func testMultihash(t *testing.T, data string) multihash.Multihash {
+	mh, err := multihash.Sum([]byte(data), multihash.SHA2_256, -1)
+	require.NoError(t, err)
+	return mh
+}
+
+// newIndexer starts an indexer that answers each multihash with the status
+// given in statuses, and a provider result when the status is OK.
+func newIndexer(t *testing.T, statuses map[string]int) *httptest.Server {
+	pid, err := peer.Decode("12D3KooWE8yt84RVwW3sFcd6WMjbUdWrZer2YtT4dmtj3dHdahSZ")
+	require.NoError(t, err)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mhStr := strings.TrimPrefix(r.URL.Path, "/multihash/")
+		status, ok := statuses[mhStr]
+		if !ok {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		if status != http.StatusOK {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		mh, err := multihash.FromB58String(mhStr)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		data, err := model.MarshalFindResponse(&model.FindResponse{
+			MultihashResults: []model.MultihashResult{{
+				Multihash: mh,
+				ProviderResults: []model.ProviderResult{{
+					ContextID: []byte("ctx"),
+					Metadata:  []byte{0x80, 0x80, 0x40},
+					Provider:  &peer.AddrInfo{ID: pid},
+				}},
+			}},
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestBenchFind(t *testing.T) {
+	hit := testMultihash(t, "hit")
+	miss := testMultihash(t, "miss")
+	limited := testMultihash(t, "limited")
+	broken := testMultihash(t, "broken")
+	srv := newIndexer(t, map[string]int{
+		hit.B58String():     http.StatusOK,
+		miss.B58String():    http.StatusNotFound,
+		limited.B58String(): http.StatusTooManyRequests,
+		broken.B58String():  http.StatusInternalServerError,
+	})
+
+	cfg := benchConfig{
+		indexers:    []string{srv.URL},
+		concurrency: 2,
+		timeout:     5 * time.Second,
+	}
+	report, err := benchFind(t.Context(), cfg, srv.URL, []multihash.Multihash{hit, miss, limited, broken})
+	require.NoError(t, err)
+	require.Equal(t, srv.URL, report.Indexer)
+	require.Equal(t, 2, report.Concurrency)
+	require.Equal(t, 4, report.Queries)
+	require.Equal(t, 1, report.Hits)
+	require.Equal(t, 1, report.Misses)
+	require.Equal(t, 2, report.Errors)
+	require.Equal(t, 0.5, report.HitRatio)
+	require.Equal(t, map[string]int{
+		"http status 429 Too Many Requests":     1,
+		"http status 500 Internal Server Error": 1,
+	}, report.ErrorKinds)
+	require.Positive(t, report.Latency.Max)
+	require.GreaterOrEqual(t, report.Latency.Max, report.Latency.Min)
+}
+
+func TestBenchFindDurationAndRate(t *testing.T) {
+	hit := testMultihash(t, "hit")
+	srv := newIndexer(t, map[string]int{hit.B58String(): http.StatusOK})
+
+	// Keys are replayed until the duration elapses, at no more than the rate.
+	cfg := benchConfig{
+		indexers:    []string{srv.URL},
+		concurrency: 4,
+		rate:        50,
+		duration:    300 * time.Millisecond,
+		timeout:     5 * time.Second,
+	}
+	report, err := benchFind(t.Context(), cfg, srv.URL, []multihash.Multihash{hit})
+	require.NoError(t, err)
+	require.Greater(t, report.Queries, 1)
+	require.LessOrEqual(t, report.Queries, 16)
+	require.Equal(t, report.Queries, report.Hits)
+	require.Zero(t, report.Errors)
+}
+
+func TestBenchFindInterrupted(t *testing.T) {
+	hit := testMultihash(t, "hit")
+	srv := newIndexer(t, map[string]int{hit.B58String(): http.StatusOK})
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	cfg := benchConfig{
+		indexers:    []string{srv.URL},
+		concurrency: 1,
+		duration:    time.Minute,
+		timeout:     5 * time.Second,
+	}
+	_, err := benchFind(ctx, cfg, srv.URL, []multihash.Multihash{hit})
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestErrorKind(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{
+			err:  fmt.Errorf("get failed: %w", &statusError{status: http.StatusBadGateway}),
+			want: "http status 502 Bad Gateway",
+		},
+		{
+			err:  apierror.New(errors.New("unavailable"), http.StatusServiceUnavailable),
+			want: "http status 503 Service Unavailable",
+		},
+		{
+			err:  fmt.Errorf("find failed: %w", context.DeadlineExceeded),
+			want: "timeout",
+		},
+		{
+			err:  &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED},
+			want: "connection refused",
+		},
+		{
+			err:  &net.OpError{Op: "read", Err: syscall.ECONNRESET},
+			want: "network: read",
+		},
+		{
+			err:  errors.New("something else"),
+			want: "other",
+		},
+	}
+	for _, tc := range tests {
+		require.Equal(t, tc.want, errorKind(tc.err), "error: %s", tc.err)
+	}
+}
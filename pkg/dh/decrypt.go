@@ -0,0 +1,252 @@
+package dh
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/ipni/go-libipni/apierror"
+	"github.com/ipni/go-libipni/dhash"
+	"github.com/ipni/go-libipni/find/model"
+	"github.com/ipni/ipni-cli/pkg/metaproto"
+	b58 "github.com/mr-tron/base58/base58"
+	"github.com/multiformats/go-multihash"
+	"github.com/urfave/cli/v3"
+)
+
+// nonceLen is the length of the nonce that prefixes encrypted values.
+const nonceLen = 12
+
+var dhDecryptSubCmd = &cli.Command{
+	Name:  "decrypt",
+	Usage: "Decrypt encrypted value keys and metadata using the original multihash",
+	Description: `Decrypt each encrypted value key using the original multihash, and show the provider ID and context ID that the value key is made from. Encrypted value keys and metadata are given in base64, as returned by a dhstore, or in base58. Encrypted metadata is decrypted using each value key, and the metadata protocols are shown.
+
+If --dhstore is specified, then any encrypted value keys or metadata that are not given are fetched from the dhstore, and each lookup URL is shown. This shows each step of a private lookup, so that a failed lookup can be traced to the key derivation or to the server.
+
+Example usage:
+	ipni dh decrypt --cid bafybeigvgzoolc3drupxhlevdp2ugqcrbcsqfmcek2zxiw5wctk3xjpjwy --dhstore https://dhstore.example.com
+	ipni dh decrypt --mh QmcgwdNjFQVhKt6aWWtSPgdLbNvULRoFMU6CCYwHsN3EEH --evk <base64 blob> --metadata <base64 blob>`,
+	Flags: append([]cli.Flag{
+		&cli.StringSliceFlag{
+			Name:  "evk",
+			Usage: "Encrypted value key, multiple OK",
+		},
+		&cli.StringSliceFlag{
+			Name:  "metadata",
+			Usage: "Encrypted metadata, multiple OK",
+		},
+		&cli.StringFlag{
+			Name:    "dhstore",
+			Usage:   "URL of dhstore to fetch encrypted value keys and metadata from",
+			Aliases: []string{"dhs"},
+		},
+	}, keyFlags...),
+	Action: dhDecryptAction,
+}
+
+func dhDecryptAction(ctx context.Context, cmd *cli.Command) error {
+	mh, err := getMultihash(cmd, true)
+	if err != nil {
+		return err
+	}
+
+	var dhsURL *url.URL
+	if cmd.String("dhstore") != "" {
+		dhsURL, err = url.Parse(cmd.String("dhstore"))
+		if err != nil {
+			return fmt.Errorf("bad dhstore url: %w", err)
+		}
+	}
+
+	evks, err := decodeBlobs(cmd.StringSlice("evk"))
+	if err != nil {
+		return fmt.Errorf("bad encrypted value key: %w", err)
+	}
+	encMetas, err := decodeBlobs(cmd.StringSlice("metadata"))
+	if err != nil {
+		return fmt.Errorf("bad encrypted metadata: %w", err)
+	}
+
+	dhmh := dhash.SecondMultihash(mh)
+	fmt.Println("Multihash:       ", mh.B58String())
+	fmt.Println("Second multihash:", dhmh.B58String())
+
+	if len(evks) == 0 {
+		if dhsURL == nil {
+			return errors.New("no encrypted value keys: specify --evk or --dhstore")
+		}
+		u := dhsURL.JoinPath("encrypted", "multihash", dhmh.B58String())
+		fmt.Println("Lookup URL:      ", u)
+		evks, err = fetchValueKeys(ctx, u)
+		if err != nil {
+			return fmt.Errorf("cannot get encrypted value keys from dhstore: %w", err)
+		}
+		if len(evks) == 0 {
+			fmt.Println("No encrypted value keys for second multihash")
+			return nil
+		}
+	}
+
+	var failed int
+	for i, candidates := range evks {
+		fmt.Println("Encrypted value key", i+1)
+		if !showValueKey(ctx, mh, candidates, encMetas, dhsURL) {
+			failed++
+		}
+	}
+	if failed != 0 {
+		return fmt.Errorf("cannot decrypt %d of %d value keys", failed, len(evks))
+	}
+	return nil
+}
+
+// showValueKey decrypts the encrypted value key and shows what it contains,
+// and the metadata that it decrypts. Returns false if the value key cannot be
+// decrypted.
+func showValueKey(ctx context.Context, mh multihash.Multihash, candidates [][]byte, encMetas [][][]byte, dhsURL *url.URL) bool {
+	var vk []byte
+	var err error
+	for _, evk := range candidates {
+		vk, err = decryptValueKey(evk, mh)
+		if err == nil {
+			fmt.Println("    Encrypted:", base64.StdEncoding.EncodeToString(evk))
+			break
+		}
+	}
+	if err != nil {
+		fmt.Println("    Error: cannot decrypt value key:", err)
+		fmt.Println("    The value key was not encrypted using this multihash")
+		return false
+	}
+	fmt.Println("    Value key:", base64.StdEncoding.EncodeToString(vk))
+
+	pid, ctxID, err := dhash.SplitValueKey(vk)
+	if err != nil {
+		fmt.Println("    Error: cannot split value key:", err)
+		return true
+	}
+	fmt.Println("    Provider:", pid)
+	fmt.Println("    ContextID:", base64.StdEncoding.EncodeToString(ctxID))
+
+	hvk := b58.Encode(dhash.SHA256(vk, nil))
+	fmt.Println("    Metadata key:", hvk)
+
+	if len(encMetas) == 0 && dhsURL != nil {
+		u := dhsURL.JoinPath("metadata", hvk)
+		fmt.Println("    Metadata URL:", u)
+		encMeta, err := fetchMetadata(ctx, u)
+		if err != nil {
+			fmt.Println("    Error: cannot get metadata from dhstore:", err)
+			return true
+		}
+		if encMeta == nil {
+			fmt.Println("    Metadata: not found")
+			return true
+		}
+		encMetas = [][][]byte{{encMeta}}
+	}
+
+	for _, metaCandidates := range encMetas {
+		for _, encMeta := range metaCandidates {
+			meta, err := dhash.DecryptMetadata(encMeta, vk)
+			if err != nil {
+				continue
+			}
+			fmt.Println("    Metadata:", base64.StdEncoding.EncodeToString(meta))
+			fmt.Println("    Protocols:", metaproto.String(meta))
+			return true
+		}
+	}
+	if len(encMetas) != 0 {
+		fmt.Println("    Metadata: none decrypted by this value key")
+	}
+	return true
+}
+
+func decryptValueKey(evk []byte, mh multihash.Multihash) ([]byte, error) {
+	if len(evk) <= nonceLen {
+		return nil, errors.New("encrypted value key too short")
+	}
+	return dhash.DecryptValueKey(evk, mh)
+}
+
+func decodeBlobs(values []string) ([][][]byte, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	blobs := make([][][]byte, len(values))
+	for i, s := range values {
+		var err error
+		blobs[i], err = decodeBlob(s)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return blobs, nil
+}
+
+func fetchValueKeys(ctx context.Context, u *url.URL) ([][][]byte, error) {
+	body, err := httpGet(ctx, u)
+	if err != nil || body == nil {
+		return nil, err
+	}
+	var findResponse model.FindResponse
+	if err = json.Unmarshal(body, &findResponse); err != nil {
+		return nil, err
+	}
+	var evks [][][]byte
+	for _, emr := range findResponse.EncryptedMultihashResults {
+		for _, evk := range emr.EncryptedValueKeys {
+			evks = append(evks, [][]byte{evk})
+		}
+	}
+	return evks, nil
+}
+
+func fetchMetadata(ctx context.Context, u *url.URL) ([]byte, error) {
+	body, err := httpGet(ctx, u)
+	if err != nil || body == nil {
+		return nil, err
+	}
+	var metaResponse struct {
+		EncryptedMetadata []byte `json:"EncryptedMetadata"`
+	}
+	if err = json.Unmarshal(body, &metaResponse); err != nil {
+		return nil, err
+	}
+	return metaResponse.EncryptedMetadata, nil
+}
+
+// httpGet gets a JSON response body from the URL. A nil body and nil error
+// are returned if the server responds with not found.
+func httpGet(ctx context.Context, u *url.URL) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Accept", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, apierror.FromResponse(resp.StatusCode, body)
+	}
+	return body, nil
+}
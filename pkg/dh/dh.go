@@ -0,0 +1,79 @@
+// Package dh provides commands to compute and decode the double-hashed values
+// used for reader-private lookups, so that private lookups can be debugged
+// without relying on a dhstore server.
+package dh
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	b58 "github.com/mr-tron/base58/base58"
+	"github.com/multiformats/go-multihash"
+	"github.com/urfave/cli/v3"
+)
+
+var DHCmd = &cli.Command{
+	Name:  "dh",
+	Usage: "Compute and decrypt double-hashed values used for reader-private lookups",
+	Commands: []*cli.Command{
+		dhHashSubCmd,
+		dhValueKeySubCmd,
+		dhDecryptSubCmd,
+	},
+}
+
+var keyFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:  "mh",
+		Usage: "Original multihash, in base58",
+	},
+	&cli.StringFlag{
+		Name:  "cid",
+		Usage: "CID whose multihash is the original multihash",
+	},
+}
+
+// getMultihash returns the original multihash given by --mh or --cid.
+func getMultihash(cmd *cli.Command, required bool) (multihash.Multihash, error) {
+	mhStr := cmd.String("mh")
+	cidStr := cmd.String("cid")
+	switch {
+	case mhStr != "" && cidStr != "":
+		return nil, errors.New("only one of --mh or --cid may be specified")
+	case mhStr != "":
+		mh, err := multihash.FromB58String(mhStr)
+		if err != nil {
+			return nil, fmt.Errorf("bad multihash %s: %w", mhStr, err)
+		}
+		return mh, nil
+	case cidStr != "":
+		c, err := cid.Decode(cidStr)
+		if err != nil {
+			return nil, fmt.Errorf("bad cid %s: %w", cidStr, err)
+		}
+		return c.Hash(), nil
+	}
+	if required {
+		return nil, errors.New("one of --mh or --cid must be specified")
+	}
+	return nil, nil
+}
+
+// decodeBlob returns the possible decodings of an encrypted value given as
+// base64 or base58. A base58 string may also be valid base64, so both
+// decodings are returned and the caller uses the one that decrypts.
+func decodeBlob(s string) ([][]byte, error) {
+	var blobs [][]byte
+	if b, err := base64.StdEncoding.DecodeString(s); err == nil {
+		blobs = append(blobs, b)
+	}
+	if b, err := b58.Decode(s); err == nil {
+		blobs = append(blobs, b)
+	}
+	if len(blobs) == 0 {
+		return nil, fmt.Errorf("value is not base64 or base58: %s", s)
+	}
+	return blobs, nil
+}
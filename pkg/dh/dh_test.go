@@ -0,0 +1,73 @@
+package dh_test
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ipni/go-libipni/dhash"
+	"github.com/ipni/go-libipni/find/model"
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/ipni/ipni-cli/pkg/dh"
+	"github.com/libp2p/go-libp2p/core/peer"
+	b58 "github.com/mr-tron/base58/base58"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+const testPeerID = "12D3KooWPNbkEgjdBNeaCGpsgCrPRETe4uBZf1ShFXStobdN18ys"
+
+func TestDecrypt(t *testing.T) {
+	mh, err := multihash.Sum([]byte("content"), multihash.SHA2_256, -1)
+	require.NoError(t, err)
+	otherMh, err := multihash.Sum([]byte("other"), multihash.SHA2_256, -1)
+	require.NoError(t, err)
+	pid, err := peer.Decode(testPeerID)
+	require.NoError(t, err)
+
+	vk := dhash.CreateValueKey(pid, []byte("ctx"))
+	evk, err := dhash.EncryptValueKey(vk, mh)
+	require.NoError(t, err)
+	md := metadata.Default.New(metadata.Bitswap{})
+	meta, err := md.MarshalBinary()
+	require.NoError(t, err)
+	encMeta, err := dhash.EncryptMetadata(meta, vk)
+	require.NoError(t, err)
+
+	findPath := "/encrypted/multihash/" + dhash.SecondMultihash(mh).B58String()
+	metaPath := "/metadata/" + b58.Encode(dhash.SHA256(vk, nil))
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.Path)
+		switch r.URL.Path {
+		case findPath:
+			json.NewEncoder(w).Encode(model.FindResponse{
+				EncryptedMultihashResults: []model.EncryptedMultihashResult{{
+					Multihash:          dhash.SecondMultihash(mh),
+					EncryptedValueKeys: [][]byte{evk},
+				}},
+			})
+		case metaPath:
+			json.NewEncoder(w).Encode(map[string][]byte{"EncryptedMetadata": encMeta})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	err = dh.DHCmd.Run(t.Context(), []string{"dh", "decrypt", "--mh", mh.B58String(), "--dhstore", srv.URL})
+	require.NoError(t, err)
+	require.Equal(t, []string{findPath, metaPath}, requests)
+
+	evkStr := base64.StdEncoding.EncodeToString(evk)
+	err = dh.DHCmd.Run(t.Context(), []string{"dh", "decrypt", "--mh", mh.B58String(), "--evk", evkStr, "--evk", b58.Encode(evk)})
+	require.NoError(t, err)
+
+	err = dh.DHCmd.Run(t.Context(), []string{"dh", "decrypt", "--mh", otherMh.B58String(), "--evk", evkStr})
+	require.ErrorContains(t, err, "cannot decrypt 1 of 1 value keys")
+
+	err = dh.DHCmd.Run(t.Context(), []string{"dh", "decrypt", "--mh", mh.B58String()})
+	require.ErrorContains(t, err, "specify --evk or --dhstore")
+}
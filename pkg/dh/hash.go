@@ -0,0 +1,89 @@
+package dh
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/ipni/go-libipni/dhash"
+	"github.com/libp2p/go-libp2p/core/peer"
+	b58 "github.com/mr-tron/base58/base58"
+	"github.com/urfave/cli/v3"
+)
+
+var dhHashSubCmd = &cli.Command{
+	Name:  "hash",
+	Usage: "Compute the second hash of a multihash",
+	Description: `Compute the second multihash that a dhstore is queried with for the original multihash, and show the dhstore lookup path.
+
+Example usage:
+	ipni dh hash --cid bafybeigvgzoolc3drupxhlevdp2ugqcrbcsqfmcek2zxiw5wctk3xjpjwy`,
+	Flags:  keyFlags,
+	Action: dhHashAction,
+}
+
+var dhValueKeySubCmd = &cli.Command{
+	Name:  "valuekey",
+	Usage: "Derive the value key for a provider and context ID",
+	Description: `Derive the value key that a dhstore stores, encrypted, for each multihash advertised by a provider with a context ID. Show the value key and the key that the encrypted metadata is stored under. If a multihash is given, also show the value key encrypted using that multihash. The encryption uses a random nonce, so the encrypted value key is different each time.
+
+Example usage:
+	ipni dh valuekey --provider 12D3KooWPNbkEgjdBNeaCGpsgCrPRETe4uBZf1ShFXStobdN18ys --context-id AXESIBRiv2zb1O3q1QwDCqt5VrjTJExA5RbDh2pK3j/Z2vte --cid bafybeigvgzoolc3drupxhlevdp2ugqcrbcsqfmcek2zxiw5wctk3xjpjwy`,
+	Flags: append([]cli.Flag{
+		&cli.StringFlag{
+			Name:     "provider",
+			Usage:    "Provider's peer ID",
+			Aliases:  []string{"pid"},
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:    "context-id",
+			Usage:   "Context ID, in base64",
+			Aliases: []string{"ctxid"},
+		},
+	}, keyFlags...),
+	Action: dhValueKeyAction,
+}
+
+func dhHashAction(ctx context.Context, cmd *cli.Command) error {
+	mh, err := getMultihash(cmd, true)
+	if err != nil {
+		return err
+	}
+	dhmh := dhash.SecondMultihash(mh)
+
+	fmt.Println("Multihash:       ", mh.B58String())
+	fmt.Println("Second multihash:", dhmh.B58String())
+	fmt.Println("Lookup path:     ", "/encrypted/multihash/"+dhmh.B58String())
+	return nil
+}
+
+func dhValueKeyAction(ctx context.Context, cmd *cli.Command) error {
+	pid, err := peer.Decode(cmd.String("provider"))
+	if err != nil {
+		return fmt.Errorf("bad provider id: %w", err)
+	}
+	ctxID, err := base64.StdEncoding.DecodeString(cmd.String("context-id"))
+	if err != nil {
+		return fmt.Errorf("bad context id: %w", err)
+	}
+	mh, err := getMultihash(cmd, false)
+	if err != nil {
+		return err
+	}
+
+	vk := dhash.CreateValueKey(pid, ctxID)
+	hvk := b58.Encode(dhash.SHA256(vk, nil))
+
+	fmt.Println("Value key:          ", base64.StdEncoding.EncodeToString(vk))
+	fmt.Println("Metadata key:       ", hvk)
+	fmt.Println("Metadata path:      ", "/metadata/"+hvk)
+	if mh != nil {
+		evk, err := dhash.EncryptValueKey(vk, mh)
+		if err != nil {
+			return fmt.Errorf("cannot encrypt value key: %w", err)
+		}
+		fmt.Println("Encrypted value key:", base64.StdEncoding.EncodeToString(evk))
+	}
+	return nil
+}
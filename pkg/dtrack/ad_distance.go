@@ -6,12 +6,14 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"strconv"
 
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-datastore"
 	"github.com/ipld/go-ipld-prime"
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
 	"github.com/ipni/go-libipni/dagsync"
+	"github.com/ipni/go-libipni/ingest/schema"
 	"github.com/libp2p/go-libp2p"
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/peer"
@@ -61,32 +63,263 @@ func NewAdDistance(options ...Option) (*AdDistance, error) {
 	}, nil
 }
 
-// Get returns the number af advertisements from the newest to the oldest
+// Distance is the result of walking an advertisement chain from a newer
+// advertisement to an older one.
+type Distance struct {
+	// Count is the number of advertisements from the newer advertisement to
+	// the older advertisement. If Exceeded is true, then this is a lower bound
+	// and the actual distance is at least Count.
+	Count int
+	// Exceeded is true if the depth limit was reached before the older
+	// advertisement was found.
+	Exceeded bool
+	// Head is the CID of the newer advertisement. This is the head of the
+	// chain if a newer advertisement CID was not specified.
+	Head cid.Cid
+	// NotAncestor is true if the start of the chain was reached without
+	// finding the older advertisement.
+	NotAncestor bool
+	// StopCid is the CID of the last advertisement reached when the walk
+	// stopped at the depth limit or at the start of the chain. Only set when
+	// Exceeded or NotAncestor is true.
+	StopCid cid.Cid
+}
+
+// String returns the distance as text, noting where the walk stopped if the
+// depth limit was exceeded.
+func (d Distance) String() string {
+	if d.NotAncestor {
+		return fmt.Sprintf("not an ancestor, start of chain reached at %s", d.StopCid)
+	}
+	if d.Exceeded {
+		return fmt.Sprintf("at least %d, depth limit reached at %s", d.Count, d.StopCid)
+	}
+	return strconv.Itoa(d.Count)
+}
+
+// ErrNotAncestor is returned when the walk from the newer advertisement
+// reaches the start of the chain without finding the older advertisement,
+// meaning that the older advertisement is not an ancestor of the newer one.
+var ErrNotAncestor = errors.New("advertisement is not an ancestor")
+
+// Fork describes where two advertisement chains meet.
+type Fork struct {
+	// Ancestor is the newest advertisement common to both chains. This is
+	// cid.Undef if no common advertisement was found.
+	Ancestor cid.Cid
+	// HeadA is the head of the first chain.
+	HeadA cid.Cid
+	// HeadB is the head of the second chain.
+	HeadB cid.Cid
+	// DistA is the distance from HeadA to Ancestor. If no ancestor was found,
+	// this is the number of advertisements walked on the first chain.
+	DistA int
+	// DistB is the distance from HeadB to Ancestor. If no ancestor was found,
+	// this is the number of advertisements walked on the second chain.
+	DistB int
+	// Disjoint is true if both chains were walked to their start without
+	// finding a common advertisement. If false and no ancestor was found,
+	// then the depth limit was reached first.
+	Disjoint bool
+}
+
+// walkResult is the result of walking an advertisement chain from its head.
+type walkResult struct {
+	head    cid.Cid
+	count   int
+	last    cid.Cid
+	lastPrv cid.Cid
+	prvOK   bool
+	cids    []cid.Cid
+}
+
+// reachedStart returns true if the walk reached the first advertisement in
+// the chain.
+func (w walkResult) reachedStart() bool {
+	return w.count != 0 && w.prvOK && w.lastPrv == cid.Undef
+}
+
+// Get returns the number of advertisements from the newest to the oldest
 // advertisement on an IPNI advertisement chain. If newestCid is cid.Undef,
-// then it referrs to the current head of the chain, and the head CID is
-// returned as the 2nd return value.
-func (a *AdDistance) Get(ctx context.Context, publisher peer.AddrInfo, oldestCid, newestCid cid.Cid) (int, cid.Cid, error) {
+// then it refers to the current head of the chain, and the head CID is
+// returned in the Distance.
+//
+// If the depth limit is reached before finding the oldest advertisement, then
+// the Distance is marked as exceeded and holds a lower bound along with the
+// CID where the walk stopped. A depth limit of 0 means no limit. If the start
+// of the chain is reached without finding the oldest advertisement, then
+// ErrNotAncestor is returned.
+func (a *AdDistance) Get(ctx context.Context, publisher peer.AddrInfo, oldestCid, newestCid cid.Cid) (Distance, error) {
 	if oldestCid == cid.Undef {
-		return 0, cid.Undef, errors.New("must specify a oldest CID")
+		return Distance{}, errors.New("must specify a oldest CID")
 	}
 
-	var depthLimit int64
+	walk, err := a.walk(ctx, publisher, newestCid, oldestCid, false)
+	if err != nil {
+		return Distance{}, err
+	}
+
+	dist := Distance{
+		Count: walk.count,
+		Head:  walk.head,
+	}
+	if walk.reachedStart() {
+		dist.NotAncestor = true
+		dist.StopCid = walk.last
+		return dist, fmt.Errorf("%w: reached start of chain at %s after %d advertisements without finding %s",
+			ErrNotAncestor, walk.last, walk.count, oldestCid)
+	}
+	if a.depthLimit != 0 && int64(walk.count) > a.depthLimit && walk.lastPrv != oldestCid {
+		dist.Exceeded = true
+		dist.StopCid = walk.last
+	}
+	return dist, nil
+}
+
+// GetMany returns the distance from the newest advertisement to each of the
+// older advertisements, walking the chain only once. If newestCid is
+// cid.Undef, then the current head of the chain is used. The returned
+// distances are in the same order as oldestCids.
+//
+// The walk stops when all older advertisements are found, the depth limit is
+// reached, or the start of the chain is reached. The Distance of each
+// advertisement that is not found is marked as either Exceeded or NotAncestor.
+func (a *AdDistance) GetMany(ctx context.Context, publisher peer.AddrInfo, oldestCids []cid.Cid, newestCid cid.Cid) ([]Distance, error) {
+	if len(oldestCids) == 0 {
+		return nil, errors.New("must specify at least one oldest CID")
+	}
+	targets := make(map[cid.Cid]struct{}, len(oldestCids))
+	for _, c := range oldestCids {
+		if c == cid.Undef {
+			return nil, errors.New("oldest CID cannot be undefined")
+		}
+		targets[c] = struct{}{}
+	}
+
+	a.store.targets = targets
+	walk, err := a.walk(ctx, publisher, newestCid, cid.Undef, true)
+	if err != nil {
+		return nil, err
+	}
+	if walk.count == 0 {
+		return nil, errors.New("publisher has no advertisements")
+	}
+	head := walk.cids[0]
+
+	depths := make(map[cid.Cid]int, len(walk.cids))
+	for i, c := range walk.cids {
+		depths[c] = i
+	}
+
+	dists := make([]Distance, len(oldestCids))
+	for i, c := range oldestCids {
+		dists[i].Head = head
+		if depth, ok := depths[c]; ok {
+			dists[i].Count = depth
+			continue
+		}
+		dists[i].Count = walk.count
+		dists[i].StopCid = walk.last
+		if walk.reachedStart() {
+			dists[i].NotAncestor = true
+		} else {
+			dists[i].Exceeded = true
+		}
+	}
+	return dists, nil
+}
+
+// CommonAncestor finds the newest advertisement that is common to the chains
+// starting at headA and headB, such as an old and a new head after a
+// publisher reset. If headB is cid.Undef, then the current head of the chain
+// is used. Each chain is walked no further than the depth limit.
+func (a *AdDistance) CommonAncestor(ctx context.Context, publisher peer.AddrInfo, headA, headB cid.Cid) (Fork, error) {
+	if headA == cid.Undef {
+		return Fork{}, errors.New("must specify first head CID")
+	}
+
+	// Walk B first, stopping if A is found on the same chain.
+	walkB, err := a.walk(ctx, publisher, headB, headA, true)
+	if err != nil {
+		return Fork{}, fmt.Errorf("cannot walk chain from %s: %w", cidOrHead(headB), err)
+	}
+	fork := Fork{
+		HeadA: headA,
+		HeadB: walkB.head,
+	}
+	if walkB.head == headA || (walkB.count != 0 && walkB.prvOK && walkB.lastPrv == headA) {
+		fork.Ancestor = headA
+		fork.DistB = walkB.count
+		return fork, nil
+	}
+
+	// Walk A, stopping if B is found on the same chain.
+	walkA, err := a.walk(ctx, publisher, headA, walkB.head, true)
+	if err != nil {
+		return Fork{}, fmt.Errorf("cannot walk chain from %s: %w", headA, err)
+	}
+	if walkA.count != 0 && walkA.prvOK && walkA.lastPrv == walkB.head {
+		fork.Ancestor = walkB.head
+		fork.DistA = walkA.count
+		return fork, nil
+	}
+
+	onA := make(map[cid.Cid]int, len(walkA.cids))
+	for i, c := range walkA.cids {
+		onA[c] = i
+	}
+	for i, c := range walkB.cids {
+		if distA, ok := onA[c]; ok {
+			fork.Ancestor = c
+			fork.DistA = distA
+			fork.DistB = i
+			return fork, nil
+		}
+	}
+
+	fork.DistA = walkA.count
+	fork.DistB = walkB.count
+	fork.Disjoint = walkA.reachedStart() && walkB.reachedStart()
+	return fork, nil
+}
+
+// walk syncs the advertisement chain from head until reaching the stop
+// advertisement, the start of the chain, one advertisement past the depth
+// limit, or all of the store's target advertisements. If record is true, then
+// the CIDs of the advertisements walked are returned, newest first.
+func (a *AdDistance) walk(ctx context.Context, publisher peer.AddrInfo, head, stop cid.Cid, record bool) (walkResult, error) {
+	defer a.store.reset()
+
+	// Walk one past the limit to tell whether the limit was exceeded.
+	depthLimit := int64(-1)
 	if a.depthLimit != 0 {
 		depthLimit = a.depthLimit + 1
 	}
 
-	newestCid, err := a.sub.SyncAdChain(ctx, publisher, dagsync.ScopedDepthLimit(depthLimit),
-		dagsync.WithHeadAdCid(newestCid), dagsync.WithStopAdCid(oldestCid))
-	if err != nil {
-		return 0, cid.Undef, fmt.Errorf("failed to sync chain lastAd=%s depth=%d: %w", a.store.lastKey, a.store.count, err)
+	a.store.record = record
+	// Resync so that the walk does not stop at the head of a previous walk
+	// when stop is undefined, and does not record this head as synced.
+	head, err := a.sub.SyncAdChain(ctx, publisher, dagsync.ScopedDepthLimit(depthLimit),
+		dagsync.WithHeadAdCid(head), dagsync.WithStopAdCid(stop), dagsync.WithAdsResync(true))
+	if err != nil && !a.store.done {
+		return walkResult{}, fmt.Errorf("failed to sync chain lastAd=%s depth=%d: %w", a.store.lastKey, a.store.count, err)
 	}
 
-	dist := a.store.distance()
-	if int64(dist) > a.depthLimit {
-		dist = -1
+	walk := walkResult{
+		head:  head,
+		count: a.store.count,
+		last:  a.store.lastCid(),
+		cids:  a.store.cids,
 	}
+	walk.lastPrv, walk.prvOK = a.store.lastPrev()
+	return walk, nil
+}
 
-	return dist, newestCid, nil
+func cidOrHead(c cid.Cid) string {
+	if c == cid.Undef {
+		return "head"
+	}
+	return c.String()
 }
 
 // Close closes the internal dagsync subscriber and the libp2p host if owned by
@@ -105,8 +338,18 @@ type countStore struct {
 	count   int
 	lastKey string
 	lastVal []byte
+	// record enables recording the CIDs of stored advertisements.
+	record bool
+	cids   []cid.Cid
+	// targets are advertisements that end the walk once all are stored.
+	targets map[cid.Cid]struct{}
+	found   int
+	done    bool
 }
 
+// errWalkDone stops a sync once all target advertisements are found.
+var errWalkDone = errors.New("all target advertisements found")
+
 func newCountStore() *countStore {
 	cs := &countStore{
 		Batching: datastore.NewNullDatastore(),
@@ -124,8 +367,18 @@ func newCountStore() *countStore {
 		return buf, func(lnk ipld.Link) error {
 			cs.count++
 			c := lnk.(cidlink.Link).Cid
+			if cs.record {
+				cs.cids = append(cs.cids, c)
+			}
 			cs.lastKey = c.String()
 			cs.lastVal = buf.Bytes()
+			if _, ok := cs.targets[c]; ok {
+				cs.found++
+				if cs.found == len(cs.targets) {
+					cs.done = true
+					return errWalkDone
+				}
+			}
 			return nil
 		}, nil
 	}
@@ -133,10 +386,36 @@ func newCountStore() *countStore {
 	return cs
 }
 
-func (s *countStore) distance() int {
-	count := s.count
+// lastCid returns the CID of the last advertisement stored.
+func (s *countStore) lastCid() cid.Cid {
+	c, err := cid.Decode(s.lastKey)
+	if err != nil {
+		return cid.Undef
+	}
+	return c
+}
+
+// lastPrev returns the previous advertisement CID of the last advertisement
+// stored. Returns false if this cannot be determined.
+func (s *countStore) lastPrev() (cid.Cid, bool) {
+	c := s.lastCid()
+	if c == cid.Undef {
+		return cid.Undef, false
+	}
+	ad, err := schema.BytesToAdvertisement(c, s.lastVal)
+	if err != nil {
+		return cid.Undef, false
+	}
+	return ad.PreviousCid(), true
+}
+
+func (s *countStore) reset() {
 	s.count = 0
 	s.lastKey = ""
 	s.lastVal = nil
-	return count
+	s.record = false
+	s.cids = nil
+	s.targets = nil
+	s.found = 0
+	s.done = false
 }
@@ -0,0 +1,268 @@
+package dtrack_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipni/go-libipni/dagsync/ipnisync"
+	"github.com/ipni/go-libipni/ingest/schema"
+	"github.com/ipni/ipni-cli/pkg/dtrack"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+// testChain is an advertisement chain served by a local HTTP publisher.
+type testChain struct {
+	t       *testing.T
+	lsys    ipld.LinkSystem
+	privKey crypto.PrivKey
+	pub     *ipnisync.Publisher
+	// ads holds the advertisement CIDs, from oldest to newest.
+	ads []cid.Cid
+}
+
+func newTestChain(t *testing.T) *testChain {
+	privKey, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+
+	store := dssync.MutexWrap(datastore.NewMapDatastore())
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.StorageReadOpener = func(lctx ipld.LinkContext, lnk ipld.Link) (io.Reader, error) {
+		val, err := store.Get(lctx.Ctx, datastore.NewKey(lnk.String()))
+		if err != nil {
+			return nil, err
+		}
+		return bytes.NewBuffer(val), nil
+	}
+	lsys.StorageWriteOpener = func(lctx ipld.LinkContext) (io.Writer, ipld.BlockWriteCommitter, error) {
+		buf := bytes.NewBuffer(nil)
+		return buf, func(lnk ipld.Link) error {
+			return store.Put(lctx.Ctx, datastore.NewKey(lnk.String()), buf.Bytes())
+		}, nil
+	}
+
+	pub, err := ipnisync.NewPublisher(lsys, privKey, ipnisync.WithHTTPListenAddrs("127.0.0.1:0"))
+	require.NoError(t, err)
+	t.Cleanup(func() { pub.Close() })
+
+	return &testChain{
+		t:       t,
+		lsys:    lsys,
+		privKey: privKey,
+		pub:     pub,
+	}
+}
+
+// addAds appends n advertisements to the chain, starting after prev, and
+// returns the CIDs of the new advertisements from oldest to newest. The last
+// advertisement added becomes the head of the chain.
+func (c *testChain) addAds(prev cid.Cid, n int) []cid.Cid {
+	providerID, err := peer.IDFromPrivateKey(c.privKey)
+	require.NoError(c.t, err)
+
+	added := make([]cid.Cid, 0, n)
+	for range n {
+		ad := schema.Advertisement{
+			Provider:  providerID.String(),
+			Addresses: []string{"/ip4/127.0.0.1/tcp/9999"},
+			Entries:   schema.NoEntries,
+			ContextID: fmt.Appendf(nil, "ctx-%d", len(c.ads)),
+			Metadata:  []byte{0x00},
+		}
+		if prev != cid.Undef {
+			ad.PreviousID = cidlink.Link{Cid: prev}
+		}
+		require.NoError(c.t, ad.Sign(c.privKey))
+		node, err := ad.ToNode()
+		require.NoError(c.t, err)
+		lnk, err := c.lsys.Store(ipld.LinkContext{Ctx: c.t.Context()}, schema.Linkproto, node)
+		require.NoError(c.t, err)
+		prev = lnk.(cidlink.Link).Cid
+		c.ads = append(c.ads, prev)
+		added = append(added, prev)
+	}
+	c.pub.SetRoot(prev)
+	return added
+}
+
+func (c *testChain) addrInfo() peer.AddrInfo {
+	return peer.AddrInfo{
+		ID:    c.pub.ID(),
+		Addrs: c.pub.Addrs(),
+	}
+}
+
+func TestAdDistance(t *testing.T) {
+	chain := newTestChain(t)
+	ads := chain.addAds(cid.Undef, 10)
+
+	adDist, err := dtrack.NewAdDistance(dtrack.WithDepthLimit(5))
+	require.NoError(t, err)
+	defer adDist.Close()
+
+	// Within limit.
+	dist, err := adDist.Get(t.Context(), chain.addrInfo(), ads[6], cid.Undef)
+	require.NoError(t, err)
+	require.False(t, dist.Exceeded)
+	require.Equal(t, 3, dist.Count)
+	require.Equal(t, ads[9], dist.Head)
+
+	// Between two specified advertisements.
+	dist, err = adDist.Get(t.Context(), chain.addrInfo(), ads[2], ads[5])
+	require.NoError(t, err)
+	require.False(t, dist.Exceeded)
+	require.Equal(t, 3, dist.Count)
+
+	// One past the limit is still an exact distance.
+	dist, err = adDist.Get(t.Context(), chain.addrInfo(), ads[3], cid.Undef)
+	require.NoError(t, err)
+	require.False(t, dist.Exceeded)
+	require.Equal(t, 6, dist.Count)
+
+	// Limit exceeded gives lower bound and where the walk stopped.
+	dist, err = adDist.Get(t.Context(), chain.addrInfo(), ads[0], cid.Undef)
+	require.NoError(t, err)
+	require.True(t, dist.Exceeded)
+	require.Equal(t, 6, dist.Count)
+	require.Equal(t, ads[4], dist.StopCid)
+
+	// No limit.
+	unlimited, err := dtrack.NewAdDistance(dtrack.WithDepthLimit(0))
+	require.NoError(t, err)
+	defer unlimited.Close()
+
+	dist, err = unlimited.Get(t.Context(), chain.addrInfo(), ads[0], cid.Undef)
+	require.NoError(t, err)
+	require.False(t, dist.Exceeded)
+	require.Equal(t, 9, dist.Count)
+}
+
+func TestAdDistanceFork(t *testing.T) {
+	chain := newTestChain(t)
+	base := chain.addAds(cid.Undef, 5)
+	oldAds := chain.addAds(base[4], 3)
+	newAds := chain.addAds(base[2], 4)
+	otherAds := chain.addAds(cid.Undef, 2)
+	// Restore head to the new chain.
+	chain.pub.SetRoot(newAds[3])
+
+	adDist, err := dtrack.NewAdDistance(dtrack.WithDepthLimit(20))
+	require.NoError(t, err)
+	defer adDist.Close()
+
+	// Old head is not an ancestor of the new head.
+	_, err = adDist.Get(t.Context(), chain.addrInfo(), oldAds[2], cid.Undef)
+	require.ErrorIs(t, err, dtrack.ErrNotAncestor)
+
+	fork, err := adDist.CommonAncestor(t.Context(), chain.addrInfo(), oldAds[2], cid.Undef)
+	require.NoError(t, err)
+	require.Equal(t, base[2], fork.Ancestor)
+	require.Equal(t, newAds[3], fork.HeadB)
+	require.Equal(t, 5, fork.DistA)
+	require.Equal(t, 4, fork.DistB)
+
+	// One head is an ancestor of the other.
+	fork, err = adDist.CommonAncestor(t.Context(), chain.addrInfo(), base[1], cid.Undef)
+	require.NoError(t, err)
+	require.Equal(t, base[1], fork.Ancestor)
+	require.Zero(t, fork.DistA)
+	require.Equal(t, 5, fork.DistB)
+
+	fork, err = adDist.CommonAncestor(t.Context(), chain.addrInfo(), newAds[3], base[1])
+	require.NoError(t, err)
+	require.Equal(t, base[1], fork.Ancestor)
+	require.Equal(t, 5, fork.DistA)
+	require.Zero(t, fork.DistB)
+
+	// Chains with nothing in common.
+	fork, err = adDist.CommonAncestor(t.Context(), chain.addrInfo(), otherAds[1], cid.Undef)
+	require.NoError(t, err)
+	require.Equal(t, cid.Undef, fork.Ancestor)
+	require.True(t, fork.Disjoint)
+	require.Equal(t, 2, fork.DistA)
+	require.Equal(t, 7, fork.DistB)
+
+	// Common ancestor beyond depth limit.
+	limited, err := dtrack.NewAdDistance(dtrack.WithDepthLimit(2))
+	require.NoError(t, err)
+	defer limited.Close()
+	fork, err = limited.CommonAncestor(t.Context(), chain.addrInfo(), oldAds[2], cid.Undef)
+	require.NoError(t, err)
+	require.Equal(t, cid.Undef, fork.Ancestor)
+	require.False(t, fork.Disjoint)
+}
+
+func TestAdDistanceGetMany(t *testing.T) {
+	chain := newTestChain(t)
+	ads := chain.addAds(cid.Undef, 10)
+	other := chain.addAds(cid.Undef, 1)
+	chain.pub.SetRoot(ads[9])
+
+	adDist, err := dtrack.NewAdDistance(dtrack.WithDepthLimit(20))
+	require.NoError(t, err)
+	defer adDist.Close()
+
+	dists, err := adDist.GetMany(t.Context(), chain.addrInfo(), []cid.Cid{ads[2], ads[9], ads[5]}, cid.Undef)
+	require.NoError(t, err)
+	require.Len(t, dists, 3)
+	require.Equal(t, 7, dists[0].Count)
+	require.Zero(t, dists[1].Count)
+	require.Equal(t, 4, dists[2].Count)
+	for _, dist := range dists {
+		require.False(t, dist.Exceeded)
+		require.False(t, dist.NotAncestor)
+		require.Equal(t, ads[9], dist.Head)
+	}
+
+	// Advertisement not on chain.
+	dists, err = adDist.GetMany(t.Context(), chain.addrInfo(), []cid.Cid{ads[1], other[0]}, ads[7])
+	require.NoError(t, err)
+	require.Equal(t, 6, dists[0].Count)
+	require.True(t, dists[1].NotAncestor)
+	require.Equal(t, ads[0], dists[1].StopCid)
+
+	// Advertisement beyond depth limit.
+	limited, err := dtrack.NewAdDistance(dtrack.WithDepthLimit(3))
+	require.NoError(t, err)
+	defer limited.Close()
+	dists, err = limited.GetMany(t.Context(), chain.addrInfo(), []cid.Cid{ads[8], ads[1]}, cid.Undef)
+	require.NoError(t, err)
+	require.Equal(t, 1, dists[0].Count)
+	require.False(t, dists[0].Exceeded)
+	require.True(t, dists[1].Exceeded)
+	require.Equal(t, 4, dists[1].Count)
+	require.Equal(t, ads[6], dists[1].StopCid)
+}
+
+func TestAdDistanceGetManyRepeated(t *testing.T) {
+	chain := newTestChain(t)
+	ads := chain.addAds(cid.Undef, 10)
+
+	adDist, err := dtrack.NewAdDistance(dtrack.WithDepthLimit(20))
+	require.NoError(t, err)
+	defer adDist.Close()
+
+	// Walking from the head again must not stop at the head of the previous
+	// walk.
+	for range 2 {
+		dists, err := adDist.GetMany(t.Context(), chain.addrInfo(), []cid.Cid{ads[2], ads[5]}, cid.Undef)
+		require.NoError(t, err)
+		require.Equal(t, 7, dists[0].Count)
+		require.Equal(t, 4, dists[1].Count)
+		require.False(t, dists[0].NotAncestor)
+		require.False(t, dists[1].NotAncestor)
+
+		dist, err := adDist.Get(t.Context(), chain.addrInfo(), ads[0], cid.Undef)
+		require.NoError(t, err)
+		require.Equal(t, 9, dist.Count)
+	}
+}
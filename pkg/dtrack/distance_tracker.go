@@ -2,18 +2,29 @@ package dtrack
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"slices"
+	"sync"
 	"time"
 
 	"github.com/ipfs/go-cid"
-	"github.com/ipni/go-libipni/pcache"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/peer"
 )
 
+// DistanceUpdate reports a change in the distance from a provider's last seen
+// advertisement to the head of its advertisement chain.
 type DistanceUpdate struct {
 	ID       peer.ID
 	Distance int
-	Err      error
+	// Exceeded is true if the depth limit was reached before finding the last
+	// seen advertisement. Distance is then a lower bound.
+	Exceeded bool
+	// StopCid is the advertisement where the walk stopped when Exceeded.
+	StopCid cid.Cid
+	Err     error
 }
 
 const (
@@ -33,30 +44,64 @@ type distTrack struct {
 }
 
 type tracker struct {
-	adDist   *AdDistance
+	adDists  []*AdDistance
+	ownHost  host.Host
 	include  map[peer.ID]struct{}
 	exclude  map[peer.ID]struct{}
-	pcache   *pcache.ProviderCache
+	src      ProviderSource
 	updateIn time.Duration
 	timeout  time.Duration
 	updates  chan<- DistanceUpdate
 }
 
-func RunDistanceTracker(ctx context.Context, include, exclude map[peer.ID]struct{}, provCache *pcache.ProviderCache, updateIn, timeout time.Duration, options ...Option) (<-chan DistanceUpdate, error) {
-	adDist, err := NewAdDistance(options...)
-	if err != nil {
-		return nil, err
+// RunDistanceTracker starts tracking the distance from each provider's last
+// seen advertisement to the head of the provider's advertisement chain.
+// Provider information is read from src, which is refreshed at each update
+// interval. A DistanceUpdate is sent on the returned channel whenever a
+// distance changes or an error occurs. The channel is closed when ctx is
+// canceled.
+func RunDistanceTracker(ctx context.Context, src ProviderSource, options ...Option) (<-chan DistanceUpdate, error) {
+	if src == nil {
+		return nil, errors.New("nil provider source")
+	}
+	opts := getOpts(options)
+
+	// All AdDistance instances share the same libp2p host.
+	var ownHost host.Host
+	if opts.p2pHost == nil {
+		var err error
+		ownHost, err = libp2p.New()
+		if err != nil {
+			return nil, err
+		}
+		options = append(slices.Clone(options), WithP2pHost(ownHost))
+	}
+
+	adDists := make([]*AdDistance, 0, opts.concurrency)
+	for range opts.concurrency {
+		adDist, err := NewAdDistance(options...)
+		if err != nil {
+			for _, ad := range adDists {
+				ad.Close()
+			}
+			if ownHost != nil {
+				ownHost.Close()
+			}
+			return nil, err
+		}
+		adDists = append(adDists, adDist)
 	}
 
 	updates := make(chan DistanceUpdate)
 
 	tkr := &tracker{
-		adDist:   adDist,
-		include:  include,
-		exclude:  exclude,
-		pcache:   provCache,
-		updateIn: updateIn,
-		timeout:  timeout,
+		adDists:  adDists,
+		ownHost:  ownHost,
+		include:  opts.include,
+		exclude:  opts.exclude,
+		src:      src,
+		updateIn: opts.updateIn,
+		timeout:  opts.timeout,
 		updates:  updates,
 	}
 
@@ -67,7 +112,7 @@ func RunDistanceTracker(ctx context.Context, include, exclude map[peer.ID]struct
 
 func (tkr *tracker) run(ctx context.Context) {
 	defer close(tkr.updates)
-	defer tkr.adDist.Close()
+	defer tkr.close()
 
 	var lookForNew bool
 	var tracks map[peer.ID]*distTrack
@@ -90,11 +135,11 @@ func (tkr *tracker) run(ctx context.Context) {
 	for {
 		select {
 		case <-timer.C:
-			if err := tkr.pcache.Refresh(ctx); err != nil {
+			if err := tkr.src.Refresh(ctx); err != nil {
 				return
 			}
 			if lookForNew {
-				for _, pinfo := range tkr.pcache.List() {
+				for _, pinfo := range tkr.src.List() {
 					pid := pinfo.AddrInfo.ID
 					if _, ok := tracks[pid]; !ok {
 						if _, ok = tkr.exclude[pid]; !ok {
@@ -111,20 +156,53 @@ func (tkr *tracker) run(ctx context.Context) {
 	}
 }
 
+func (tkr *tracker) close() {
+	for _, adDist := range tkr.adDists {
+		adDist.Close()
+	}
+	if tkr.ownHost != nil {
+		tkr.ownHost.Close()
+	}
+}
+
 func (tkr *tracker) updateTracks(ctx context.Context, tracks map[peer.ID]*distTrack) {
+	if len(tkr.adDists) == 1 {
+		for providerID, track := range tracks {
+			tkr.updateTrack(ctx, tkr.adDists[0], providerID, track)
+		}
+		return
+	}
+
+	type work struct {
+		pid   peer.ID
+		track *distTrack
+	}
+	workChan := make(chan work)
+	var wg sync.WaitGroup
+	for _, adDist := range tkr.adDists {
+		wg.Add(1)
+		go func(adDist *AdDistance) {
+			defer wg.Done()
+			for w := range workChan {
+				tkr.updateTrack(ctx, adDist, w.pid, w.track)
+			}
+		}(adDist)
+	}
 	for providerID, track := range tracks {
-		tkr.updateTrack(ctx, providerID, track)
+		workChan <- work{providerID, track}
 	}
+	close(workChan)
+	wg.Wait()
 }
 
-func (tkr *tracker) updateTrack(ctx context.Context, pid peer.ID, track *distTrack) {
+func (tkr *tracker) updateTrack(ctx context.Context, adDist *AdDistance, pid peer.ID, track *distTrack) {
 	if tkr.timeout != 0 {
 		var cancel context.CancelFunc
 		ctx, cancel = context.WithTimeout(ctx, tkr.timeout)
 		defer cancel()
 	}
 
-	pinfo, err := tkr.pcache.Get(ctx, pid)
+	pinfo, err := tkr.src.Get(ctx, pid)
 	if err != nil {
 		return
 	}
@@ -166,7 +244,7 @@ func (tkr *tracker) updateTrack(ctx context.Context, pid peer.ID, track *distTra
 	}
 
 	if track.head == cid.Undef {
-		dist, head, err := tkr.adDist.Get(ctx, *pinfo.Publisher, pinfo.LastAdvertisement, cid.Undef)
+		dist, err := adDist.Get(ctx, *pinfo.Publisher, pinfo.LastAdvertisement, cid.Undef)
 		if err != nil {
 			if track.errType != errTypeUpdate {
 				track.errType = errTypeUpdate
@@ -181,13 +259,15 @@ func (tkr *tracker) updateTrack(ctx context.Context, pid peer.ID, track *distTra
 		track.err = nil
 		track.errType = errTypeNone
 		track.ad = pinfo.LastAdvertisement
-		track.dist = dist
-		if dist != -1 {
-			track.head = head
+		track.dist = dist.Count
+		if !dist.Exceeded {
+			track.head = dist.Head
 		}
 		tkr.updates <- DistanceUpdate{
 			ID:       pid,
-			Distance: dist,
+			Distance: dist.Count,
+			Exceeded: dist.Exceeded,
+			StopCid:  dist.StopCid,
 		}
 		return
 	}
@@ -195,7 +275,13 @@ func (tkr *tracker) updateTrack(ctx context.Context, pid peer.ID, track *distTra
 	var updated bool
 
 	// Get distance between old head and new head.
-	dist, head, err := tkr.adDist.Get(ctx, *pinfo.Publisher, track.head, cid.Undef)
+	dist, err := adDist.Get(ctx, *pinfo.Publisher, track.head, cid.Undef)
+	if errors.Is(err, ErrNotAncestor) {
+		// Chain was replaced since the last head was seen, so measure again.
+		track.head = cid.Undef
+		tkr.updateTrack(ctx, adDist, pid, track)
+		return
+	}
 	if err != nil {
 		if track.errType != errTypeUpdate {
 			track.errType = errTypeUpdate
@@ -209,24 +295,27 @@ func (tkr *tracker) updateTrack(ctx context.Context, pid peer.ID, track *distTra
 	}
 	track.err = nil
 	track.errType = errTypeNone
-	if dist == -1 {
-		track.dist = -1
+	if dist.Exceeded {
+		// Head moved too far to track incrementally, so measure again.
 		track.head = cid.Undef
-		tkr.updates <- DistanceUpdate{
-			ID:       pid,
-			Distance: -1,
-		}
+		tkr.updateTrack(ctx, adDist, pid, track)
 		return
 	}
-	if head != track.head {
-		track.dist += dist
-		track.head = head
+	if dist.Head != track.head {
+		track.dist += dist.Count
+		track.head = dist.Head
 		updated = true
 	}
 
 	if pinfo.LastAdvertisement != track.ad {
 		// If the last seen advertisement has changed, then get the distance it has moved.
-		dist, _, err := tkr.adDist.Get(ctx, *pinfo.Publisher, track.ad, pinfo.LastAdvertisement)
+		dist, err := adDist.Get(ctx, *pinfo.Publisher, track.ad, pinfo.LastAdvertisement)
+		if errors.Is(err, ErrNotAncestor) {
+			// Last seen ad is on a different chain, so measure again.
+			track.head = cid.Undef
+			tkr.updateTrack(ctx, adDist, pid, track)
+			return
+		}
 		if err != nil {
 			if track.errType != errTypeUpdate {
 				track.errType = errTypeUpdate
@@ -240,17 +329,15 @@ func (tkr *tracker) updateTrack(ctx context.Context, pid peer.ID, track *distTra
 		}
 		track.err = nil
 		track.errType = errTypeNone
-		if dist == -1 {
-			track.dist = -1
+		if dist.Exceeded {
+			// Last seen ad moved too far to track incrementally, so measure
+			// again.
 			track.head = cid.Undef
-			tkr.updates <- DistanceUpdate{
-				ID:       pid,
-				Distance: -1,
-			}
+			tkr.updateTrack(ctx, adDist, pid, track)
 			return
 		}
 		track.ad = pinfo.LastAdvertisement
-		track.dist -= dist
+		track.dist -= dist.Count
 		updated = true
 	}
 
@@ -0,0 +1,129 @@
+package dtrack_test
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipni/go-libipni/find/model"
+	"github.com/ipni/ipni-cli/pkg/dtrack"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testPID1  = "12D3KooWE8yt84RVwW3sFcd6WMjbUdWrZer2YtT4dmtj3dHdahSZ"
+	testPID2  = "12D3KooWLjeDyvuv7rbfG2wWNvWn7ybmmU88PirmSckuqCgXBAph"
+	testPID3  = "12D3KooWEAcRJ5fYjuavKgAhu79juR7mgaznSZxsm2RRUBiWurv9"
+	testAdCid = "baguqeerank3iclae2u4lin3vj2avuory3ny67tldh2cd5uodsgsdl6uawz3a"
+)
+
+type fakeSource struct {
+	provs map[peer.ID]*model.ProviderInfo
+}
+
+func (s *fakeSource) Refresh(context.Context) error { return nil }
+
+func (s *fakeSource) Get(_ context.Context, pid peer.ID) (*model.ProviderInfo, error) {
+	return s.provs[pid], nil
+}
+
+func (s *fakeSource) List() []*model.ProviderInfo {
+	pinfos := make([]*model.ProviderInfo, 0, len(s.provs))
+	for _, pinfo := range s.provs {
+		pinfos = append(pinfos, pinfo)
+	}
+	return pinfos
+}
+
+func TestDistanceTrackerErrors(t *testing.T) {
+	pid1, err := peer.Decode(testPID1)
+	require.NoError(t, err)
+	pid2, err := peer.Decode(testPID2)
+	require.NoError(t, err)
+	pid3, err := peer.Decode(testPID3)
+	require.NoError(t, err)
+	adCid, err := cid.Decode(testAdCid)
+	require.NoError(t, err)
+
+	src := &fakeSource{
+		provs: map[peer.ID]*model.ProviderInfo{
+			// Provider that has never synced.
+			pid1: {
+				AddrInfo: peer.AddrInfo{ID: pid1},
+			},
+			// Provider that has no publisher.
+			pid2: {
+				AddrInfo:          peer.AddrInfo{ID: pid2},
+				LastAdvertisement: adCid,
+			},
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(t.Context(), 10*time.Second)
+	defer cancel()
+
+	updates, err := dtrack.RunDistanceTracker(ctx, src,
+		dtrack.WithInclude(pid1, pid2, pid3),
+		dtrack.WithUpdateInterval(time.Hour),
+		dtrack.WithConcurrency(2))
+	require.NoError(t, err)
+
+	errs := make(map[peer.ID]string)
+	for len(errs) < 3 {
+		select {
+		case update, ok := <-updates:
+			require.True(t, ok, "updates channel closed early")
+			require.Error(t, update.Err)
+			errs[update.ID] = update.Err.Error()
+		case <-ctx.Done():
+			t.Fatal("timed out waiting for distance updates")
+		}
+	}
+	require.Equal(t, "provider never synced", errs[pid1])
+	require.Equal(t, "no advertisement publisher", errs[pid2])
+	require.Equal(t, "provider info not found", errs[pid3])
+
+	cancel()
+	for range updates {
+	}
+}
+
+func TestFileSource(t *testing.T) {
+	pid1, err := peer.Decode(testPID1)
+	require.NoError(t, err)
+	pid2, err := peer.Decode(testPID2)
+	require.NoError(t, err)
+
+	pinfos := []*model.ProviderInfo{
+		{AddrInfo: peer.AddrInfo{ID: pid1}},
+	}
+	data, err := json.Marshal(pinfos)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "providers.json")
+	require.NoError(t, os.WriteFile(path, data, 0o644))
+
+	src, err := dtrack.NewFileSource(path)
+	require.NoError(t, err)
+	require.Len(t, src.List(), 1)
+
+	pinfo, err := src.Get(t.Context(), pid1)
+	require.NoError(t, err)
+	require.Equal(t, pid1, pinfo.AddrInfo.ID)
+
+	pinfo, err = src.Get(t.Context(), pid2)
+	require.NoError(t, err)
+	require.Nil(t, pinfo)
+
+	// Check that refresh reads the updated file.
+	pinfos = append(pinfos, &model.ProviderInfo{AddrInfo: peer.AddrInfo{ID: pid2}})
+	data, err = json.Marshal(pinfos)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, data, 0o644))
+	require.NoError(t, src.Refresh(t.Context()))
+	require.Len(t, src.List(), 2)
+}
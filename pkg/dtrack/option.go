@@ -1,12 +1,26 @@
 package dtrack
 
 import (
+	"time"
+
 	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+	defaultDepthLimit     = 5000
+	defaultUpdateInterval = 2 * time.Minute
+	defaultUpdateTimeout  = 5 * time.Minute
 )
 
 type config struct {
-	depthLimit int64
-	p2pHost    host.Host
+	concurrency int
+	depthLimit  int64
+	exclude     map[peer.ID]struct{}
+	include     map[peer.ID]struct{}
+	p2pHost     host.Host
+	timeout     time.Duration
+	updateIn    time.Duration
 }
 
 type Option func(*config)
@@ -14,7 +28,10 @@ type Option func(*config)
 // getOpts creates a config and applies Options to it.
 func getOpts(opts []Option) config {
 	cfg := config{
-		depthLimit: 5000,
+		concurrency: 1,
+		depthLimit:  defaultDepthLimit,
+		timeout:     defaultUpdateTimeout,
+		updateIn:    defaultUpdateInterval,
 	}
 	for _, opt := range opts {
 		opt(&cfg)
@@ -36,3 +53,56 @@ func WithP2pHost(p2pHost host.Host) Option {
 		c.p2pHost = p2pHost
 	}
 }
+
+// WithUpdateInterval configures the time the distance tracker waits between
+// checks for distance updates.
+func WithUpdateInterval(interval time.Duration) Option {
+	return func(c *config) {
+		if interval > 0 {
+			c.updateIn = interval
+		}
+	}
+}
+
+// WithUpdateTimeout configures the timeout for getting the distance of a single
+// provider. A value of 0 means no timeout.
+func WithUpdateTimeout(timeout time.Duration) Option {
+	return func(c *config) {
+		c.timeout = timeout
+	}
+}
+
+// WithConcurrency configures the number of providers that the distance tracker
+// updates concurrently. Values less than 1 are treated as 1.
+func WithConcurrency(n int) Option {
+	return func(c *config) {
+		c.concurrency = max(n, 1)
+	}
+}
+
+// WithInclude configures the distance tracker to only track the specified
+// providers. If none are specified, then all providers from the provider
+// source are tracked.
+func WithInclude(pids ...peer.ID) Option {
+	return func(c *config) {
+		if c.include == nil {
+			c.include = make(map[peer.ID]struct{}, len(pids))
+		}
+		for _, pid := range pids {
+			c.include[pid] = struct{}{}
+		}
+	}
+}
+
+// WithExclude configures the distance tracker to not track the specified
+// providers.
+func WithExclude(pids ...peer.ID) Option {
+	return func(c *config) {
+		if c.exclude == nil {
+			c.exclude = make(map[peer.ID]struct{}, len(pids))
+		}
+		for _, pid := range pids {
+			c.exclude[pid] = struct{}{}
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package dtrack
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/ipni/go-libipni/apierror"
+	"github.com/ipni/go-libipni/find/client"
+	"github.com/ipni/go-libipni/find/model"
+	"github.com/ipni/go-libipni/pcache"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// ProviderSource supplies the provider information that the distance tracker
+// uses to find each provider's publisher and last seen advertisement.
+//
+// A *pcache.ProviderCache satisfies this interface and can be used directly.
+type ProviderSource interface {
+	// Refresh updates the provider information held by the source.
+	Refresh(context.Context) error
+	// Get returns information for a specific provider. If the provider is not
+	// known, then nil is returned without error.
+	Get(context.Context, peer.ID) (*model.ProviderInfo, error)
+	// List returns information for all known providers.
+	List() []*model.ProviderInfo
+}
+
+var (
+	_ ProviderSource = (*pcache.ProviderCache)(nil)
+	_ ProviderSource = (*fileSource)(nil)
+	_ ProviderSource = (*indexerSource)(nil)
+)
+
+// providerMap holds a snapshot of provider information that is safe for
+// concurrent reads while being replaced by a refresh.
+type providerMap struct {
+	mutex sync.RWMutex
+	provs map[peer.ID]*model.ProviderInfo
+}
+
+func (m *providerMap) get(pid peer.ID) (*model.ProviderInfo, bool) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	pinfo, ok := m.provs[pid]
+	return pinfo, ok
+}
+
+func (m *providerMap) put(pinfo *model.ProviderInfo) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.provs == nil {
+		m.provs = make(map[peer.ID]*model.ProviderInfo)
+	}
+	m.provs[pinfo.AddrInfo.ID] = pinfo
+}
+
+func (m *providerMap) replace(pinfos []*model.ProviderInfo) {
+	provs := make(map[peer.ID]*model.ProviderInfo, len(pinfos))
+	for _, pinfo := range pinfos {
+		if pinfo == nil {
+			continue
+		}
+		provs[pinfo.AddrInfo.ID] = pinfo
+	}
+	m.mutex.Lock()
+	m.provs = provs
+	m.mutex.Unlock()
+}
+
+func (m *providerMap) list() []*model.ProviderInfo {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	pinfos := make([]*model.ProviderInfo, 0, len(m.provs))
+	for _, pinfo := range m.provs {
+		pinfos = append(pinfos, pinfo)
+	}
+	return pinfos
+}
+
+type fileSource struct {
+	providerMap
+	path string
+}
+
+// NewFileSource creates a ProviderSource that reads provider information from
+// a file. The file contains a JSON array of provider information, as returned
+// by an indexer's /providers endpoint. The file is read again each time the
+// source is refreshed.
+func NewFileSource(path string) (ProviderSource, error) {
+	src := &fileSource{
+		path: path,
+	}
+	if err := src.Refresh(context.Background()); err != nil {
+		return nil, err
+	}
+	return src, nil
+}
+
+func (s *fileSource) Refresh(_ context.Context) error {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+	var pinfos []*model.ProviderInfo
+	if err = json.Unmarshal(data, &pinfos); err != nil {
+		return fmt.Errorf("cannot decode providers file %s: %w", s.path, err)
+	}
+	s.replace(pinfos)
+	return nil
+}
+
+func (s *fileSource) Get(_ context.Context, pid peer.ID) (*model.ProviderInfo, error) {
+	pinfo, _ := s.get(pid)
+	return pinfo, nil
+}
+
+func (s *fileSource) List() []*model.ProviderInfo {
+	return s.list()
+}
+
+type indexerSource struct {
+	providerMap
+	client *client.Client
+}
+
+// NewIndexerSource creates a ProviderSource that gets provider information
+// from a single indexer. Each refresh fetches the indexer's full list of
+// providers. A provider that is not in the list is fetched individually.
+func NewIndexerSource(indexerURL string) (ProviderSource, error) {
+	cl, err := client.New(indexerURL)
+	if err != nil {
+		return nil, err
+	}
+	return &indexerSource{
+		client: cl,
+	}, nil
+}
+
+func (s *indexerSource) Refresh(ctx context.Context) error {
+	pinfos, err := s.client.ListProviders(ctx)
+	if err != nil {
+		return err
+	}
+	s.replace(pinfos)
+	return nil
+}
+
+func (s *indexerSource) Get(ctx context.Context, pid peer.ID) (*model.ProviderInfo, error) {
+	if pinfo, ok := s.get(pid); ok {
+		return pinfo, nil
+	}
+	pinfo, err := s.client.GetProvider(ctx, pid)
+	if err != nil {
+		var ae *apierror.Error
+		if errors.As(err, &ae) && ae.Status() == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	s.put(pinfo)
+	return pinfo, nil
+}
+
+func (s *indexerSource) List() []*model.ProviderInfo {
+	return s.list()
+}
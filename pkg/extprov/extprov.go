@@ -0,0 +1,144 @@
+// Package extprov describes the extended providers of a provider, as recorded
+// by an indexer, in a form that is suitable for output.
+package extprov
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ipni/go-libipni/find/model"
+	"github.com/ipni/ipni-cli/pkg/metaproto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Provider is an extended provider.
+type Provider struct {
+	ID    peer.ID  `json:"id"`
+	Addrs []string `json:"addrs"`
+	// Protocols are the metadata transport protocols that the extended
+	// provider is advertised with. This is empty if the extended provider
+	// uses the main provider's metadata.
+	Protocols []string `json:"protocols,omitempty"`
+	// Error is set if the extended provider's metadata cannot be decoded.
+	Error string `json:"error,omitempty"`
+}
+
+// Set is a set of extended providers that applies either to all of a
+// provider's content, or only to the content with its context ID.
+type Set struct {
+	// ContextID is the base64 context ID that the set applies to. It is empty
+	// for the chain-level set, which applies to all content.
+	ContextID string `json:"context_id,omitempty"`
+	// Override is true if the set replaces the chain-level set, instead of
+	// adding to it, for content with its context ID.
+	Override  bool       `json:"override"`
+	Providers []Provider `json:"providers"`
+}
+
+// Sets returns the chain-level set of extended providers, if any, followed by
+// each context-level set.
+func Sets(ep *model.ExtendedProviders) []Set {
+	if ep == nil {
+		return nil
+	}
+	var sets []Set
+	if len(ep.Providers) != 0 {
+		sets = append(sets, Set{
+			Providers: providers(ep.Providers, ep.Metadatas),
+		})
+	}
+	for _, cep := range ep.Contextual {
+		sets = append(sets, Set{
+			ContextID: cep.ContextID,
+			Override:  cep.Override,
+			Providers: providers(cep.Providers, cep.Metadatas),
+		})
+	}
+	return sets
+}
+
+// ForContext returns the sets of extended providers that apply to content
+// with the given context ID. This is the context-level set for the context ID,
+// and the chain-level set unless the context-level set overrides it.
+func ForContext(ep *model.ExtendedProviders, contextID []byte) []Set {
+	if ep == nil {
+		return nil
+	}
+	var sets []Set
+	ctxID := base64.StdEncoding.EncodeToString(contextID)
+	for _, set := range Sets(ep) {
+		if set.ContextID == ctxID && len(contextID) != 0 {
+			if set.Override {
+				return []Set{set}
+			}
+			sets = append(sets, set)
+		} else if set.ContextID == "" {
+			sets = append(sets, set)
+		}
+	}
+	return sets
+}
+
+// providers returns the extended providers, where each provider's metadata is
+// at the same index in metadatas.
+func providers(addrInfos []peer.AddrInfo, metadatas [][]byte) []Provider {
+	provs := make([]Provider, len(addrInfos))
+	for i, ai := range addrInfos {
+		provs[i].ID = ai.ID
+		provs[i].Addrs = make([]string, len(ai.Addrs))
+		for j, a := range ai.Addrs {
+			provs[i].Addrs[j] = a.String()
+		}
+		if i < len(metadatas) && len(metadatas[i]) != 0 {
+			protos, err := metaproto.Names(metadatas[i])
+			if err != nil {
+				provs[i].Error = err.Error()
+			}
+			provs[i].Protocols = protos
+		}
+	}
+	return provs
+}
+
+// IDs returns the IDs of all extended providers in the sets, without
+// duplicates.
+func IDs(sets []Set) []string {
+	var ids []string
+	seen := make(map[peer.ID]struct{})
+	for _, set := range sets {
+		for _, p := range set.Providers {
+			if _, ok := seen[p.ID]; ok {
+				continue
+			}
+			seen[p.ID] = struct{}{}
+			ids = append(ids, p.ID.String())
+		}
+	}
+	return ids
+}
+
+// Write writes the sets as text, with each line starting with indent.
+func Write(w io.Writer, sets []Set, indent string) {
+	for _, set := range sets {
+		if set.ContextID == "" {
+			fmt.Fprintf(w, "%sContextID: all\n", indent)
+		} else {
+			fmt.Fprintf(w, "%sContextID: %s\n", indent, set.ContextID)
+			fmt.Fprintf(w, "%s    Override: %t\n", indent, set.Override)
+		}
+		for _, p := range set.Providers {
+			fmt.Fprintf(w, "%s    Provider: %s\n", indent, p.ID)
+			fmt.Fprintf(w, "%s        Addresses: %s\n", indent, strings.Join(p.Addrs, " "))
+			switch {
+			case p.Error != "":
+				fmt.Fprintf(w, "%s        Protocols: error: %s\n", indent, p.Error)
+			case len(p.Protocols) == 0:
+				fmt.Fprintf(w, "%s        Protocols: same as main provider\n", indent)
+			default:
+				fmt.Fprintf(w, "%s        Protocols: %s\n", indent, strings.Join(p.Protocols, ", "))
+			}
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package extprov_test
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/ipni/go-libipni/find/model"
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/ipni/ipni-cli/pkg/extprov"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	pidA = "12D3KooWE8yt84RVwW3sFcd6WMjbUdWrZer2YtT4dmtj3dHdahSZ"
+	pidB = "12D3KooWLjeDyvuv7rbfG2wWNvWn7ybmmU88PirmSckuqCgXBAph"
+)
+
+func addrInfo(t *testing.T, pid, addr string) peer.AddrInfo {
+	id, err := peer.Decode(pid)
+	require.NoError(t, err)
+	return peer.AddrInfo{
+		ID:    id,
+		Addrs: []multiaddr.Multiaddr{multiaddr.StringCast(addr)},
+	}
+}
+
+func TestSets(t *testing.T) {
+	meta := metadata.Default.New(metadata.Bitswap{})
+	bitswap, err := meta.MarshalBinary()
+	require.NoError(t, err)
+
+	ctxAbc := base64.StdEncoding.EncodeToString([]byte("abc"))
+	ctxXyz := base64.StdEncoding.EncodeToString([]byte("xyz"))
+	ep := &model.ExtendedProviders{
+		Providers: []peer.AddrInfo{addrInfo(t, pidA, "/dns/a.example/tcp/443/https")},
+		Metadatas: [][]byte{bitswap},
+		Contextual: []model.ContextualExtendedProviders{
+			{
+				ContextID: ctxAbc,
+				Override:  true,
+				Providers: []peer.AddrInfo{addrInfo(t, pidB, "/ip4/1.2.3.4/tcp/1")},
+			},
+			{
+				ContextID: ctxXyz,
+				Providers: []peer.AddrInfo{addrInfo(t, pidA, "/ip4/5.6.7.8/tcp/2")},
+			},
+		},
+	}
+
+	sets := extprov.Sets(ep)
+	require.Len(t, sets, 3)
+	require.Empty(t, sets[0].ContextID)
+	require.Equal(t, []string{"transport-bitswap"}, sets[0].Providers[0].Protocols)
+	require.Equal(t, []string{"/dns/a.example/tcp/443/https"}, sets[0].Providers[0].Addrs)
+	require.Equal(t, ctxAbc, sets[1].ContextID)
+	require.True(t, sets[1].Override)
+	require.Empty(t, sets[1].Providers[0].Protocols)
+	require.Equal(t, []string{pidA, pidB}, extprov.IDs(sets))
+
+	// Override replaces the chain-level set.
+	sets = extprov.ForContext(ep, []byte("abc"))
+	require.Len(t, sets, 1)
+	require.Equal(t, ctxAbc, sets[0].ContextID)
+
+	// Without override, the context-level set is added to the chain-level set.
+	sets = extprov.ForContext(ep, []byte("xyz"))
+	require.Len(t, sets, 2)
+	require.Empty(t, sets[0].ContextID)
+	require.Equal(t, ctxXyz, sets[1].ContextID)
+
+	sets = extprov.ForContext(ep, []byte("other"))
+	require.Len(t, sets, 1)
+	require.Empty(t, sets[0].ContextID)
+
+	require.Nil(t, extprov.Sets(nil))
+}
@@ -0,0 +1,336 @@
+package find
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipni/go-libipni/apierror"
+	"github.com/ipni/go-libipni/find/client"
+	"github.com/ipni/go-libipni/find/model"
+	"github.com/ipni/go-libipni/pcache"
+	"github.com/ipni/ipni-cli/pkg/probe"
+	"github.com/mattn/go-isatty"
+	"github.com/multiformats/go-multihash"
+	"github.com/urfave/cli/v3"
+)
+
+// lookupFunc looks up provider results for a multihash, and calls emit with
+// the results. A streaming lookup calls emit with each provider result as it
+// arrives.
+type lookupFunc func(ctx context.Context, mh multihash.Multihash, emit emitFunc) error
+
+// emitFunc is called with lookup results, and the indexers that returned each
+// provider result if they were looked up from more than one indexer.
+type emitFunc func(*model.FindResponse, resultSources)
+
+// finderWrapper adds rate limiting and result filtering to a finder.
+type finderWrapper func(client.Finder) client.Finder
+
+// RateLimiter limits the rate of lookups made by concurrent workers. A nil
+// RateLimiter does not limit the rate.
+type RateLimiter struct {
+	ticker *time.Ticker
+	tick   <-chan time.Time
+}
+
+// NewRateLimiter creates a RateLimiter that allows rate lookups per second, or
+// returns nil if rate is not positive.
+func NewRateLimiter(rate float64) *RateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / rate))
+	return &RateLimiter{
+		ticker: ticker,
+		tick:   ticker.C,
+	}
+}
+
+// Wait waits until the next lookup is allowed, or returns the context's error
+// if the context is done first.
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	select {
+	case <-l.tick:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Stop releases the RateLimiter's resources.
+func (l *RateLimiter) Stop() {
+	if l != nil && l.ticker != nil {
+		l.ticker.Stop()
+	}
+}
+
+// rateLimitFinder waits for the rate limiter before each lookup, so that all
+// lookups sharing the same limiter do not exceed its rate.
+type rateLimitFinder struct {
+	client.Finder
+	limiter *RateLimiter
+}
+
+func (f *rateLimitFinder) Find(ctx context.Context, mh multihash.Multihash) (*model.FindResponse, error) {
+	if err := f.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return f.Finder.Find(ctx, mh)
+}
+
+func limitRate(finder client.Finder, limiter *RateLimiter) client.Finder {
+	if limiter == nil {
+		return finder
+	}
+	return &rateLimitFinder{
+		Finder:  finder,
+		limiter: limiter,
+	}
+}
+
+// ParseKey decodes a lookup key that is either a CID or a base58 multihash.
+func ParseKey(s string) (multihash.Multihash, error) {
+	c, err := cid.Decode(s)
+	if err == nil {
+		return c.Hash(), nil
+	}
+	m, mhErr := multihash.FromB58String(s)
+	if mhErr != nil {
+		return nil, fmt.Errorf("not a CID or multihash: %s", s)
+	}
+	return m, nil
+}
+
+// OpenInput opens the named file to read lookup keys from, or returns stdin if
+// the name is empty or "-".
+func OpenInput(fileName string) (io.ReadCloser, error) {
+	if fileName == "" || fileName == "-" {
+		if isatty.IsTerminal(os.Stdin.Fd()) {
+			fmt.Fprintln(os.Stderr, "Reading CIDs or multihashes from stdin. Enter one per line, or Ctrl-D to finish.")
+		}
+		return io.NopCloser(os.Stdin), nil
+	}
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open input file: %w", err)
+	}
+	return f, nil
+}
+
+// openInput returns the reader to read lookup keys from, or nil if keys are
+// only given by flags. Keys are read from stdin if --input is "-", or if no
+// keys are given by flags and stdin is not a terminal.
+func openInput(cmd *cli.Command, haveKeys bool) (io.ReadCloser, error) {
+	inFile := cmd.String("input")
+	if inFile == "" {
+		if haveKeys {
+			return nil, nil
+		}
+		if isatty.IsTerminal(os.Stdin.Fd()) {
+			return nil, cli.Exit("must specify at least one multihash or CID, or --input", 1)
+		}
+	}
+	return OpenInput(inFile)
+}
+
+// readKeys sends the multihashes from mhs, followed by those read from input,
+// to the returned channel. Lines that cannot be decoded are reported and
+// skipped. Any error reading input is written to the error channel after the
+// key channel is closed.
+func readKeys(ctx context.Context, mhs []multihash.Multihash, input io.Reader) (<-chan multihash.Multihash, <-chan error) {
+	keys := make(chan multihash.Multihash)
+	errCh := make(chan error, 1)
+
+	go func() {
+		defer close(errCh)
+		defer close(keys)
+
+		send := func(m multihash.Multihash) bool {
+			select {
+			case keys <- m:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		for _, m := range mhs {
+			if !send(m) {
+				return
+			}
+		}
+
+		if input != nil {
+			scanner := bufio.NewScanner(input)
+			var lineNum int
+			for scanner.Scan() {
+				lineNum++
+				line := strings.TrimSpace(scanner.Text())
+				if line == "" {
+					// Skip empty lines.
+					continue
+				}
+				m, err := ParseKey(line)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Skipping line %d: %s\n", lineNum, err)
+					continue
+				}
+				if !send(m) {
+					return
+				}
+			}
+			if err := scanner.Err(); err != nil {
+				errCh <- fmt.Errorf("cannot read input: %w", err)
+			}
+		}
+	}()
+
+	return keys, errCh
+}
+
+// findOne looks up the multihash, and returns an empty response if it is not
+// found.
+func findOne(ctx context.Context, finder client.Finder, mh multihash.Multihash) (*model.FindResponse, error) {
+	resp, err := finder.Find(ctx, mh)
+	if err != nil {
+		var ae *apierror.Error
+		if errors.As(err, &ae) && ae.Status() == http.StatusNotFound {
+			return &model.FindResponse{}, nil
+		}
+		return nil, err
+	}
+	if resp == nil {
+		resp = &model.FindResponse{}
+	}
+	return resp, nil
+}
+
+// findKeys looks up all keys, from flags and input, using concurrent lookups.
+// Results are printed as each lookup completes. When reading keys from input,
+// a failed lookup is reported and the remaining keys are still looked up.
+func findKeys(ctx context.Context, cmd *cli.Command, mhs []multihash.Multihash, indexer string, lookup lookupFunc) error {
+	input, err := openInput(cmd, len(mhs) != 0)
+	if err != nil {
+		return err
+	}
+	bulk := input != nil
+	if bulk {
+		defer input.Close()
+	}
+
+	concurrency := max(cmd.Int("concurrency"), 1)
+
+	var prober *probe.Prober
+	if cmd.Bool("probe") {
+		prober, err = probe.New(probe.WithTimeout(cmd.Duration("probe-timeout")))
+		if err != nil {
+			return err
+		}
+		defer prober.Close()
+	}
+
+	var extProvCache *pcache.ProviderCache
+	if cmd.Bool("extended-providers") {
+		extProvCache, err = newExtProvCache(cmd.StringSlice("indexer"))
+		if err != nil {
+			return err
+		}
+	}
+
+	parentCtx := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	keyCh, readErr := readKeys(ctx, mhs, input)
+
+	printer := newResultPrinter(cmd.String("output"), cmd.Bool("id-only"))
+	var (
+		firstErr         error
+		keys, found, bad int
+		mutex            sync.Mutex
+		wg               sync.WaitGroup
+	)
+	start := time.Now()
+
+	for range concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for mh := range keyCh {
+				var isFound bool
+				err := lookup(ctx, mh, func(resp *model.FindResponse, sources resultSources) {
+					if resp == nil || len(resp.MultihashResults) == 0 {
+						return
+					}
+					isFound = true
+					printer.print(resp, indexer, sources, probeResponse(ctx, prober, resp), lookupExtendedProviders(ctx, extProvCache, resp))
+				})
+				mutex.Lock()
+				keys++
+				if err != nil {
+					bad++
+					if firstErr == nil {
+						firstErr = err
+					}
+					mutex.Unlock()
+					if !bulk {
+						cancel()
+						return
+					}
+					if ctx.Err() == nil {
+						fmt.Fprintf(os.Stderr, "Lookup failed for %s: %s\n", mh.B58String(), err)
+					}
+					continue
+				}
+				if isFound {
+					found++
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err = <-readErr; err != nil {
+		return err
+	}
+	if parentCtx.Err() != nil {
+		// Interrupted, so complete the output of the results already printed.
+		printer.finish()
+		return parentCtx.Err()
+	}
+	if !bulk {
+		if firstErr != nil {
+			return firstErr
+		}
+		return printer.finish()
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if bad != keys {
+		if err = printer.finish(); err != nil {
+			return err
+		}
+	}
+
+	elapsed := time.Since(start)
+	fmt.Fprintf(os.Stderr, "Looked up %d keys in %s (%.1f keys/s): %d found, %d not found, %d failed\n",
+		keys, elapsed.Round(time.Millisecond), float64(keys)/elapsed.Seconds(), found, keys-found-bad, bad)
+	if bad != 0 {
+		return fmt.Errorf("lookup failed for %d keys: %w", bad, firstErr)
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package find
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipni/go-libipni/apierror"
+	"github.com/ipni/go-libipni/find/model"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func testMultihash(t *testing.T, data string) multihash.Multihash {
+	mh, err := multihash.Sum([]byte(data), multihash.SHA2_256, -1)
+	require.NoError(t, err)
+	return mh
+}
+
+// fakeFinder returns a fixed response or error, and counts lookups.
+type fakeFinder struct {
+	resp  *model.FindResponse
+	err   error
+	finds int
+}
+
+func (f *fakeFinder) Find(_ context.Context, mh multihash.Multihash) (*model.FindResponse, error) {
+	f.finds++
+	return f.resp, f.err
+}
+
+func TestReadKeys(t *testing.T) {
+	mhA := testMultihash(t, "a")
+	mhB := testMultihash(t, "b")
+	mhC := testMultihash(t, "c")
+	input := strings.Join([]string{
+		cid.NewCidV1(cid.Raw, mhB).String(),
+		"",
+		"not a key",
+		"  " + mhC.B58String() + "  ",
+	}, "\n")
+
+	keys, readErr := readKeys(t.Context(), []multihash.Multihash{mhA}, strings.NewReader(input))
+	var got []multihash.Multihash
+	for mh := range keys {
+		got = append(got, mh)
+	}
+	require.NoError(t, <-readErr)
+	// Keys from flags are first, and lines that are not keys are skipped.
+	require.Equal(t, []multihash.Multihash{mhA, mhB, mhC}, got)
+}
+
+func TestReadKeysCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(t.Context())
+	keys, readErr := readKeys(ctx, []multihash.Multihash{testMultihash(t, "a"), testMultihash(t, "b")}, nil)
+	<-keys
+	cancel()
+	// Reading stops without sending the remaining keys.
+	for range keys {
+	}
+	require.NoError(t, <-readErr)
+}
+
+func TestLimitRate(t *testing.T) {
+	finder := &fakeFinder{resp: &model.FindResponse{}}
+	require.Same(t, finder, limitRate(finder, nil))
+
+	tick := make(chan time.Time)
+	limited := limitRate(finder, &RateLimiter{tick: tick})
+	mh := testMultihash(t, "a")
+
+	done := make(chan error)
+	go func() {
+		_, err := limited.Find(t.Context(), mh)
+		done <- err
+	}()
+	select {
+	case <-done:
+		t.Fatal("lookup did not wait for tick")
+	case <-time.After(50 * time.Millisecond):
+	}
+	tick <- time.Now()
+	require.NoError(t, <-done)
+	require.Equal(t, 1, finder.finds)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	_, err := limited.Find(ctx, mh)
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, 1, finder.finds)
+
+	// A nil limiter does not wait.
+	var limiter *RateLimiter
+	require.NoError(t, limiter.Wait(ctx))
+	limiter.Stop()
+}
+
+func TestNewRateLimiter(t *testing.T) {
+	require.Nil(t, NewRateLimiter(0))
+	require.Nil(t, NewRateLimiter(-1))
+
+	limiter := NewRateLimiter(1000)
+	defer limiter.Stop()
+	start := time.Now()
+	for range 3 {
+		require.NoError(t, limiter.Wait(t.Context()))
+	}
+	require.GreaterOrEqual(t, time.Since(start), 2*time.Millisecond)
+}
+
+func TestDHLookupFallback(t *testing.T) {
+	mh := testMultihash(t, "a")
+	found := &model.FindResponse{
+		MultihashResults: []model.MultihashResult{{Multihash: mh}},
+	}
+	tests := []struct {
+		name      string
+		dhErr     error
+		dhResp    *model.FindResponse
+		wantClear bool
+		wantErr   bool
+	}{
+		{name: "found", dhResp: found},
+		// An empty result must not repeat the lookup without reader-privacy.
+		{name: "empty", dhResp: &model.FindResponse{}},
+		{name: "not found", dhErr: apierror.New(errors.New("not found"), http.StatusNotFound)},
+		{name: "not implemented", dhErr: apierror.New(errors.New("not implemented"), http.StatusNotImplemented), wantClear: true},
+		{name: "method not allowed", dhErr: apierror.New(errors.New("method not allowed"), http.StatusMethodNotAllowed), wantClear: true},
+		{name: "bad request", dhErr: apierror.New(errors.New("bad request"), http.StatusBadRequest), wantClear: true},
+		{name: "server error", dhErr: apierror.New(errors.New("server error"), http.StatusInternalServerError), wantErr: true},
+		{name: "other error", dhErr: errors.New("connection refused"), wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			dh := &fakeFinder{resp: tc.dhResp, err: tc.dhErr}
+			clear := &fakeFinder{resp: found}
+
+			var emitted []*model.FindResponse
+			err := dhLookup(dh, clear)(t.Context(), mh, func(resp *model.FindResponse, _ resultSources) {
+				emitted = append(emitted, resp)
+			})
+			if tc.wantErr {
+				require.Error(t, err)
+				require.Empty(t, emitted)
+				require.Zero(t, clear.finds)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, 1, dh.finds)
+			require.Len(t, emitted, 1)
+			if tc.wantClear {
+				require.Equal(t, 1, clear.finds)
+				require.Equal(t, found, emitted[0])
+				return
+			}
+			require.Zero(t, clear.finds)
+			if tc.dhResp != nil {
+				require.Equal(t, tc.dhResp, emitted[0])
+			} else {
+				require.Empty(t, emitted[0].MultihashResults)
+			}
+		})
+	}
+
+	// Without a fallback finder, an unsupported error is returned.
+	dh := &fakeFinder{err: apierror.New(errors.New("not implemented"), http.StatusNotImplemented)}
+	err := dhLookup(dh, nil)(t.Context(), mh, func(*model.FindResponse, resultSources) {})
+	require.Error(t, err)
+}
@@ -0,0 +1,312 @@
+package find
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/ipni/go-libipni/apierror"
+	"github.com/ipni/go-libipni/find/client"
+	"github.com/multiformats/go-multihash"
+	"github.com/urfave/cli/v3"
+)
+
+// compareSource is an indexer, or dhstore, that is queried for comparison.
+type compareSource struct {
+	name   string
+	finder client.Finder
+}
+
+// compareRecord is the result of comparing the providers that each indexer
+// returned for a multihash.
+type compareRecord struct {
+	Multihash string `json:"multihash"`
+	// Providers maps each provider ID to the indexers that returned it.
+	Providers map[string][]string `json:"providers"`
+	// Missing maps each provider ID to the indexers that did not return it.
+	Missing map[string][]string `json:"missing,omitempty"`
+	// Errors maps each indexer that failed to its error.
+	Errors map[string]string `json:"errors,omitempty"`
+	Agree  bool              `json:"agree"`
+}
+
+func compareSources(cmd *cli.Command, wrap finderWrapper) ([]compareSource, error) {
+	indexers := cmd.StringSlice("indexer")
+	dhstore := cmd.String("dhstore")
+
+	apis := cmd.StringSlice("api")
+
+	sources := make([]compareSource, 0, len(indexers)*len(apis)+1)
+	for _, idxr := range indexers {
+		for _, api := range apis {
+			var finder client.Finder
+			var err error
+			if api == apiIPNI && cmd.Bool("private") && dhstore == "" {
+				finder, err = client.NewDHashClient(
+					client.WithProvidersURL(idxr),
+					client.WithDHStoreURL(idxr),
+					client.WithPcacheTTL(0),
+				)
+			} else {
+				finder, err = newIndexerFinder(api, idxr)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("cannot create client for %s: %w", idxr, err)
+			}
+			name := idxr
+			if len(apis) > 1 {
+				name = fmt.Sprintf("%s (%s)", idxr, api)
+			}
+			sources = append(sources, compareSource{
+				name:   name,
+				finder: wrap(finder),
+			})
+		}
+	}
+	if dhstore != "" {
+		cl, err := client.NewDHashClient(
+			client.WithProvidersURL(indexers...),
+			client.WithDHStoreURL(dhstore),
+			client.WithPcacheTTL(0),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create client for %s: %w", dhstore, err)
+		}
+		sources = append(sources, compareSource{
+			name:   dhstore,
+			finder: wrap(cl),
+		})
+	}
+	if len(sources) < 2 {
+		return nil, errors.New("--compare requires at least two indexers, an indexer and a dhstore, or two APIs")
+	}
+	return sources, nil
+}
+
+// compareFind queries every indexer, and dhstore, in parallel for each key and
+// reports which indexers returned which providers.
+func compareFind(ctx context.Context, cmd *cli.Command, mhs []multihash.Multihash, wrap finderWrapper) error {
+	sources, err := compareSources(cmd, wrap)
+	if err != nil {
+		return err
+	}
+
+	input, err := openInput(cmd, len(mhs) != 0)
+	if err != nil {
+		return err
+	}
+	if input != nil {
+		defer input.Close()
+	}
+
+	keys, readErr := readKeys(ctx, mhs, input)
+
+	printer := newComparePrinter(cmd.String("output"))
+	var (
+		compared, disagree int
+		mutex              sync.Mutex
+		wg                 sync.WaitGroup
+	)
+	for range max(cmd.Int("concurrency"), 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for m := range keys {
+				rec := compareOne(ctx, sources, m)
+				if ctx.Err() != nil {
+					return
+				}
+				mutex.Lock()
+				compared++
+				if !rec.Agree {
+					disagree++
+				}
+				mutex.Unlock()
+				printer.print(rec)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err = <-readErr; err != nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err = printer.finish(); err != nil {
+		return err
+	}
+
+	names := make([]string, len(sources))
+	for i := range sources {
+		names[i] = sources[i].name
+	}
+	fmt.Fprintf(os.Stderr, "Compared %d multihashes across %s: %d agree, %d disagree\n",
+		compared, strings.Join(names, ", "), compared-disagree, disagree)
+	return nil
+}
+
+// compareOne looks up the multihash at all sources concurrently and compares
+// the sets of providers returned.
+func compareOne(ctx context.Context, sources []compareSource, m multihash.Multihash) compareRecord {
+	found := make([]map[string]struct{}, len(sources))
+	errs := make([]error, len(sources))
+
+	var wg sync.WaitGroup
+	for i, src := range sources {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := src.finder.Find(ctx, m)
+			if err != nil {
+				var ae *apierror.Error
+				if !errors.As(err, &ae) || ae.Status() != http.StatusNotFound {
+					errs[i] = err
+					return
+				}
+			}
+			pids := make(map[string]struct{})
+			if resp != nil {
+				for _, mhr := range resp.MultihashResults {
+					for _, pr := range mhr.ProviderResults {
+						if pr.Provider != nil {
+							pids[pr.Provider.ID.String()] = struct{}{}
+						}
+					}
+				}
+			}
+			found[i] = pids
+		}()
+	}
+	wg.Wait()
+
+	rec := compareRecord{
+		Multihash: m.B58String(),
+		Providers: make(map[string][]string),
+	}
+	for i, src := range sources {
+		if errs[i] != nil {
+			if rec.Errors == nil {
+				rec.Errors = make(map[string]string)
+			}
+			rec.Errors[src.name] = errs[i].Error()
+			continue
+		}
+		for pid := range found[i] {
+			rec.Providers[pid] = append(rec.Providers[pid], src.name)
+		}
+	}
+	for pid := range rec.Providers {
+		for i, src := range sources {
+			if errs[i] != nil {
+				continue
+			}
+			if _, ok := found[i][pid]; !ok {
+				if rec.Missing == nil {
+					rec.Missing = make(map[string][]string)
+				}
+				rec.Missing[pid] = append(rec.Missing[pid], src.name)
+			}
+		}
+	}
+	rec.Agree = len(rec.Missing) == 0 && len(rec.Errors) == 0
+	return rec
+}
+
+// comparePrinter prints comparison results from concurrent lookups.
+type comparePrinter struct {
+	format  string
+	mutex   sync.Mutex
+	records []compareRecord
+	csvw    *csv.Writer
+	jsonEnc *json.Encoder
+}
+
+func newComparePrinter(format string) *comparePrinter {
+	p := &comparePrinter{
+		format: format,
+	}
+	switch format {
+	case outputNDJSON:
+		p.jsonEnc = json.NewEncoder(os.Stdout)
+	case outputCSV:
+		p.csvw = csv.NewWriter(os.Stdout)
+		p.csvw.Write([]string{"multihash", "provider_id", "found_at", "missing_from", "agree", "error"})
+	}
+	return p
+}
+
+func (p *comparePrinter) print(rec compareRecord) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	switch p.format {
+	case outputJSON:
+		p.records = append(p.records, rec)
+	case outputNDJSON:
+		p.jsonEnc.Encode(rec)
+	case outputCSV:
+		for _, pid := range slices.Sorted(maps.Keys(rec.Providers)) {
+			p.csvw.Write([]string{
+				rec.Multihash,
+				pid,
+				strings.Join(rec.Providers[pid], " "),
+				strings.Join(rec.Missing[pid], " "),
+				fmt.Sprint(len(rec.Missing[pid]) == 0),
+				"",
+			})
+		}
+		for _, name := range slices.Sorted(maps.Keys(rec.Errors)) {
+			p.csvw.Write([]string{rec.Multihash, "", "", name, "false", rec.Errors[name]})
+		}
+		p.csvw.Flush()
+	default:
+		if rec.Agree {
+			fmt.Println("Multihash:", rec.Multihash)
+		} else {
+			fmt.Println("Multihash:", rec.Multihash, "(DISAGREE)")
+		}
+		if len(rec.Providers) == 0 && len(rec.Errors) == 0 {
+			fmt.Println("  index not found at any indexer")
+		}
+		for _, pid := range slices.Sorted(maps.Keys(rec.Providers)) {
+			fmt.Println("  Provider:", pid)
+			if missing := rec.Missing[pid]; len(missing) != 0 {
+				fmt.Println("    Found at:    ", strings.Join(rec.Providers[pid], ", "))
+				fmt.Println("    Missing from:", strings.Join(missing, ", "))
+			} else {
+				fmt.Println("    Found at all indexers")
+			}
+		}
+		for _, name := range slices.Sorted(maps.Keys(rec.Errors)) {
+			fmt.Printf("  Error from %s: %s\n", name, rec.Errors[name])
+		}
+	}
+}
+
+func (p *comparePrinter) finish() error {
+	switch p.format {
+	case outputJSON:
+		if p.records == nil {
+			p.records = []compareRecord{}
+		}
+		data, err := json.MarshalIndent(p.records, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+	case outputCSV:
+		p.csvw.Flush()
+		return p.csvw.Error()
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package find
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ipni/go-libipni/find/model"
+	"github.com/ipni/go-libipni/pcache"
+	"github.com/ipni/ipni-cli/pkg/extprov"
+)
+
+// responseExtProvs holds the extended providers that apply to each provider
+// result in a find response, indexed by multihash result and then by provider
+// result.
+type responseExtProvs [][][]extprov.Set
+
+// newExtProvCache creates the provider cache used to look up the extended
+// providers of find results from the indexers.
+func newExtProvCache(indexers []string) (*pcache.ProviderCache, error) {
+	pc, err := pcache.New(pcache.WithPreload(false), pcache.WithRefreshInterval(0),
+		pcache.WithSourceURL(indexers...))
+	if err != nil {
+		return nil, fmt.Errorf("cannot create provider cache: %w", err)
+	}
+	return pc, nil
+}
+
+// lookupExtendedProviders gets the provider information for each provider in
+// the response, and returns the extended providers that apply to the context
+// ID of each provider result. A provider that cannot be looked up has no
+// extended providers.
+func lookupExtendedProviders(ctx context.Context, pc *pcache.ProviderCache, resp *model.FindResponse) responseExtProvs {
+	if pc == nil || resp == nil {
+		return nil
+	}
+
+	extProvs := make(responseExtProvs, len(resp.MultihashResults))
+	for i, mhr := range resp.MultihashResults {
+		extProvs[i] = make([][]extprov.Set, len(mhr.ProviderResults))
+		for j, pr := range mhr.ProviderResults {
+			if pr.Provider == nil {
+				continue
+			}
+			pinfo, err := pc.Get(ctx, pr.Provider.ID)
+			if err != nil || pinfo == nil {
+				continue
+			}
+			extProvs[i][j] = extprov.ForContext(pinfo.ExtendedProviders, pr.ContextID)
+		}
+	}
+	return extProvs
+}
+
+// get returns the extended providers for a provider result, or nil if not
+// looking them up.
+func (re responseExtProvs) get(mhIndex, prIndex int) []extprov.Set {
+	if re == nil {
+		return nil
+	}
+	return re[mhIndex][prIndex]
+}
@@ -0,0 +1,228 @@
+package find
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"slices"
+
+	"github.com/ipni/go-libipni/find/client"
+	"github.com/ipni/go-libipni/find/model"
+	"github.com/ipni/ipni-cli/pkg/metaproto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+	"github.com/urfave/cli/v3"
+)
+
+// protocolNames maps short transport protocol names to metadata protocol IDs.
+var protocolNames = map[string][]multicodec.Code{
+	"bitswap":   {multicodec.TransportBitswap},
+	"graphsync": {multicodec.TransportGraphsyncFilecoinv1},
+	"http":      {multicodec.TransportIpfsGatewayHttp, multicodec.Http},
+	"piece":     {multicodec.TransportFilecoinPieceHttp},
+}
+
+// asyncFinder is implemented by finders that return provider results as they
+// are found.
+type asyncFinder interface {
+	FindAsync(context.Context, multihash.Multihash, chan<- model.ProviderResult) error
+}
+
+// resultFilter selects which provider results to keep from find responses.
+type resultFilter struct {
+	include    map[peer.ID]struct{}
+	exclude    map[peer.ID]struct{}
+	protocols  []multicodec.Code
+	contextIDs [][]byte
+	first      bool
+}
+
+// newResultFilter creates a resultFilter from the command flags, or returns nil
+// if no filtering is needed.
+func newResultFilter(cmd *cli.Command) (*resultFilter, error) {
+	f := &resultFilter{
+		first: cmd.Bool("first"),
+	}
+
+	var err error
+	f.include, err = decodePeerIDs(cmd.StringSlice("provider"))
+	if err != nil {
+		return nil, err
+	}
+	f.exclude, err = decodePeerIDs(cmd.StringSlice("exclude-provider"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range cmd.StringSlice("protocol") {
+		codes, ok := protocolNames[name]
+		if !ok {
+			var code multicodec.Code
+			if err = code.Set(name); err != nil {
+				return nil, fmt.Errorf("unknown protocol %q: use bitswap, graphsync, http, piece, or a multicodec name", name)
+			}
+			codes = []multicodec.Code{code}
+		}
+		f.protocols = append(f.protocols, codes...)
+	}
+
+	for _, ctxIDStr := range cmd.StringSlice("context-id") {
+		ctxID, err := base64.StdEncoding.DecodeString(ctxIDStr)
+		if err != nil {
+			return nil, fmt.Errorf("context ID %q is not base64: %w", ctxIDStr, err)
+		}
+		f.contextIDs = append(f.contextIDs, ctxID)
+	}
+
+	if !f.selects() && !f.first {
+		return nil, nil
+	}
+	return f, nil
+}
+
+// selects returns true if the filter selects results by provider, context ID,
+// or protocol, and not only by --first.
+func (f *resultFilter) selects() bool {
+	return f.include != nil || f.exclude != nil || f.protocols != nil || f.contextIDs != nil
+}
+
+func decodePeerIDs(pidStrs []string) (map[peer.ID]struct{}, error) {
+	if len(pidStrs) == 0 {
+		return nil, nil
+	}
+	pids := make(map[peer.ID]struct{}, len(pidStrs))
+	for _, pidStr := range pidStrs {
+		pid, err := peer.Decode(pidStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid peer ID %s: %w", pidStr, err)
+		}
+		pids[pid] = struct{}{}
+	}
+	return pids, nil
+}
+
+// match returns true if the provider result passes the filter. A result
+// without a provider only passes a filter that does not select results.
+func (f *resultFilter) match(pr model.ProviderResult) bool {
+	if pr.Provider == nil {
+		return !f.selects()
+	}
+	if f.include != nil {
+		if _, ok := f.include[pr.Provider.ID]; !ok {
+			return false
+		}
+	}
+	if _, ok := f.exclude[pr.Provider.ID]; ok {
+		return false
+	}
+	if f.contextIDs != nil && !slices.ContainsFunc(f.contextIDs, func(ctxID []byte) bool {
+		return bytes.Equal(ctxID, pr.ContextID)
+	}) {
+		return false
+	}
+	if f.protocols != nil {
+		codes, err := metaproto.Codes(pr.Metadata)
+		if err != nil {
+			return false
+		}
+		if !slices.ContainsFunc(codes, func(code multicodec.Code) bool {
+			return slices.Contains(f.protocols, code)
+		}) {
+			return false
+		}
+	}
+	return true
+}
+
+// apply removes the provider results that do not pass the filter. Multihash
+// results left without any provider results are removed.
+func (f *resultFilter) apply(resp *model.FindResponse) *model.FindResponse {
+	if resp == nil {
+		return nil
+	}
+	mhrs := resp.MultihashResults[:0]
+	for _, mhr := range resp.MultihashResults {
+		prs := mhr.ProviderResults[:0]
+		for _, pr := range mhr.ProviderResults {
+			if !f.match(pr) {
+				continue
+			}
+			prs = append(prs, pr)
+			if f.first {
+				break
+			}
+		}
+		if len(prs) == 0 {
+			continue
+		}
+		mhr.ProviderResults = prs
+		mhrs = append(mhrs, mhr)
+	}
+	resp.MultihashResults = mhrs
+	return resp
+}
+
+// filterFinder applies a resultFilter to the results of each lookup.
+type filterFinder struct {
+	client.Finder
+	filter *resultFilter
+}
+
+func filterResults(finder client.Finder, filter *resultFilter) client.Finder {
+	if filter == nil {
+		return finder
+	}
+	return &filterFinder{
+		Finder: finder,
+		filter: filter,
+	}
+}
+
+func (f *filterFinder) Find(ctx context.Context, mh multihash.Multihash) (*model.FindResponse, error) {
+	if af, ok := f.Finder.(asyncFinder); ok && f.filter.first {
+		return f.findFirst(ctx, af, mh)
+	}
+	resp, err := f.Finder.Find(ctx, mh)
+	if err != nil {
+		return nil, err
+	}
+	return f.filter.apply(resp), nil
+}
+
+// findFirst stops reading results as soon as one provider result matches, so
+// that remaining results are not fetched.
+func (f *filterFinder) findFirst(ctx context.Context, af asyncFinder, mh multihash.Multihash) (*model.FindResponse, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	resChan := make(chan model.ProviderResult)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- af.FindAsync(ctx, mh, resChan)
+	}()
+
+	var found *model.ProviderResult
+	for pr := range resChan {
+		if found == nil && f.filter.match(pr) {
+			found = &pr
+			cancel()
+		}
+	}
+	err := <-errChan
+	if found != nil {
+		return &model.FindResponse{
+			MultihashResults: []model.MultihashResult{
+				{
+					Multihash:       mh,
+					ProviderResults: []model.ProviderResult{*found},
+				},
+			},
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &model.FindResponse{}, nil
+}
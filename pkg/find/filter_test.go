@@ -0,0 +1,180 @@
+package find
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+
+	"github.com/ipni/go-libipni/find/model"
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v3"
+)
+
+// parseFilter parses the filter flags from args, and returns the resultFilter
+// created from them. The flags are created for each call, since cli flags keep
+// their values after a command runs.
+func parseFilter(ctx context.Context, args ...string) (*resultFilter, error) {
+	var filter *resultFilter
+	cmd := &cli.Command{
+		Name: "find",
+		Flags: []cli.Flag{
+			&cli.StringSliceFlag{Name: "provider"},
+			&cli.StringSliceFlag{Name: "exclude-provider"},
+			&cli.StringSliceFlag{Name: "protocol"},
+			&cli.StringSliceFlag{Name: "context-id"},
+			&cli.BoolFlag{Name: "first"},
+		},
+		Action: func(_ context.Context, cmd *cli.Command) error {
+			var err error
+			filter, err = newResultFilter(cmd)
+			return err
+		},
+	}
+	if err := cmd.Run(ctx, append([]string{"find"}, args...)); err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+// resultIDs returns the provider ID and context ID of each result.
+func resultIDs(resp *model.FindResponse) []string {
+	var ids []string
+	for _, mhr := range resp.MultihashResults {
+		for _, pr := range mhr.ProviderResults {
+			ids = append(ids, pr.Provider.ID.String()+"/"+string(pr.ContextID))
+		}
+	}
+	return ids
+}
+
+func TestResultFilter(t *testing.T) {
+	bitswap := encodeMetadata(t, &metadata.Bitswap{})
+	gateway := encodeMetadata(t, &metadata.IpfsGatewayHttp{})
+	both := encodeMetadata(t, &metadata.Bitswap{}, &metadata.IpfsGatewayHttp{})
+
+	mh1 := testMultihash(t, "one")
+	mh2 := testMultihash(t, "two")
+	newResponse := func() *model.FindResponse {
+		return &model.FindResponse{
+			MultihashResults: []model.MultihashResult{
+				{
+					Multihash: mh1,
+					ProviderResults: []model.ProviderResult{
+						providerResult(t, pidA, "a1", bitswap),
+						providerResult(t, pidB, "b1", gateway),
+						providerResult(t, pidA, "a2", both),
+						providerResult(t, pidC, "c1", []byte{0xff}),
+					},
+				},
+				{
+					Multihash: mh2,
+					ProviderResults: []model.ProviderResult{
+						providerResult(t, pidC, "c2", bitswap),
+					},
+				},
+			},
+		}
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{
+			name: "provider",
+			args: []string{"--provider", pidA},
+			want: []string{pidA + "/a1", pidA + "/a2"},
+		},
+		{
+			name: "exclude provider",
+			args: []string{"--exclude-provider", pidA, "--exclude-provider", pidC},
+			want: []string{pidB + "/b1"},
+		},
+		{
+			name: "protocol",
+			args: []string{"--protocol", "http"},
+			want: []string{pidB + "/b1", pidA + "/a2"},
+		},
+		{
+			name: "protocol multicodec name",
+			args: []string{"--protocol", "transport-bitswap"},
+			want: []string{pidA + "/a1", pidA + "/a2", pidC + "/c2"},
+		},
+		{
+			name: "context ID",
+			args: []string{"--context-id", base64.StdEncoding.EncodeToString([]byte("c2"))},
+			want: []string{pidC + "/c2"},
+		},
+		{
+			name: "provider and protocol",
+			args: []string{"--provider", pidA, "--protocol", "http"},
+			want: []string{pidA + "/a2"},
+		},
+		{
+			name: "first",
+			args: []string{"--first"},
+			want: []string{pidA + "/a1", pidC + "/c2"},
+		},
+		{
+			name: "first with protocol",
+			args: []string{"--first", "--protocol", "http"},
+			want: []string{pidB + "/b1"},
+		},
+		{
+			name: "first with exclude provider",
+			args: []string{"--first", "--exclude-provider", pidA},
+			want: []string{pidB + "/b1", pidC + "/c2"},
+		},
+		{
+			name: "no match",
+			args: []string{"--provider", pidB, "--protocol", "bitswap"},
+			want: nil,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			filter, err := parseFilter(t.Context(), tc.args...)
+			require.NoError(t, err)
+			require.NotNil(t, filter)
+			resp := filter.apply(newResponse())
+			require.Equal(t, tc.want, resultIDs(resp))
+			for _, mhr := range resp.MultihashResults {
+				require.NotEmpty(t, mhr.ProviderResults)
+			}
+		})
+	}
+
+	filter, err := parseFilter(t.Context())
+	require.NoError(t, err)
+	require.Nil(t, filter, "no filter expected without filter flags")
+
+	_, err = parseFilter(t.Context(), "--protocol", "no-such-protocol")
+	require.ErrorContains(t, err, "unknown protocol")
+}
+
+func TestResultFilterNilProvider(t *testing.T) {
+	noProvider := model.ProviderResult{
+		ContextID: []byte("c1"),
+		Metadata:  encodeMetadata(t, &metadata.Bitswap{}),
+	}
+	tests := []struct {
+		name string
+		args []string
+		want bool
+	}{
+		{name: "first", args: []string{"--first"}, want: true},
+		{name: "provider", args: []string{"--provider", pidA}},
+		{name: "exclude provider", args: []string{"--exclude-provider", pidA}},
+		{name: "protocol", args: []string{"--protocol", "bitswap"}},
+		{name: "context ID", args: []string{"--context-id", base64.StdEncoding.EncodeToString([]byte("c1"))}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			filter, err := parseFilter(t.Context(), tc.args...)
+			require.NoError(t, err)
+			require.Equal(t, tc.want, filter.match(noProvider))
+		})
+	}
+}
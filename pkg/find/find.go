@@ -3,14 +3,20 @@ package find
 import (
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
 	"strings"
+	"time"
 
 	"github.com/ipfs/go-cid"
+	"github.com/ipni/go-libipni/apierror"
 	"github.com/ipni/go-libipni/find/client"
 	"github.com/ipni/go-libipni/find/model"
-	"github.com/ipni/go-libipni/metadata"
-	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/ipni/ipni-cli/pkg/extprov"
+	"github.com/ipni/ipni-cli/pkg/metaproto"
 	"github.com/multiformats/go-multihash"
 	"github.com/urfave/cli/v3"
 )
@@ -20,8 +26,28 @@ var FindCmd = &cli.Command{
 	Usage: "Lookup storage provider data by CID or multihash at indexer",
 	Description: `The find command queries an indexer, using the supplied CIDs or multihashes as lookup keys, for the storage provider data needed to retrieve the content identified by the keys.
 
+If no CID or multihash is given with flags, then lookup keys are read from stdin, one per line, when stdin is not a terminal. Keys may also be read from a file using --input, or from stdin using --input -. Each key may be either a CID or a base58 multihash. Keys are looked up concurrently at a limited rate, with results printed as each lookup completes. This allows checking large numbers of CIDs from a dataset.
+
+The --output flag selects json, ndjson, or csv output instead of text. These write one record for each provider result of each multihash, containing the provider ID, addresses, context ID, decoded metadata, and the indexer that answered.
+
+The --compare flag queries every indexer given by --indexer, and the dhstore if given, in parallel for the same keys. For each multihash it reports which indexers returned each provider, and marks where the indexers disagree. This is useful for finding replication gaps between indexers.
+
+Results can be filtered by provider ID using --provider and --exclude-provider, by metadata transport protocol using --protocol, and by context ID using --context-id. The --first flag keeps only the first matching provider result for each multihash. When using reader privacy, this stops fetching results once a match is found. The --first flag cannot be used with --compare, since indexers that return the same providers may return them in a different order.
+
+The --merge flag queries every indexer given by --indexer in parallel, and merges their results. Provider results with the same provider ID and context ID are shown once, annotated with all the indexers that returned them. If some indexers fail, the lookup succeeds using the results from the others, and the failures are reported. Without --merge, only the first indexer is queried unless using reader privacy.
+
+The --api flag selects which HTTP API to query. The default, ipni, uses the indexer's native /multihash API. The routing-v1 API uses the IPFS delegated routing /routing/v1/providers endpoint, and reads its JSON or NDJSON response into the same results. Delegated routing responses do not have context IDs, and only have metadata for protocols that the indexer includes it for. Giving --api more than once with --compare queries each indexer using each API, to check that an indexer's delegated routing endpoint agrees with its native find API.
+
+The --stream flag requests a streaming NDJSON response from the indexer, and prints each provider result as soon as it arrives instead of waiting for the whole response. This is useful for CIDs that have thousands of provider records. The --timeout flag limits the time for each lookup, and an interrupt stops all lookups after completing the output of results already received.
+
+The --extended-providers flag gets the information for each provider in the results from the indexer, and shows the extended providers that apply to each result's context ID. These are the provider's chain-level extended providers, and those for the result's context ID, unless the context-level extended providers override the chain-level ones. Each extended provider is shown with its addresses and metadata protocols.
+
+The --probe flag checks that the content is retrievable from each provider result, using the protocols named in its metadata. IPFS trustless gateway providers are sent an HTTP HEAD request, and Bitswap providers are sent a want-have. The status and latency of each probe is shown with the result.
+
 Example usage:
-	ipni find -i https://cid.contact --cid bafybeigvgzoolc3drupxhlevdp2ugqcrbcsqfmcek2zxiw5wctk3xjpjwy`,
+	ipni find -i https://cid.contact --cid bafybeigvgzoolc3drupxhlevdp2ugqcrbcsqfmcek2zxiw5wctk3xjpjwy
+	ipni find -i https://cid.contact --input cids.txt --concurrency 8 --rate 200
+	ipni find -i https://cid.contact --compare --api ipni --api routing-v1 --input cids.txt`,
 	Flags:  findFlags,
 	Before: beforeFind,
 	Action: findAction,
@@ -53,13 +79,87 @@ var findFlags = []cli.Flag{
 		Name:  "id-only",
 		Usage: "Only show provider's peer ID from each result",
 	},
+	&cli.StringFlag{
+		Name:    "output",
+		Usage:   "Output format: text, json, ndjson, or csv. Structured formats have one record per provider result for each multihash.",
+		Aliases: []string{"o"},
+		Value:   outputText,
+	},
+	&cli.BoolFlag{
+		Name:  "merge",
+		Usage: "Query all indexers and merge their results, removing duplicate provider results",
+	},
+	&cli.StringSliceFlag{
+		Name:  "api",
+		Usage: "Find API to query: ipni for the native /multihash API, or routing-v1 for the delegated routing /routing/v1/providers API. Multiple OK with --compare.",
+		Value: []string{apiIPNI},
+	},
 	&cli.BoolFlag{
 		Name:  "private",
 		Usage: "Use reader-privacy for queries",
 	},
 	&cli.BoolFlag{
 		Name:  "fallback",
-		Usage: "Do non-private query only if the indexer does not support reader-privacy",
+		Usage: "Do non-private query only if the indexer does not support reader-privacy. A private query that finds nothing is not repeated.",
+	},
+	&cli.BoolFlag{
+		Name:  "compare",
+		Usage: "Query all indexers, and dhstore, and compare the providers each returns",
+	},
+	&cli.StringSliceFlag{
+		Name:  "provider",
+		Usage: "Only show results from the provider with this peer ID, multiple OK",
+	},
+	&cli.StringSliceFlag{
+		Name:  "exclude-provider",
+		Usage: "Do not show results from the provider with this peer ID, multiple OK",
+	},
+	&cli.StringSliceFlag{
+		Name:  "protocol",
+		Usage: "Only show results with this metadata transport protocol: bitswap, graphsync, http, piece, or a multicodec name. Multiple OK",
+	},
+	&cli.StringSliceFlag{
+		Name:  "context-id",
+		Usage: "Only show results with this base64 context ID, multiple OK",
+	},
+	&cli.BoolFlag{
+		Name:  "first",
+		Usage: "Stop at the first provider result that passes all filters for each multihash",
+	},
+	&cli.BoolFlag{
+		Name:  "stream",
+		Usage: "Request a streaming NDJSON response and print each provider result as it arrives",
+	},
+	&cli.DurationFlag{
+		Name:  "timeout",
+		Usage: "Maximum time for each lookup. 0 for no limit.",
+	},
+	&cli.BoolFlag{
+		Name:  "probe",
+		Usage: "Check that each provider result is retrievable using its metadata protocol: trustless gateway HTTP or Bitswap",
+	},
+	&cli.DurationFlag{
+		Name:  "probe-timeout",
+		Usage: "Time to wait for each retrieval probe",
+		Value: 10 * time.Second,
+	},
+	&cli.BoolFlag{
+		Name:  "extended-providers",
+		Usage: "Look up each provider's information from the indexer, and show the extended providers that apply to each result",
+	},
+	&cli.StringFlag{
+		Name:    "input",
+		Usage:   "File to read CIDs or multihashes from, one per line. Use \"-\" for stdin.",
+		Aliases: []string{"f"},
+	},
+	&cli.IntFlag{
+		Name:  "concurrency",
+		Usage: "Number of keys to look up concurrently",
+		Value: 4,
+	},
+	&cli.Float64Flag{
+		Name:  "rate",
+		Usage: "Maximum number of lookups per second. 0 for unlimited.",
 	},
 }
 
@@ -75,6 +175,50 @@ func beforeFind(ctx context.Context, cmd *cli.Command) (context.Context, error)
 	if cmd.String("dhstore") != "" {
 		cmd.Set("private", "true")
 	}
+	if err := checkOutputFormat(cmd.String("output")); err != nil {
+		return ctx, cli.Exit(err.Error(), 1)
+	}
+	if cmd.Bool("id-only") && cmd.String("output") != outputText {
+		return ctx, cli.Exit("--id-only can only be used with text output", 1)
+	}
+	if cmd.Bool("id-only") && cmd.Bool("compare") {
+		return ctx, cli.Exit("--id-only cannot be used with --compare", 1)
+	}
+	apis := cmd.StringSlice("api")
+	for _, api := range apis {
+		switch api {
+		case apiIPNI:
+		case apiRoutingV1:
+			if cmd.Bool("private") {
+				return ctx, cli.Exit("--api routing-v1 cannot be used with reader privacy", 1)
+			}
+		default:
+			return ctx, cli.Exit(fmt.Sprintf("unknown --api %q, must be ipni or routing-v1", api), 1)
+		}
+	}
+	if len(apis) > 1 && !cmd.Bool("compare") {
+		return ctx, cli.Exit("more than one --api can only be used with --compare", 1)
+	}
+	if cmd.Bool("first") && cmd.Bool("compare") {
+		// Each indexer's first result may differ even when the indexers agree.
+		return ctx, cli.Exit("--first cannot be used with --compare", 1)
+	}
+	if cmd.Bool("extended-providers") && cmd.Bool("compare") {
+		return ctx, cli.Exit("--extended-providers cannot be used with --compare", 1)
+	}
+	if cmd.Bool("stream") && cmd.Bool("compare") {
+		return ctx, cli.Exit("--stream cannot be used with --compare", 1)
+	}
+	if cmd.Bool("merge") {
+		switch {
+		case cmd.Bool("private"):
+			return ctx, cli.Exit("--merge cannot be used with reader privacy", 1)
+		case cmd.Bool("compare"):
+			return ctx, cli.Exit("--merge cannot be used with --compare", 1)
+		case cmd.Bool("stream"):
+			return ctx, cli.Exit("--merge cannot be used with --stream", 1)
+		}
+	}
 
 	return ctx, nil
 }
@@ -82,9 +226,6 @@ func beforeFind(ctx context.Context, cmd *cli.Command) (context.Context, error)
 func findAction(ctx context.Context, cmd *cli.Command) error {
 	mhArgs := cmd.StringSlice("mh")
 	cidArgs := cmd.StringSlice("cid")
-	if len(mhArgs) == 0 && len(cidArgs) == 0 {
-		return fmt.Errorf("must specify at least one multihash or CID")
-	}
 
 	mhs := make([]multihash.Multihash, 0, len(mhArgs)+len(cidArgs))
 	for i := range mhArgs {
@@ -102,13 +243,39 @@ func findAction(ctx context.Context, cmd *cli.Command) error {
 		mhs = append(mhs, c.Hash())
 	}
 
+	limiter := NewRateLimiter(cmd.Float64("rate"))
+	defer limiter.Stop()
+
+	filter, err := newResultFilter(cmd)
+	if err != nil {
+		return err
+	}
+	timeout := cmd.Duration("timeout")
+	wrap := func(finder client.Finder) client.Finder {
+		return limitRate(limitTime(filterResults(finder, filter), timeout), limiter)
+	}
+
+	if cmd.Bool("stream") {
+		ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+		defer stop()
+		return streamFind(ctx, cmd, mhs, filter, limiter, timeout)
+	}
+	if cmd.Bool("compare") {
+		return compareFind(ctx, cmd, mhs, wrap)
+	}
+	if cmd.Bool("merge") {
+		return mergeFind(ctx, cmd, mhs, wrap, filter)
+	}
 	if cmd.Bool("private") {
-		return dhFind(ctx, cmd, mhs)
+		return dhFind(ctx, cmd, mhs, wrap)
+	}
+	if indexers := cmd.StringSlice("indexer"); len(indexers) > 1 {
+		fmt.Fprintf(os.Stderr, "Querying only the first indexer, %s. Use --merge to query all indexers.\n", indexers[0])
 	}
-	return clearFind(ctx, cmd, mhs)
+	return clearFind(ctx, cmd, mhs, wrap)
 }
 
-func dhFind(ctx context.Context, cmd *cli.Command, mhs []multihash.Multihash) error {
+func dhFind(ctx context.Context, cmd *cli.Command, mhs []multihash.Multihash, wrap finderWrapper) error {
 	cl, err := client.NewDHashClient(
 		client.WithProvidersURL(cmd.StringSlice("indexer")...),
 		client.WithDHStoreURL(cmd.String("dhstore")),
@@ -118,92 +285,170 @@ func dhFind(ctx context.Context, cmd *cli.Command, mhs []multihash.Multihash) er
 		return err
 	}
 
-	resp, err := client.FindBatch(ctx, cl, mhs)
-	if err != nil {
-		return err
+	var clearFinder client.Finder
+	if cmd.Bool("fallback") {
+		clearFinder, err = newClearFinder(cmd, wrap)
+		if err != nil {
+			return err
+		}
 	}
-	if resp == nil && cmd.Bool("fallback") {
-		return clearFind(ctx, cmd, mhs)
+
+	if cmd.String("output") == outputText {
+		fmt.Println("🔒 Reader privacy enabled")
+	}
+	return findKeys(ctx, cmd, mhs, dhstoreURL(cmd), dhLookup(wrap(cl), clearFinder))
+}
+
+// dhLookup returns a lookupFunc that does a reader-privacy lookup, and repeats
+// the lookup using clearFinder, if not nil, only when the indexer does not
+// support reader-privacy.
+func dhLookup(finder, clearFinder client.Finder) lookupFunc {
+	return func(ctx context.Context, mh multihash.Multihash, emit emitFunc) error {
+		resp, err := findOne(ctx, finder, mh)
+		if err != nil && clearFinder != nil && privacyUnsupported(err) {
+			resp, err = findOne(ctx, clearFinder, mh)
+		}
+		if err != nil {
+			return err
+		}
+		emit(resp, nil)
+		return nil
 	}
-	fmt.Println("🔒 Reader privacy enabled")
-	return printResults(cmd, resp)
 }
 
-func clearFind(ctx context.Context, cmd *cli.Command, mhs []multihash.Multihash) error {
-	idxr := cmd.String("dhstore")
-	if idxr == "" {
-		idxr = cmd.StringSlice("indexer")[0]
+// privacyUnsupported returns true if a reader-privacy lookup failed because the
+// indexer does not support it. An indexer that supports reader-privacy returns
+// not found for a key it has no results for, so that is not treated as
+// unsupported.
+func privacyUnsupported(err error) bool {
+	var ae *apierror.Error
+	if !errors.As(err, &ae) {
+		return false
+	}
+	switch ae.Status() {
+	case http.StatusBadRequest, http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		return true
 	}
-	cl, err := client.New(idxr)
+	return false
+}
+
+func clearFind(ctx context.Context, cmd *cli.Command, mhs []multihash.Multihash, wrap finderWrapper) error {
+	finder, err := newClearFinder(cmd, wrap)
 	if err != nil {
 		return err
 	}
+	return findKeys(ctx, cmd, mhs, dhstoreURL(cmd), func(ctx context.Context, mh multihash.Multihash, emit emitFunc) error {
+		resp, err := findOne(ctx, finder, mh)
+		if err != nil {
+			return err
+		}
+		emit(resp, nil)
+		return nil
+	})
+}
 
-	resp, err := client.FindBatch(ctx, cl, mhs)
+// streamFind looks up each key and prints each provider result as it arrives.
+// The lookup of a key stops when its timeout expires, or when the first
+// result is found if using --first, and all lookups stop on interrupt.
+func streamFind(ctx context.Context, cmd *cli.Command, mhs []multihash.Multihash, filter *resultFilter, limiter *RateLimiter, timeout time.Duration) error {
+	var primary, fallback asyncFinder
+	var err error
+	if cmd.Bool("private") {
+		primary, err = client.NewDHashClient(
+			client.WithProvidersURL(cmd.StringSlice("indexer")...),
+			client.WithDHStoreURL(cmd.String("dhstore")),
+			client.WithPcacheTTL(0),
+		)
+		if err != nil {
+			return err
+		}
+		if cmd.Bool("fallback") {
+			fallback, err = newStreamFinder(cmd)
+		}
+		if cmd.String("output") == outputText {
+			fmt.Println("🔒 Reader privacy enabled")
+		}
+	} else {
+		primary, err = newStreamFinder(cmd)
+	}
 	if err != nil {
 		return err
 	}
-	return printResults(cmd, resp)
+
+	return findKeys(ctx, cmd, mhs, dhstoreURL(cmd), streamLookup(primary, fallback, filter, limiter, timeout))
 }
 
-func printResults(cmd *cli.Command, resp *model.FindResponse) error {
-	if resp == nil || len(resp.MultihashResults) == 0 {
-		fmt.Println("index not found")
-		return nil
+// newStreamFinder creates a finder that reads streaming responses from the
+// API selected by --api.
+func newStreamFinder(cmd *cli.Command) (asyncFinder, error) {
+	if cmd.StringSlice("api")[0] == apiRoutingV1 {
+		return newRoutingFinder(dhstoreURL(cmd))
 	}
+	return newNDJSONFinder(dhstoreURL(cmd))
+}
 
-	if cmd.Bool("id-only") {
-		seen := make(map[peer.ID]struct{})
-		for i := range resp.MultihashResults {
-			for _, pr := range resp.MultihashResults[i].ProviderResults {
-				if _, ok := seen[pr.Provider.ID]; ok {
-					continue
-				}
-				seen[pr.Provider.ID] = struct{}{}
-				fmt.Println(pr.Provider.ID.String())
-			}
-		}
-		return nil
+func newClearFinder(cmd *cli.Command, wrap finderWrapper) (client.Finder, error) {
+	cl, err := newIndexerFinder(cmd.StringSlice("api")[0], dhstoreURL(cmd))
+	if err != nil {
+		return nil, err
 	}
+	return wrap(cl), nil
+}
 
+// dhstoreURL returns the URL of the dhstore, or of the first indexer if no
+// dhstore is specified.
+func dhstoreURL(cmd *cli.Command) string {
+	if dhs := cmd.String("dhstore"); dhs != "" {
+		return dhs
+	}
+	return cmd.StringSlice("indexer")[0]
+}
+
+// printResults prints the results as text. The multihash heading is not
+// repeated if it is the same as lastMh, the last multihash printed, so that
+// streamed results for a multihash are printed together. Returns the last
+// multihash printed.
+func printResults(resp *model.FindResponse, sources resultSources, probes responseProbes, extProvs responseExtProvs, lastMh string) string {
 	for i := range resp.MultihashResults {
-		fmt.Println("Multihash:", resp.MultihashResults[i].Multihash.B58String())
+		mhStr := resp.MultihashResults[i].Multihash.B58String()
+		if mhStr != lastMh {
+			fmt.Println("Multihash:", mhStr)
+			lastMh = mhStr
+		}
 		if len(resp.MultihashResults[i].ProviderResults) == 0 {
 			fmt.Println("  index not found")
 			continue
 		}
 		// Group results by provider.
-		providers := make(map[string][]model.ProviderResult)
-		for _, pr := range resp.MultihashResults[i].ProviderResults {
+		providers := make(map[string][]int)
+		for j, pr := range resp.MultihashResults[i].ProviderResults {
 			provStr := pr.Provider.String()
-			providers[provStr] = append(providers[provStr], pr)
+			providers[provStr] = append(providers[provStr], j)
 		}
-		for provStr, prs := range providers {
+		for provStr, prIndexes := range providers {
 			fmt.Println("  Provider:", provStr)
-			for _, pr := range prs {
+			for _, j := range prIndexes {
+				pr := resp.MultihashResults[i].ProviderResults[j]
 				fmt.Println("    ContextID:", base64.StdEncoding.EncodeToString(pr.ContextID))
 				fmt.Print("      Metadata: ")
 				if len(pr.Metadata) == 0 {
 					fmt.Println("none")
 				} else {
 					fmt.Println(base64.StdEncoding.EncodeToString(pr.Metadata))
-					fmt.Println("        Protocols:", decodeMetadataProtos(pr.Metadata))
+					fmt.Println("        Protocols:", metaproto.String(pr.Metadata))
+				}
+				if indexers := sources.get(i, j); indexers != nil {
+					fmt.Println("      Indexers:", strings.Join(indexers, ", "))
+				}
+				for _, res := range probes.get(i, j) {
+					fmt.Println("        Probe:", probeString(res))
+				}
+				if sets := extProvs.get(i, j); sets != nil {
+					fmt.Println("      ExtendedProviders:")
+					extprov.Write(os.Stdout, sets, "        ")
 				}
 			}
 		}
 	}
-	return nil
-}
-
-func decodeMetadataProtos(metaBytes []byte) string {
-	meta := metadata.Default.New()
-	err := meta.UnmarshalBinary(metaBytes)
-	if err != nil {
-		return fmt.Sprint("error: ", err.Error())
-	}
-	protoStrs := make([]string, meta.Len())
-	for i, p := range meta.Protocols() {
-		protoStrs[i] = p.String()
-	}
-	return strings.Join(protoStrs, ", ")
+	return lastMh
 }
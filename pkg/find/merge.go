@@ -0,0 +1,175 @@
+package find
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/ipni/go-libipni/find/model"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multihash"
+	"github.com/urfave/cli/v3"
+)
+
+// resultSources holds the indexers that returned each provider result in a
+// find response, indexed by multihash result and then by provider result.
+type resultSources [][][]string
+
+// get returns the indexers that returned a provider result, or nil if results
+// are not merged from multiple indexers.
+func (rs resultSources) get(mhIndex, prIndex int) []string {
+	if rs == nil {
+		return nil
+	}
+	return rs[mhIndex][prIndex]
+}
+
+// sourceFailures counts the failed lookups of each indexer.
+type sourceFailures struct {
+	mutex  sync.Mutex
+	counts map[string]int
+	first  map[string]error
+	order  []string
+}
+
+func newSourceFailures() *sourceFailures {
+	return &sourceFailures{
+		counts: make(map[string]int),
+		first:  make(map[string]error),
+	}
+}
+
+// add records a failed lookup. The first failure of each indexer is reported
+// when it happens.
+func (f *sourceFailures) add(name string, err error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.counts[name] == 0 {
+		f.first[name] = err
+		f.order = append(f.order, name)
+		fmt.Fprintf(os.Stderr, "Indexer %s failed, using results from other indexers: %s\n", name, err)
+	}
+	f.counts[name]++
+}
+
+// report writes the number of failed lookups for each indexer that failed.
+func (f *sourceFailures) report() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for _, name := range f.order {
+		fmt.Fprintf(os.Stderr, "Indexer %s failed %d lookups, first error: %s\n", name, f.counts[name], f.first[name])
+	}
+}
+
+// mergeFind queries every indexer for each key, and merges the results into a
+// single response without duplicate provider results. Lookups succeed as long
+// as any indexer answers.
+func mergeFind(ctx context.Context, cmd *cli.Command, mhs []multihash.Multihash, wrap finderWrapper, filter *resultFilter) error {
+	api := cmd.StringSlice("api")[0]
+	indexers := cmd.StringSlice("indexer")
+	sources := make([]compareSource, len(indexers))
+	for i, idxr := range indexers {
+		finder, err := newIndexerFinder(api, idxr)
+		if err != nil {
+			return fmt.Errorf("cannot create client for %s: %w", idxr, err)
+		}
+		sources[i] = compareSource{
+			name:   idxr,
+			finder: wrap(finder),
+		}
+	}
+	first := filter != nil && filter.first
+
+	failures := newSourceFailures()
+	err := findKeys(ctx, cmd, mhs, "", func(ctx context.Context, mh multihash.Multihash, emit emitFunc) error {
+		resp, srcs, err := mergeOne(ctx, sources, mh, first, failures)
+		if err != nil {
+			return err
+		}
+		emit(resp, srcs)
+		return nil
+	})
+	failures.report()
+	return err
+}
+
+// mergeOne queries all sources in parallel for the multihash, and merges their
+// provider results. Results with the same provider ID and context ID are
+// merged into one result, annotated with all the indexers that returned it.
+func mergeOne(ctx context.Context, sources []compareSource, mh multihash.Multihash, first bool, failures *sourceFailures) (*model.FindResponse, resultSources, error) {
+	type answer struct {
+		resp *model.FindResponse
+		err  error
+	}
+	answers := make([]answer, len(sources))
+	var wg sync.WaitGroup
+	for i, src := range sources {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := findOne(ctx, src.finder, mh)
+			answers[i] = answer{resp, err}
+		}()
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return nil, nil, ctx.Err()
+	}
+
+	var merged []model.ProviderResult
+	var indexers [][]string
+	type resultKey struct {
+		providerID peer.ID
+		contextID  string
+	}
+	seen := make(map[resultKey]int)
+	var failed int
+	var lastErr error
+	for i, ans := range answers {
+		name := sources[i].name
+		if ans.err != nil {
+			failed++
+			lastErr = ans.err
+			failures.add(name, ans.err)
+			continue
+		}
+		for _, mhr := range ans.resp.MultihashResults {
+			for _, pr := range mhr.ProviderResults {
+				key := resultKey{
+					contextID: string(pr.ContextID),
+				}
+				if pr.Provider != nil {
+					key.providerID = pr.Provider.ID
+				}
+				if j, ok := seen[key]; ok {
+					indexers[j] = append(indexers[j], name)
+					continue
+				}
+				seen[key] = len(merged)
+				merged = append(merged, pr)
+				indexers = append(indexers, []string{name})
+			}
+		}
+	}
+	if failed == len(sources) {
+		return nil, nil, fmt.Errorf("all indexers failed: %w", lastErr)
+	}
+	if len(merged) == 0 {
+		return &model.FindResponse{}, nil, nil
+	}
+	if first {
+		merged = merged[:1]
+		indexers = indexers[:1]
+	}
+
+	resp := &model.FindResponse{
+		MultihashResults: []model.MultihashResult{
+			{
+				Multihash:       mh,
+				ProviderResults: merged,
+			},
+		},
+	}
+	return resp, resultSources{indexers}, nil
+}
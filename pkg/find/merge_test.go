@@ -0,0 +1,95 @@
+package find
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ipni/go-libipni/find/client"
+	"github.com/ipni/go-libipni/find/model"
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+// staticFinder returns the same provider results, or error, for every lookup.
+type staticFinder struct {
+	results []model.ProviderResult
+	err     error
+}
+
+func (f staticFinder) Find(_ context.Context, mh multihash.Multihash) (*model.FindResponse, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &model.FindResponse{
+		MultihashResults: []model.MultihashResult{
+			{
+				Multihash:       mh,
+				ProviderResults: f.results,
+			},
+		},
+	}, nil
+}
+
+// testMergeOne merges the results of the named finders for the multihash, and
+// returns the indexers that returned each merged result.
+func testMergeOne(ctx context.Context, finders map[string]client.Finder, names []string, mh multihash.Multihash, first bool) (*model.FindResponse, [][]string, error) {
+	sources := make([]compareSource, len(names))
+	for i, name := range names {
+		sources[i] = compareSource{
+			name:   name,
+			finder: finders[name],
+		}
+	}
+	resp, srcs, err := mergeOne(ctx, sources, mh, first, newSourceFailures())
+	if srcs == nil {
+		return resp, nil, err
+	}
+	return resp, srcs[0], err
+}
+
+func TestMergeOne(t *testing.T) {
+	bitswap := encodeMetadata(t, &metadata.Bitswap{})
+	gateway := encodeMetadata(t, &metadata.IpfsGatewayHttp{})
+	mh := testMultihash(t, "merge")
+
+	finders := map[string]client.Finder{
+		"one": staticFinder{results: []model.ProviderResult{
+			providerResult(t, pidA, "a1", bitswap),
+			providerResult(t, pidB, "b1", bitswap),
+		}},
+		// Same provider and context ID as results from "one", with different
+		// metadata, and a result for another context ID.
+		"two": staticFinder{results: []model.ProviderResult{
+			providerResult(t, pidB, "b1", gateway),
+			providerResult(t, pidA, "a2", bitswap),
+		}},
+		"three": staticFinder{results: []model.ProviderResult{
+			providerResult(t, pidA, "a1", bitswap),
+		}},
+		"failed": staticFinder{err: errors.New("unavailable")},
+	}
+
+	resp, sources, err := testMergeOne(t.Context(), finders, []string{"one", "two", "three", "failed"}, mh, false)
+	require.NoError(t, err)
+	require.Equal(t, []string{pidA + "/a1", pidB + "/b1", pidA + "/a2"}, resultIDs(resp))
+	require.Equal(t, [][]string{{"one", "three"}, {"one", "two"}, {"two"}}, sources)
+	// The first result returned for a provider and context ID is kept.
+	require.Equal(t, bitswap, resp.MultihashResults[0].ProviderResults[1].Metadata)
+
+	resp, sources, err = testMergeOne(t.Context(), finders, []string{"two", "one"}, mh, true)
+	require.NoError(t, err)
+	require.Equal(t, []string{pidB + "/b1"}, resultIDs(resp))
+	require.Equal(t, [][]string{{"two", "one"}}, sources)
+
+	// No results is not an error.
+	finders["empty"] = staticFinder{}
+	resp, sources, err = testMergeOne(t.Context(), finders, []string{"empty", "failed"}, mh, false)
+	require.NoError(t, err)
+	require.Empty(t, resp.MultihashResults)
+	require.Nil(t, sources)
+
+	_, _, err = testMergeOne(t.Context(), finders, []string{"failed"}, mh, false)
+	require.ErrorContains(t, err, "all indexers failed")
+}
@@ -0,0 +1,258 @@
+package find
+
+import (
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/ipni/go-libipni/find/model"
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/ipni/ipni-cli/pkg/extprov"
+	"github.com/ipni/ipni-cli/pkg/metaproto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+	outputText   = "text"
+	outputJSON   = "json"
+	outputNDJSON = "ndjson"
+	outputCSV    = "csv"
+)
+
+var csvHeader = []string{"multihash", "provider_id", "addrs", "context_id", "protocols", "metadata", "indexer", "probes", "extended_providers"}
+
+// findRecord is a single provider result for a multihash, as written by the
+// structured output formats.
+type findRecord struct {
+	Multihash  string           `json:"multihash"`
+	ProviderID string           `json:"provider_id"`
+	Addrs      []string         `json:"addrs"`
+	ContextID  string           `json:"context_id"`
+	Protocols  []string         `json:"protocols"`
+	Metadata   []protocolRecord `json:"metadata"`
+	Indexer    string           `json:"indexer"`
+	// Indexers lists every indexer that returned the result, when merging
+	// results from multiple indexers.
+	Indexers []string      `json:"indexers,omitempty"`
+	Probes   []probeRecord `json:"probes,omitempty"`
+	// ExtendedProviders are the extended providers that apply to the
+	// result, when looking them up.
+	ExtendedProviders []extprov.Set `json:"extended_providers,omitempty"`
+}
+
+// protocolRecord is the decoded metadata for one transport protocol.
+type protocolRecord struct {
+	Protocol string         `json:"protocol"`
+	Fields   map[string]any `json:"fields,omitempty"`
+	Error    string         `json:"error,omitempty"`
+}
+
+// resultPrinter prints find results from concurrent lookups.
+type resultPrinter struct {
+	format  string
+	idOnly  bool
+	found   bool
+	lastMh  string
+	mutex   sync.Mutex
+	seen    map[peer.ID]struct{}
+	records []findRecord
+	csvw    *csv.Writer
+	jsonEnc *json.Encoder
+}
+
+func newResultPrinter(format string, idOnly bool) *resultPrinter {
+	p := &resultPrinter{
+		format: format,
+		idOnly: idOnly,
+		seen:   make(map[peer.ID]struct{}),
+	}
+	switch p.format {
+	case outputNDJSON:
+		p.jsonEnc = json.NewEncoder(os.Stdout)
+	case outputCSV:
+		p.csvw = csv.NewWriter(os.Stdout)
+		p.csvw.Write(csvHeader)
+	}
+	return p
+}
+
+func checkOutputFormat(format string) error {
+	switch format {
+	case outputText, outputJSON, outputNDJSON, outputCSV:
+		return nil
+	}
+	return fmt.Errorf("unsupported output format %q: must be one of text, json, ndjson, csv", format)
+}
+
+func (p *resultPrinter) print(resp *model.FindResponse, indexer string, sources resultSources, probes responseProbes, extProvs responseExtProvs) {
+	if resp == nil || len(resp.MultihashResults) == 0 {
+		return
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.found = true
+
+	switch p.format {
+	case outputJSON:
+		p.records = append(p.records, findRecords(resp, indexer, sources, probes, extProvs)...)
+	case outputNDJSON:
+		for _, rec := range findRecords(resp, indexer, sources, probes, extProvs) {
+			p.jsonEnc.Encode(rec)
+		}
+	case outputCSV:
+		for _, rec := range findRecords(resp, indexer, sources, probes, extProvs) {
+			p.csvw.Write(rec.csvRow())
+		}
+		p.csvw.Flush()
+	default:
+		if p.idOnly {
+			for i := range resp.MultihashResults {
+				for _, pr := range resp.MultihashResults[i].ProviderResults {
+					if _, ok := p.seen[pr.Provider.ID]; ok {
+						continue
+					}
+					p.seen[pr.Provider.ID] = struct{}{}
+					fmt.Println(pr.Provider.ID.String())
+				}
+			}
+			return
+		}
+		p.lastMh = printResults(resp, sources, probes, extProvs, p.lastMh)
+	}
+}
+
+// finish writes any buffered output and reports if nothing was found.
+func (p *resultPrinter) finish() error {
+	switch p.format {
+	case outputJSON:
+		if p.records == nil {
+			p.records = []findRecord{}
+		}
+		data, err := json.MarshalIndent(p.records, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+	case outputCSV:
+		p.csvw.Flush()
+		return p.csvw.Error()
+	case outputText:
+		if !p.found {
+			fmt.Println("index not found")
+		}
+	}
+	return nil
+}
+
+func findRecords(resp *model.FindResponse, indexer string, sources resultSources, probes responseProbes, extProvs responseExtProvs) []findRecord {
+	var records []findRecord
+	for i, mhr := range resp.MultihashResults {
+		mhStr := mhr.Multihash.B58String()
+		for j, pr := range mhr.ProviderResults {
+			rec := findRecord{
+				Multihash:         mhStr,
+				ContextID:         base64.StdEncoding.EncodeToString(pr.ContextID),
+				Indexer:           indexer,
+				Probes:            probeRecords(probes.get(i, j)),
+				ExtendedProviders: extProvs.get(i, j),
+			}
+			if indexers := sources.get(i, j); indexers != nil {
+				rec.Indexer = indexers[0]
+				rec.Indexers = indexers
+			}
+			if pr.Provider != nil {
+				rec.ProviderID = pr.Provider.ID.String()
+				rec.Addrs = make([]string, len(pr.Provider.Addrs))
+				for i, a := range pr.Provider.Addrs {
+					rec.Addrs[i] = a.String()
+				}
+			}
+			rec.Protocols, rec.Metadata = decodeMetadata(pr.Metadata)
+			records = append(records, rec)
+		}
+	}
+	return records
+}
+
+// decodeMetadata returns the names of the metadata transport protocols and the
+// decoded fields of each.
+func decodeMetadata(metaBytes []byte) ([]string, []protocolRecord) {
+	if len(metaBytes) == 0 {
+		return []string{}, []protocolRecord{}
+	}
+	meta, err := metaproto.Decode(metaBytes)
+	if err != nil {
+		return []string{}, []protocolRecord{{Error: err.Error()}}
+	}
+	protos := make([]string, meta.Len())
+	protoRecs := make([]protocolRecord, meta.Len())
+	for i, code := range meta.Protocols() {
+		protos[i] = code.String()
+		protoRecs[i] = protocolRecord{
+			Protocol: protos[i],
+			Fields:   protocolFields(meta.Get(code)),
+		}
+	}
+	return protos, protoRecs
+}
+
+func protocolFields(proto metadata.Protocol) map[string]any {
+	switch p := proto.(type) {
+	case *metadata.GraphsyncFilecoinV1:
+		return map[string]any{
+			"piece_cid":      p.PieceCID.String(),
+			"verified_deal":  p.VerifiedDeal,
+			"fast_retrieval": p.FastRetrieval,
+		}
+	case *metadata.Unknown:
+		if len(p.Payload) != 0 {
+			return map[string]any{
+				"payload": base64.StdEncoding.EncodeToString(p.Payload),
+			}
+		}
+	}
+	return nil
+}
+
+func (r findRecord) csvRow() []string {
+	var fields []string
+	for _, pr := range r.Metadata {
+		if pr.Error != "" {
+			fields = append(fields, "error="+pr.Error)
+			continue
+		}
+		for _, name := range slices.Sorted(maps.Keys(pr.Fields)) {
+			fields = append(fields, fmt.Sprintf("%s.%s=%v", pr.Protocol, name, pr.Fields[name]))
+		}
+	}
+	probeStrs := make([]string, len(r.Probes))
+	for i, pr := range r.Probes {
+		if pr.OK {
+			probeStrs[i] = fmt.Sprintf("%s=ok:%.0fms", pr.Protocol, pr.LatencyMs)
+		} else {
+			probeStrs[i] = pr.Protocol + "=fail"
+		}
+	}
+	indexer := r.Indexer
+	if r.Indexers != nil {
+		indexer = strings.Join(r.Indexers, " ")
+	}
+	return []string{
+		r.Multihash,
+		r.ProviderID,
+		strings.Join(r.Addrs, " "),
+		r.ContextID,
+		strings.Join(r.Protocols, " "),
+		strings.Join(fields, " "),
+		indexer,
+		strings.Join(probeStrs, " "),
+		strings.Join(extprov.IDs(r.ExtendedProviders), " "),
+	}
+}
@@ -0,0 +1,178 @@
+package find
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipni/go-libipni/find/model"
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	pidA = "12D3KooWE8yt84RVwW3sFcd6WMjbUdWrZer2YtT4dmtj3dHdahSZ"
+	pidB = "12D3KooWLjeDyvuv7rbfG2wWNvWn7ybmmU88PirmSckuqCgXBAph"
+	pidC = "12D3KooWPNbkEgjdBNeaCGpsgCrPRETe4uBZf1ShFXStobdN18ys"
+
+	pieceCid = "baga6ea4seaqlv5z4jzczr7wpmqnlg4jmnyvabfb2vcdrkqpaxvh2ia2fmpp3spq"
+)
+
+func encodeMetadata(t *testing.T, protos ...metadata.Protocol) []byte {
+	meta := metadata.Default.New(protos...)
+	data, err := meta.MarshalBinary()
+	require.NoError(t, err)
+	return data
+}
+
+func providerResult(t *testing.T, pid, contextID string, meta []byte, addrs ...string) model.ProviderResult {
+	id, err := peer.Decode(pid)
+	require.NoError(t, err)
+	ai := &peer.AddrInfo{ID: id}
+	for _, addr := range addrs {
+		ai.Addrs = append(ai.Addrs, multiaddr.StringCast(addr))
+	}
+	return model.ProviderResult{
+		ContextID: []byte(contextID),
+		Metadata:  meta,
+		Provider:  ai,
+	}
+}
+
+// captureStdout returns everything written to stdout while running f.
+func captureStdout(t *testing.T, f func()) string {
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	out := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		out <- string(data)
+	}()
+	f()
+	w.Close()
+	return <-out
+}
+
+// outputResponses returns a response with a bitswap result and a graphsync
+// result with a piece CID, and a response with a result whose metadata cannot
+// be decoded.
+func outputResponses(t *testing.T) []*model.FindResponse {
+	piece, err := cid.Decode(pieceCid)
+	require.NoError(t, err)
+	return []*model.FindResponse{
+		{
+			MultihashResults: []model.MultihashResult{{
+				Multihash: testMultihash(t, "a"),
+				ProviderResults: []model.ProviderResult{
+					providerResult(t, pidA, "ctx-a", encodeMetadata(t, metadata.Bitswap{}), "/ip4/1.2.3.4/tcp/1"),
+					providerResult(t, pidA, "ctx-b", encodeMetadata(t, &metadata.GraphsyncFilecoinV1{
+						PieceCID:     piece,
+						VerifiedDeal: true,
+					}), "/ip4/1.2.3.4/tcp/1"),
+				},
+			}},
+		},
+		{
+			MultihashResults: []model.MultihashResult{{
+				Multihash: testMultihash(t, "b"),
+				ProviderResults: []model.ProviderResult{
+					providerResult(t, pidB, "ctx-c", []byte{0xff}),
+				},
+			}},
+		},
+	}
+}
+
+func printResponses(t *testing.T, format string, resps []*model.FindResponse) string {
+	return captureStdout(t, func() {
+		p := newResultPrinter(format, false)
+		for _, resp := range resps {
+			p.print(resp, "https://indexer.example", nil, nil, nil)
+		}
+		require.NoError(t, p.finish())
+	})
+}
+
+func checkRecords(t *testing.T, recs []findRecord) {
+	require.Len(t, recs, 3)
+
+	mhA := testMultihash(t, "a").B58String()
+	require.Equal(t, mhA, recs[0].Multihash)
+	require.Equal(t, pidA, recs[0].ProviderID)
+	require.Equal(t, []string{"/ip4/1.2.3.4/tcp/1"}, recs[0].Addrs)
+	require.Equal(t, base64.StdEncoding.EncodeToString([]byte("ctx-a")), recs[0].ContextID)
+	require.Equal(t, "https://indexer.example", recs[0].Indexer)
+	require.Equal(t, []string{"transport-bitswap"}, recs[0].Protocols)
+	require.Equal(t, []protocolRecord{{Protocol: "transport-bitswap"}}, recs[0].Metadata)
+
+	require.Equal(t, mhA, recs[1].Multihash)
+	require.Equal(t, []string{"transport-graphsync-filecoinv1"}, recs[1].Protocols)
+	require.Equal(t, []protocolRecord{{
+		Protocol: "transport-graphsync-filecoinv1",
+		Fields: map[string]any{
+			"piece_cid":      pieceCid,
+			"verified_deal":  true,
+			"fast_retrieval": false,
+		},
+	}}, recs[1].Metadata)
+
+	require.Equal(t, testMultihash(t, "b").B58String(), recs[2].Multihash)
+	require.Equal(t, pidB, recs[2].ProviderID)
+	require.Empty(t, recs[2].Protocols)
+	require.Len(t, recs[2].Metadata, 1)
+	require.NotEmpty(t, recs[2].Metadata[0].Error)
+}
+
+func TestPrintJSON(t *testing.T) {
+	out := printResponses(t, outputJSON, outputResponses(t))
+	var recs []findRecord
+	require.NoError(t, json.Unmarshal([]byte(out), &recs))
+	checkRecords(t, recs)
+
+	// Nothing found is an empty array.
+	out = printResponses(t, outputJSON, []*model.FindResponse{{}})
+	require.Equal(t, "[]\n", out)
+}
+
+func TestPrintNDJSON(t *testing.T) {
+	out := printResponses(t, outputNDJSON, outputResponses(t))
+	var recs []findRecord
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		var rec findRecord
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &rec))
+		recs = append(recs, rec)
+	}
+	require.NoError(t, scanner.Err())
+	checkRecords(t, recs)
+
+	require.Empty(t, printResponses(t, outputNDJSON, nil))
+}
+
+func TestPrintText(t *testing.T) {
+	out := printResponses(t, outputText, outputResponses(t))
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	require.Equal(t, "Multihash: "+testMultihash(t, "a").B58String(), lines[0])
+	require.Contains(t, lines[1], "Provider: ")
+	require.Contains(t, lines[1], pidA)
+	require.Contains(t, out, "ContextID: "+base64.StdEncoding.EncodeToString([]byte("ctx-a")))
+	require.Contains(t, out, "Protocols: transport-bitswap\n")
+	require.Contains(t, out, "Protocols: transport-graphsync-filecoinv1\n")
+	require.Contains(t, out, "Multihash: "+testMultihash(t, "b").B58String())
+	require.Contains(t, out, "Protocols: error: ")
+	require.NotContains(t, out, "index not found")
+
+	out = printResponses(t, outputText, nil)
+	require.Equal(t, "index not found\n", out)
+}
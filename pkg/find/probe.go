@@ -0,0 +1,86 @@
+package find
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ipni/go-libipni/find/model"
+	"github.com/ipni/ipni-cli/pkg/probe"
+)
+
+// maxProbes is the maximum number of concurrent retrieval probes for a find
+// response.
+const maxProbes = 16
+
+// responseProbes holds the probe results for each provider result in a find
+// response, indexed by multihash result and then by provider result.
+type responseProbes [][][]probe.Result
+
+// probeRecord is the outcome of a retrieval probe, as written by the
+// structured output formats.
+type probeRecord struct {
+	Protocol  string  `json:"protocol"`
+	OK        bool    `json:"ok"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// probeResponse probes every provider result in the response concurrently.
+func probeResponse(ctx context.Context, prober *probe.Prober, resp *model.FindResponse) responseProbes {
+	if prober == nil || resp == nil {
+		return nil
+	}
+
+	probes := make(responseProbes, len(resp.MultihashResults))
+	limit := make(chan struct{}, maxProbes)
+	var wg sync.WaitGroup
+	for i, mhr := range resp.MultihashResults {
+		probes[i] = make([][]probe.Result, len(mhr.ProviderResults))
+		for j, pr := range mhr.ProviderResults {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				limit <- struct{}{}
+				probes[i][j] = prober.Probe(ctx, mhr.Multihash, pr)
+				<-limit
+			}()
+		}
+	}
+	wg.Wait()
+	return probes
+}
+
+// get returns the probe results for a provider result, or nil if not probing.
+func (rp responseProbes) get(mhIndex, prIndex int) []probe.Result {
+	if rp == nil {
+		return nil
+	}
+	return rp[mhIndex][prIndex]
+}
+
+func probeRecords(results []probe.Result) []probeRecord {
+	if results == nil {
+		return nil
+	}
+	recs := make([]probeRecord, len(results))
+	for i, res := range results {
+		recs[i] = probeRecord{
+			Protocol:  res.Protocol.String(),
+			OK:        res.OK,
+			LatencyMs: float64(res.Latency) / float64(time.Millisecond),
+		}
+		if res.Err != nil {
+			recs[i].Error = res.Err.Error()
+		}
+	}
+	return recs
+}
+
+func probeString(res probe.Result) string {
+	if res.OK {
+		return fmt.Sprintf("%s ok (%s)", res.Protocol, res.Latency.Round(time.Millisecond))
+	}
+	return fmt.Sprintf("%s fail (%s): %s", res.Protocol, res.Latency.Round(time.Millisecond), res.Err)
+}
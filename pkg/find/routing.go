@@ -0,0 +1,257 @@
+package find
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipni/go-libipni/apierror"
+	"github.com/ipni/go-libipni/find/client"
+	"github.com/ipni/go-libipni/find/model"
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+)
+
+const (
+	apiIPNI      = "ipni"
+	apiRoutingV1 = "routing-v1"
+)
+
+const (
+	mediaTypeJSON   = "application/json"
+	mediaTypeNDJSON = "application/x-ndjson"
+)
+
+// Delegated routing record schemas.
+const (
+	schemaPeer    = "peer"
+	schemaBitswap = "bitswap"
+)
+
+// routingFinder finds providers using the delegated routing HTTP API,
+// /routing/v1/providers/{cid}.
+type routingFinder struct {
+	c           *http.Client
+	providerURL *url.URL
+}
+
+// routingRecord is a provider record from a delegated routing response. Any
+// metadata for a protocol is in a field named after the protocol.
+type routingRecord struct {
+	Schema    string
+	ID        *peer.ID
+	Addrs     []multiaddr.Multiaddr
+	Protocols []string
+	// Protocol is used by the deprecated bitswap schema.
+	Protocol string
+
+	extra map[string]json.RawMessage
+}
+
+func (r *routingRecord) UnmarshalJSON(b []byte) error {
+	type plain routingRecord
+	if err := json.Unmarshal(b, (*plain)(r)); err != nil {
+		return err
+	}
+	return json.Unmarshal(b, &r.extra)
+}
+
+type routingResponse struct {
+	Providers []routingRecord
+}
+
+// newIndexerFinder creates a non-private finder for the indexer that uses the
+// specified find API.
+func newIndexerFinder(api, indexerURL string) (client.Finder, error) {
+	if api == apiRoutingV1 {
+		return newRoutingFinder(indexerURL)
+	}
+	return client.New(indexerURL)
+}
+
+func newRoutingFinder(indexerURL string) (*routingFinder, error) {
+	u, err := url.Parse(indexerURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" {
+		u.Scheme = "http"
+	}
+	return &routingFinder{
+		c:           http.DefaultClient,
+		providerURL: u.JoinPath("routing", "v1", "providers"),
+	}, nil
+}
+
+// Find implements client.Finder.
+func (f *routingFinder) Find(ctx context.Context, mh multihash.Multihash) (*model.FindResponse, error) {
+	resChan := make(chan model.ProviderResult)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- f.FindAsync(ctx, mh, resChan)
+	}()
+
+	var providerResults []model.ProviderResult
+	for pr := range resChan {
+		providerResults = append(providerResults, pr)
+	}
+	if err := <-errChan; err != nil {
+		return nil, err
+	}
+	if len(providerResults) == 0 {
+		return &model.FindResponse{}, nil
+	}
+	return &model.FindResponse{
+		MultihashResults: []model.MultihashResult{
+			{
+				Multihash:       mh,
+				ProviderResults: providerResults,
+			},
+		},
+	}, nil
+}
+
+// FindAsync sends provider results on resChan as they are read from the
+// response. An NDJSON response is read one record at a time. When finished,
+// resChan is closed and the error or nil is returned.
+func (f *routingFinder) FindAsync(ctx context.Context, mh multihash.Multihash, resChan chan<- model.ProviderResult) error {
+	defer close(resChan)
+
+	u := f.providerURL.JoinPath(cid.NewCidV1(cid.Raw, mh).String())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", mediaTypeNDJSON+", "+mediaTypeJSON+";q=0.9")
+
+	resp, err := f.c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode == http.StatusNotFound {
+			return nil
+		}
+		return apierror.FromResponse(resp.StatusCode, body)
+	}
+
+	send := func(rec routingRecord) error {
+		pr, ok := rec.providerResult()
+		if !ok {
+			return nil
+		}
+		select {
+		case resChan <- pr:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		return nil
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType == mediaTypeNDJSON {
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(nil, maxRecordSize)
+		for scanner.Scan() {
+			line := scanner.Bytes()
+			if len(line) == 0 {
+				continue
+			}
+			var rec routingRecord
+			if err = json.Unmarshal(line, &rec); err != nil {
+				return fmt.Errorf("cannot decode routing record: %w", err)
+			}
+			if err = send(rec); err != nil {
+				return err
+			}
+		}
+		if err = scanner.Err(); err != nil {
+			return fmt.Errorf("cannot read routing response: %w", err)
+		}
+		return nil
+	}
+
+	var routingResp routingResponse
+	if err = json.NewDecoder(resp.Body).Decode(&routingResp); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		return fmt.Errorf("cannot decode routing response: %w", err)
+	}
+	for _, rec := range routingResp.Providers {
+		if err = send(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// providerResult converts the routing record into a provider result. The
+// metadata is built from each protocol's metadata field when present. Returns
+// false if the record does not identify a provider, or has a schema that is
+// not known, since clients must ignore records with unknown schemas.
+func (r routingRecord) providerResult() (model.ProviderResult, bool) {
+	if r.ID == nil || (r.Schema != schemaPeer && r.Schema != schemaBitswap) {
+		return model.ProviderResult{}, false
+	}
+
+	protocols := r.Protocols
+	if r.Protocol != "" && len(protocols) == 0 {
+		protocols = []string{r.Protocol}
+	}
+	var meta []byte
+	for _, name := range protocols {
+		meta = append(meta, protocolMetadata(name, r.extra[name])...)
+	}
+
+	return model.ProviderResult{
+		Metadata: meta,
+		Provider: &peer.AddrInfo{
+			ID:    *r.ID,
+			Addrs: r.Addrs,
+		},
+	}, true
+}
+
+// protocolMetadata returns the binary metadata for a protocol. The raw field is
+// the base64 metadata for the protocol, if the record has one. Without it, only
+// protocols that have no metadata payload can be encoded, and any other
+// protocol is omitted.
+func protocolMetadata(name string, raw json.RawMessage) []byte {
+	var b64 string
+	if len(raw) != 0 && json.Unmarshal(raw, &b64) == nil && b64 != "" {
+		if meta, err := base64.StdEncoding.DecodeString(b64); err == nil {
+			return meta
+		}
+	}
+	var code multicodec.Code
+	if err := code.Set(strings.ToLower(name)); err != nil {
+		return nil
+	}
+	var proto metadata.Protocol
+	switch code {
+	case multicodec.TransportBitswap:
+		proto = metadata.Bitswap{}
+	case multicodec.TransportIpfsGatewayHttp:
+		proto = metadata.IpfsGatewayHttp{}
+	default:
+		return nil
+	}
+	meta, _ := proto.MarshalBinary()
+	return meta
+}
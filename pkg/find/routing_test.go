@@ -0,0 +1,244 @@
+package find
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipni/go-libipni/apierror"
+	"github.com/ipni/go-libipni/find/model"
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/ipni/ipni-cli/pkg/metaproto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multicodec"
+	"github.com/stretchr/testify/require"
+)
+
+// routingRecords returns delegated routing records for providers A and B, a
+// record with an unknown schema, and a record without a provider ID.
+func routingRecords(t *testing.T) []map[string]any {
+	piece, err := cid.Decode(pieceCid)
+	require.NoError(t, err)
+	graphsync := encodeMetadata(t, &metadata.GraphsyncFilecoinV1{PieceCID: piece})
+	return []map[string]any{
+		{
+			"Schema":    schemaPeer,
+			"ID":        pidA,
+			"Addrs":     []string{"/ip4/1.2.3.4/tcp/1"},
+			"Protocols": []string{"transport-bitswap", "transport-graphsync-filecoinv1"},
+			// Metadata for graphsync, which cannot be encoded without it.
+			"transport-graphsync-filecoinv1": base64.StdEncoding.EncodeToString(graphsync),
+		},
+		{
+			"Schema":   schemaBitswap,
+			"ID":       pidB,
+			"Protocol": "transport-bitswap",
+		},
+		{
+			"Schema": "future-schema",
+			"ID":     pidC,
+		},
+		{
+			"Schema":    schemaPeer,
+			"Protocols": []string{"transport-bitswap"},
+		},
+	}
+}
+
+// newRoutingServer starts a delegated routing server. The response for each
+// CID is given by the handler for its path.
+func newRoutingServer(t *testing.T, handlers map[string]http.HandlerFunc) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h, ok := handlers[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		h(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func routingPath(t *testing.T, data string) string {
+	return "/routing/v1/providers/" + cid.NewCidV1(cid.Raw, testMultihash(t, data)).String()
+}
+
+func TestRoutingFinder(t *testing.T) {
+	recs := routingRecords(t)
+	srv := newRoutingServer(t, map[string]http.HandlerFunc{
+		routingPath(t, "json"): func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", mediaTypeJSON)
+			json.NewEncoder(w).Encode(map[string]any{"Providers": recs})
+		},
+		routingPath(t, "ndjson"): func(w http.ResponseWriter, r *http.Request) {
+			if !strings.Contains(r.Header.Get("Accept"), mediaTypeNDJSON) {
+				http.Error(w, "ndjson not accepted", http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("Content-Type", mediaTypeNDJSON+"; charset=utf-8")
+			enc := json.NewEncoder(w)
+			for _, rec := range recs {
+				enc.Encode(rec)
+				w.Write([]byte("\n"))
+			}
+		},
+		routingPath(t, "empty"): func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", mediaTypeJSON)
+		},
+		routingPath(t, "error"): func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "overloaded", http.StatusServiceUnavailable)
+		},
+		routingPath(t, "bad json"): func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", mediaTypeJSON)
+			w.Write([]byte(`{"Providers": [`))
+		},
+		routingPath(t, "bad ndjson"): func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", mediaTypeNDJSON)
+			w.Write([]byte("{not json}\n"))
+		},
+	})
+	finder, err := newRoutingFinder(srv.URL)
+	require.NoError(t, err)
+
+	for _, name := range []string{"json", "ndjson"} {
+		t.Run(name, func(t *testing.T) {
+			mh := testMultihash(t, name)
+			resp, err := finder.Find(t.Context(), mh)
+			require.NoError(t, err)
+			require.Len(t, resp.MultihashResults, 1)
+			require.Equal(t, mh, resp.MultihashResults[0].Multihash)
+
+			// Only records with a known schema and a provider ID are results.
+			prs := resp.MultihashResults[0].ProviderResults
+			require.Len(t, prs, 2)
+			require.Equal(t, pidA, prs[0].Provider.ID.String())
+			require.Equal(t, []multiaddr.Multiaddr{multiaddr.StringCast("/ip4/1.2.3.4/tcp/1")}, prs[0].Provider.Addrs)
+			codes, err := metaproto.Codes(prs[0].Metadata)
+			require.NoError(t, err)
+			require.Equal(t, []multicodec.Code{multicodec.TransportBitswap, multicodec.TransportGraphsyncFilecoinv1}, codes)
+
+			require.Equal(t, pidB, prs[1].Provider.ID.String())
+			codes, err = metaproto.Codes(prs[1].Metadata)
+			require.NoError(t, err)
+			require.Equal(t, []multicodec.Code{multicodec.TransportBitswap}, codes)
+		})
+	}
+
+	for _, name := range []string{"empty", "not found"} {
+		t.Run(name, func(t *testing.T) {
+			resp, err := finder.Find(t.Context(), testMultihash(t, name))
+			require.NoError(t, err)
+			require.Empty(t, resp.MultihashResults)
+		})
+	}
+
+	t.Run("error", func(t *testing.T) {
+		_, err := finder.Find(t.Context(), testMultihash(t, "error"))
+		var ae *apierror.Error
+		require.True(t, errors.As(err, &ae))
+		require.Equal(t, http.StatusServiceUnavailable, ae.Status())
+	})
+
+	t.Run("bad json", func(t *testing.T) {
+		_, err := finder.Find(t.Context(), testMultihash(t, "bad json"))
+		require.ErrorContains(t, err, "cannot decode routing response")
+	})
+
+	t.Run("bad ndjson", func(t *testing.T) {
+		_, err := finder.Find(t.Context(), testMultihash(t, "bad ndjson"))
+		require.ErrorContains(t, err, "cannot decode routing record")
+	})
+}
+
+func TestRoutingRecordProviderResult(t *testing.T) {
+	id, err := peer.Decode(pidA)
+	require.NoError(t, err)
+	bitswap := encodeMetadata(t, metadata.Bitswap{})
+	gateway := encodeMetadata(t, metadata.IpfsGatewayHttp{})
+
+	tests := []struct {
+		name     string
+		record   string
+		wantOK   bool
+		wantMeta []byte
+	}{
+		{
+			name:     "peer",
+			record:   `{"Schema": "peer", "ID": "` + pidA + `", "Protocols": ["transport-bitswap", "transport-ipfs-gateway-http"]}`,
+			wantOK:   true,
+			wantMeta: append(append([]byte{}, bitswap...), gateway...),
+		},
+		{
+			name:   "peer without protocols",
+			record: `{"Schema": "peer", "ID": "` + pidA + `"}`,
+			wantOK: true,
+		},
+		{
+			name:     "bitswap",
+			record:   `{"Schema": "bitswap", "ID": "` + pidA + `", "Protocol": "transport-bitswap"}`,
+			wantOK:   true,
+			wantMeta: bitswap,
+		},
+		{
+			name:   "protocol without metadata omitted",
+			record: `{"Schema": "peer", "ID": "` + pidA + `", "Protocols": ["transport-graphsync-filecoinv1", "unknown-protocol"]}`,
+			wantOK: true,
+		},
+		{
+			name:     "protocol metadata field",
+			record:   `{"Schema": "peer", "ID": "` + pidA + `", "Protocols": ["transport-bitswap"], "transport-bitswap": "` + base64.StdEncoding.EncodeToString(gateway) + `"}`,
+			wantOK:   true,
+			wantMeta: gateway,
+		},
+		{
+			name:   "unknown schema",
+			record: `{"Schema": "future-schema", "ID": "` + pidA + `", "Protocols": ["transport-bitswap"]}`,
+		},
+		{
+			name:   "no schema",
+			record: `{"ID": "` + pidA + `", "Protocols": ["transport-bitswap"]}`,
+		},
+		{
+			name:   "no ID",
+			record: `{"Schema": "peer", "Protocols": ["transport-bitswap"]}`,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var rec routingRecord
+			require.NoError(t, json.NewDecoder(strings.NewReader(tc.record)).Decode(&rec))
+			pr, ok := rec.providerResult()
+			require.Equal(t, tc.wantOK, ok)
+			if !ok {
+				require.Equal(t, model.ProviderResult{}, pr)
+				return
+			}
+			require.Equal(t, id, pr.Provider.ID)
+			require.Equal(t, tc.wantMeta, pr.Metadata)
+		})
+	}
+}
+
+func TestProtocolMetadata(t *testing.T) {
+	bitswap := encodeMetadata(t, metadata.Bitswap{})
+	gateway := encodeMetadata(t, metadata.IpfsGatewayHttp{})
+
+	require.Equal(t, bitswap, protocolMetadata("transport-bitswap", nil))
+	require.Equal(t, bitswap, protocolMetadata("Transport-Bitswap", nil))
+	require.Equal(t, gateway, protocolMetadata("transport-ipfs-gateway-http", nil))
+	require.Nil(t, protocolMetadata("transport-graphsync-filecoinv1", nil))
+	require.Nil(t, protocolMetadata("unknown-protocol", nil))
+
+	raw := json.RawMessage(`"` + base64.StdEncoding.EncodeToString(gateway) + `"`)
+	require.Equal(t, gateway, protocolMetadata("transport-bitswap", raw))
+	// Metadata that is not base64 is ignored.
+	require.Equal(t, bitswap, protocolMetadata("transport-bitswap", json.RawMessage(`"!!"`)))
+	require.Equal(t, bitswap, protocolMetadata("transport-bitswap", json.RawMessage(`{}`)))
+}
@@ -0,0 +1,218 @@
+package find
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/ipni/go-libipni/apierror"
+	"github.com/ipni/go-libipni/find/client"
+	"github.com/ipni/go-libipni/find/model"
+	"github.com/multiformats/go-multihash"
+)
+
+// maxRecordSize is the maximum size of a single NDJSON record.
+const maxRecordSize = 1 << 20
+
+// ndjsonFinder finds providers using the indexer's native find API, and
+// requests a streaming NDJSON response so that each provider result can be
+// handled as soon as it arrives.
+type ndjsonFinder struct {
+	c       *http.Client
+	findURL *url.URL
+}
+
+func newNDJSONFinder(indexerURL string) (*ndjsonFinder, error) {
+	u, err := url.Parse(indexerURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" {
+		u.Scheme = "http"
+	}
+	return &ndjsonFinder{
+		c:       http.DefaultClient,
+		findURL: u.JoinPath("multihash"),
+	}, nil
+}
+
+// FindAsync sends provider results on resChan as they are read from the
+// response. If the indexer does not stream its response, then the results are
+// sent after the whole response is read. When finished, resChan is closed and
+// the error or nil is returned.
+func (f *ndjsonFinder) FindAsync(ctx context.Context, mh multihash.Multihash, resChan chan<- model.ProviderResult) error {
+	defer close(resChan)
+
+	u := f.findURL.JoinPath(mh.B58String())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", mediaTypeNDJSON+", "+mediaTypeJSON+";q=0.9")
+
+	resp, err := f.c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode == http.StatusNotFound {
+			return nil
+		}
+		return apierror.FromResponse(resp.StatusCode, body)
+	}
+
+	send := func(pr model.ProviderResult) error {
+		select {
+		case resChan <- pr:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		return nil
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != mediaTypeNDJSON {
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		findResp, err := model.UnmarshalFindResponse(body)
+		if err != nil {
+			return err
+		}
+		for _, mhr := range findResp.MultihashResults {
+			for _, pr := range mhr.ProviderResults {
+				if err = send(pr); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(nil, maxRecordSize)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var pr model.ProviderResult
+		if err = json.Unmarshal(line, &pr); err != nil {
+			return fmt.Errorf("cannot decode provider result: %w", err)
+		}
+		if err = send(pr); err != nil {
+			return err
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return fmt.Errorf("cannot read find response: %w", err)
+	}
+	return nil
+}
+
+// streamLookup returns a lookupFunc that emits each provider result as it
+// arrives. Each key is looked up using the primary finder, and then the
+// fallback finder, if any, when the primary does not support reader-privacy.
+func streamLookup(primary, fallback asyncFinder, filter *resultFilter, limiter *RateLimiter, timeout time.Duration) lookupFunc {
+	return func(ctx context.Context, mh multihash.Multihash, emit emitFunc) error {
+		n, err := streamOne(ctx, primary, mh, filter, limiter, timeout, emit)
+		if err != nil && n == 0 && fallback != nil && privacyUnsupported(err) {
+			_, err = streamOne(ctx, fallback, mh, filter, limiter, timeout, emit)
+		}
+		return err
+	}
+}
+
+// streamOne looks up a single multihash and emits each provider result that
+// passes the filter. Returns the number of results emitted.
+func streamOne(ctx context.Context, af asyncFinder, mh multihash.Multihash, filter *resultFilter, limiter *RateLimiter, timeout time.Duration, emit emitFunc) (int, error) {
+	if err := limiter.Wait(ctx); err != nil {
+		return 0, err
+	}
+
+	var findCtx context.Context
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		findCtx, cancel = context.WithTimeout(ctx, timeout)
+	} else {
+		findCtx, cancel = context.WithCancel(ctx)
+	}
+	defer cancel()
+
+	resChan := make(chan model.ProviderResult)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- af.FindAsync(findCtx, mh, resChan)
+	}()
+
+	var n int
+	var done bool
+	for pr := range resChan {
+		if done || (filter != nil && !filter.match(pr)) {
+			continue
+		}
+		n++
+		emit(&model.FindResponse{
+			MultihashResults: []model.MultihashResult{
+				{
+					Multihash:       mh,
+					ProviderResults: []model.ProviderResult{pr},
+				},
+			},
+		}, nil)
+		if filter != nil && filter.first {
+			// Stop reading results now that the first is found.
+			done = true
+			cancel()
+		}
+	}
+	err := <-errChan
+	if err == nil || (done && ctx.Err() == nil) {
+		return n, nil
+	}
+	var ae *apierror.Error
+	switch {
+	case errors.As(err, &ae) && ae.Status() == http.StatusNotFound:
+		return n, nil
+	case errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil:
+		return n, fmt.Errorf("lookup of %s timed out after %s with %d results: %w", mh.B58String(), timeout, n, err)
+	}
+	return n, err
+}
+
+// timeoutFinder limits the time that each lookup may take.
+type timeoutFinder struct {
+	client.Finder
+	timeout time.Duration
+}
+
+func (f *timeoutFinder) Find(ctx context.Context, mh multihash.Multihash) (*model.FindResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, f.timeout)
+	defer cancel()
+	resp, err := f.Finder.Find(ctx, mh)
+	if err != nil && errors.Is(err, context.DeadlineExceeded) {
+		return nil, fmt.Errorf("lookup of %s timed out after %s: %w", mh.B58String(), f.timeout, err)
+	}
+	return resp, err
+}
+
+func limitTime(finder client.Finder, timeout time.Duration) client.Finder {
+	if timeout <= 0 {
+		return finder
+	}
+	return &timeoutFinder{
+		Finder:  finder,
+		timeout: timeout,
+	}
+}
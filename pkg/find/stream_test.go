@@ -0,0 +1,235 @@
+package find
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ipni/go-libipni/apierror"
+	"github.com/ipni/go-libipni/find/model"
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+// streamResults returns the provider results streamed for each lookup.
+func streamResults(t *testing.T) []model.ProviderResult {
+	bitswap := encodeMetadata(t, metadata.Bitswap{})
+	return []model.ProviderResult{
+		providerResult(t, pidA, "a1", bitswap, "/ip4/1.2.3.4/tcp/1"),
+		providerResult(t, pidB, "b1", bitswap, "/ip4/1.2.3.4/tcp/1"),
+		providerResult(t, pidC, "c1", bitswap, "/ip4/1.2.3.4/tcp/1"),
+	}
+}
+
+// newStreamServer starts an indexer that streams NDJSON find responses. The
+// response for each multihash is given by the handler for its path.
+func newStreamServer(t *testing.T, handlers map[string]http.HandlerFunc) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h, ok := handlers[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		h(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func streamPath(t *testing.T, data string) string {
+	return "/multihash/" + testMultihash(t, data).B58String()
+}
+
+// writeRecords writes each provider result as an NDJSON record, and flushes
+// each so that the client receives it before the next is written.
+func writeRecords(w http.ResponseWriter, prs []model.ProviderResult) {
+	w.Header().Set("Content-Type", mediaTypeNDJSON)
+	for _, pr := range prs {
+		data, _ := json.Marshal(pr)
+		w.Write(append(data, '\n'))
+		w.(http.Flusher).Flush()
+	}
+}
+
+// collect looks up the multihash using streamOne, and returns the emitted
+// results and the error.
+func collect(t *testing.T, af asyncFinder, data string, filter *resultFilter, timeout time.Duration) ([]model.ProviderResult, error) {
+	var got []model.ProviderResult
+	mh := testMultihash(t, data)
+	n, err := streamOne(t.Context(), af, mh, filter, nil, timeout, func(resp *model.FindResponse, _ resultSources) {
+		require.Len(t, resp.MultihashResults, 1)
+		require.Equal(t, mh, resp.MultihashResults[0].Multihash)
+		got = append(got, resp.MultihashResults[0].ProviderResults...)
+	})
+	require.Equal(t, len(got), n)
+	return got, err
+}
+
+func TestNDJSONFinder(t *testing.T) {
+	prs := streamResults(t)
+	block := make(chan struct{})
+	t.Cleanup(func() { close(block) })
+
+	srv := newStreamServer(t, map[string]http.HandlerFunc{
+		streamPath(t, "ndjson"): func(w http.ResponseWriter, r *http.Request) {
+			writeRecords(w, prs)
+		},
+		streamPath(t, "json"): func(w http.ResponseWriter, r *http.Request) {
+			// An indexer that does not stream returns the whole response.
+			data, _ := model.MarshalFindResponse(&model.FindResponse{
+				MultihashResults: []model.MultihashResult{{
+					Multihash:       testMultihash(t, "json"),
+					ProviderResults: prs,
+				}},
+			})
+			w.Header().Set("Content-Type", mediaTypeJSON)
+			w.Write(data)
+		},
+		streamPath(t, "truncated"): func(w http.ResponseWriter, r *http.Request) {
+			writeRecords(w, prs[:2])
+			data, _ := json.Marshal(prs[2])
+			w.Write(data[:len(data)/2])
+		},
+		streamPath(t, "aborted"): func(w http.ResponseWriter, r *http.Request) {
+			writeRecords(w, prs[:2])
+			// Abort the response after some results were sent.
+			panic(http.ErrAbortHandler)
+		},
+		streamPath(t, "error"): func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "overloaded", http.StatusServiceUnavailable)
+		},
+		streamPath(t, "slow"): func(w http.ResponseWriter, r *http.Request) {
+			writeRecords(w, prs[:1])
+			select {
+			case <-block:
+			case <-r.Context().Done():
+			}
+		},
+	})
+	finder, err := newNDJSONFinder(srv.URL)
+	require.NoError(t, err)
+
+	t.Run("ndjson", func(t *testing.T) {
+		got, err := collect(t, finder, "ndjson", nil, 0)
+		require.NoError(t, err)
+		require.Equal(t, prs, got)
+	})
+
+	t.Run("json", func(t *testing.T) {
+		got, err := collect(t, finder, "json", nil, 0)
+		require.NoError(t, err)
+		require.Equal(t, prs, got)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		got, err := collect(t, finder, "not found", nil, 0)
+		require.NoError(t, err)
+		require.Empty(t, got)
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		// Results before the truncated record are kept.
+		got, err := collect(t, finder, "truncated", nil, 0)
+		require.ErrorContains(t, err, "cannot decode provider result")
+		require.Equal(t, prs[:2], got)
+	})
+
+	t.Run("aborted", func(t *testing.T) {
+		got, err := collect(t, finder, "aborted", nil, 0)
+		require.ErrorContains(t, err, "cannot read find response")
+		require.Equal(t, prs[:2], got)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		got, err := collect(t, finder, "error", nil, 0)
+		var ae *apierror.Error
+		require.True(t, errors.As(err, &ae))
+		require.Equal(t, http.StatusServiceUnavailable, ae.Status())
+		require.Empty(t, got)
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		got, err := collect(t, finder, "slow", nil, 100*time.Millisecond)
+		require.ErrorContains(t, err, "timed out after 100ms with 1 results")
+		require.Equal(t, prs[:1], got)
+	})
+
+	t.Run("first", func(t *testing.T) {
+		// The lookup stops at the first result that passes the filter,
+		// without waiting for the rest of the response.
+		filter := &resultFilter{
+			exclude: map[peer.ID]struct{}{prs[0].Provider.ID: {}},
+			first:   true,
+		}
+		got, err := collect(t, finder, "ndjson", filter, 0)
+		require.NoError(t, err)
+		require.Equal(t, prs[1:2], got)
+
+		filter = &resultFilter{first: true}
+		got, err = collect(t, finder, "slow", filter, 0)
+		require.NoError(t, err)
+		require.Equal(t, prs[:1], got)
+	})
+}
+
+// asyncFunc is an asyncFinder that returns its results and error.
+type asyncFunc struct {
+	results []model.ProviderResult
+	err     error
+	finds   int
+}
+
+func (f *asyncFunc) FindAsync(ctx context.Context, _ multihash.Multihash, resChan chan<- model.ProviderResult) error {
+	defer close(resChan)
+	f.finds++
+	for _, pr := range f.results {
+		resChan <- pr
+	}
+	return f.err
+}
+
+func TestStreamLookupFallback(t *testing.T) {
+	prs := streamResults(t)
+	tests := []struct {
+		name      string
+		primary   *asyncFunc
+		wantClear bool
+		wantErr   bool
+	}{
+		{name: "found", primary: &asyncFunc{results: prs[:1]}},
+		{name: "empty", primary: &asyncFunc{}},
+		{name: "not found", primary: &asyncFunc{err: apierror.New(errors.New("not found"), http.StatusNotFound)}},
+		{name: "not implemented", primary: &asyncFunc{err: apierror.New(errors.New("not implemented"), http.StatusNotImplemented)}, wantClear: true},
+		{name: "server error", primary: &asyncFunc{err: apierror.New(errors.New("server error"), http.StatusInternalServerError)}, wantErr: true},
+		// Results were already emitted, so the lookup is not repeated.
+		{name: "error after results", primary: &asyncFunc{results: prs[:1], err: apierror.New(errors.New("bad request"), http.StatusBadRequest)}, wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			fallback := &asyncFunc{results: prs[1:]}
+			var n int
+			err := streamLookup(tc.primary, fallback, nil, nil, 0)(t.Context(), testMultihash(t, "a"), func(resp *model.FindResponse, _ resultSources) {
+				n += len(resp.MultihashResults[0].ProviderResults)
+			})
+			if tc.wantErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+			require.Equal(t, 1, tc.primary.finds)
+			if tc.wantClear {
+				require.Equal(t, 1, fallback.finds)
+				require.Equal(t, 2, n)
+				return
+			}
+			require.Zero(t, fallback.finds)
+			require.Equal(t, len(tc.primary.results), n)
+		})
+	}
+}
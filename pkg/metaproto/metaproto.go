@@ -0,0 +1,53 @@
+// Package metaproto decodes the transport protocols of provider metadata, for
+// output and for filtering results by protocol.
+package metaproto
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/multiformats/go-multicodec"
+)
+
+// Decode decodes the metadata using the default protocols.
+func Decode(metaBytes []byte) (metadata.Metadata, error) {
+	meta := metadata.Default.New()
+	if err := meta.UnmarshalBinary(metaBytes); err != nil {
+		return metadata.Metadata{}, err
+	}
+	return meta, nil
+}
+
+// Codes returns the multicodec codes of the transport protocols in the
+// metadata.
+func Codes(metaBytes []byte) ([]multicodec.Code, error) {
+	meta, err := Decode(metaBytes)
+	if err != nil {
+		return nil, err
+	}
+	return meta.Protocols(), nil
+}
+
+// Names returns the names of the transport protocols in the metadata.
+func Names(metaBytes []byte) ([]string, error) {
+	codes, err := Codes(metaBytes)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(codes))
+	for i, code := range codes {
+		names[i] = code.String()
+	}
+	return names, nil
+}
+
+// String returns the names of the transport protocols in the metadata,
+// separated by commas, or the error if the metadata cannot be decoded.
+func String(metaBytes []byte) string {
+	names, err := Names(metaBytes)
+	if err != nil {
+		return fmt.Sprint("error: ", err)
+	}
+	return strings.Join(names, ", ")
+}
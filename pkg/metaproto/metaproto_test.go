@@ -0,0 +1,37 @@
+package metaproto_test
+
+import (
+	"testing"
+
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/ipni/ipni-cli/pkg/metaproto"
+	"github.com/multiformats/go-multicodec"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecode(t *testing.T) {
+	meta := metadata.Default.New(metadata.Bitswap{}, metadata.IpfsGatewayHttp{})
+	metaBytes, err := meta.MarshalBinary()
+	require.NoError(t, err)
+
+	codes, err := metaproto.Codes(metaBytes)
+	require.NoError(t, err)
+	require.Equal(t, []multicodec.Code{multicodec.TransportBitswap, multicodec.TransportIpfsGatewayHttp}, codes)
+
+	names, err := metaproto.Names(metaBytes)
+	require.NoError(t, err)
+	require.Equal(t, []string{"transport-bitswap", "transport-ipfs-gateway-http"}, names)
+	require.Equal(t, "transport-bitswap, transport-ipfs-gateway-http", metaproto.String(metaBytes))
+
+	decoded, err := metaproto.Decode(metaBytes)
+	require.NoError(t, err)
+	require.True(t, meta.Equal(decoded))
+}
+
+func TestDecodeError(t *testing.T) {
+	_, err := metaproto.Names(nil)
+	require.Error(t, err)
+	_, err = metaproto.Codes([]byte{0xff})
+	require.Error(t, err)
+	require.Contains(t, metaproto.String([]byte{0xff}), "error: ")
+}
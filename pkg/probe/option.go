@@ -0,0 +1,60 @@
+package probe
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+)
+
+const defaultTimeout = 10 * time.Second
+
+type config struct {
+	httpClient *http.Client
+	p2pHost    host.Host
+	timeout    time.Duration
+}
+
+// Option is a function that sets a value in a config.
+type Option func(*config)
+
+// getOpts creates a config and applies Options to it.
+func getOpts(opts []Option) config {
+	cfg := config{
+		timeout: defaultTimeout,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.httpClient == nil {
+		cfg.httpClient = &http.Client{
+			Timeout: cfg.timeout,
+		}
+	}
+	return cfg
+}
+
+// WithHTTPClient sets the HTTP client used for trustless gateway probes.
+func WithHTTPClient(c *http.Client) Option {
+	return func(cfg *config) {
+		cfg.httpClient = c
+	}
+}
+
+// WithP2pHost sets the libp2p host used for Bitswap probes. If not set, a new
+// host is created and closed when the Prober is closed.
+func WithP2pHost(h host.Host) Option {
+	return func(cfg *config) {
+		cfg.p2pHost = h
+	}
+}
+
+// WithTimeout sets how long to wait for each probe to complete. Default is 10
+// seconds.
+func WithTimeout(timeout time.Duration) Option {
+	return func(cfg *config) {
+		if timeout > 0 {
+			cfg.timeout = timeout
+		}
+	}
+}
@@ -0,0 +1,305 @@
+// Package probe checks whether content is retrievable from a provider, using
+// the retrieval protocol that the provider advertised for the content.
+package probe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	bsmsg "github.com/ipfs/boxo/bitswap/message"
+	bspb "github.com/ipfs/boxo/bitswap/message/pb"
+	"github.com/ipfs/go-cid"
+	"github.com/ipni/go-libipni/find/model"
+	"github.com/ipni/go-libipni/maurl"
+	"github.com/ipni/ipni-cli/pkg/metaproto"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-msgio"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+)
+
+// BitswapProtocol is the Bitswap protocol version that supports want-have.
+const BitswapProtocol protocol.ID = "/ipfs/bitswap/1.2.0"
+
+const maxMessageSize = 4 << 20
+
+var (
+	ErrNoHTTPAddrs   = errors.New("no http addresses")
+	ErrDontHave      = errors.New("provider does not have block")
+	ErrNotSupported  = errors.New("probe not supported for protocol")
+	ErrNoAddrs       = errors.New("no provider addresses")
+	ErrNoProvider    = errors.New("no provider in result")
+	ErrBadStatusCode = errors.New("unexpected http status")
+)
+
+// Result is the outcome of a retrieval check using one protocol.
+type Result struct {
+	// Protocol is the retrieval protocol that was checked.
+	Protocol multicodec.Code
+	// OK is true if the provider responded that it has the content.
+	OK bool
+	// Latency is the time taken to get a response, including connecting.
+	Latency time.Duration
+	// Err describes why the check failed.
+	Err error
+}
+
+// Prober performs lightweight retrieval checks against providers.
+type Prober struct {
+	httpClient *http.Client
+	p2pHost    host.Host
+	ownHost    bool
+	timeout    time.Duration
+
+	mutex   sync.Mutex
+	waiters map[waitKey][]chan bool
+}
+
+type waitKey struct {
+	peerID peer.ID
+	mh     string
+}
+
+// New creates a Prober. The Prober must be closed when no longer needed.
+func New(options ...Option) (*Prober, error) {
+	opts := getOpts(options)
+
+	p := &Prober{
+		httpClient: opts.httpClient,
+		p2pHost:    opts.p2pHost,
+		timeout:    opts.timeout,
+		waiters:    make(map[waitKey][]chan bool),
+	}
+	if p.p2pHost == nil {
+		var err error
+		p.p2pHost, err = libp2p.New()
+		if err != nil {
+			return nil, fmt.Errorf("cannot create libp2p host: %w", err)
+		}
+		p.ownHost = true
+	}
+	// Bitswap responses arrive on a stream opened by the remote peer.
+	p.p2pHost.SetStreamHandler(BitswapProtocol, p.handleBitswap)
+
+	return p, nil
+}
+
+// Close removes the Bitswap stream handler, and closes the libp2p host if it
+// was created by the Prober.
+func (p *Prober) Close() error {
+	p.p2pHost.RemoveStreamHandler(BitswapProtocol)
+	if p.ownHost {
+		return p.p2pHost.Close()
+	}
+	return nil
+}
+
+// Probe checks retrieval of the multihash from the provider in the provider
+// result, using each protocol in the result's metadata. A Result is returned
+// for each protocol.
+func (p *Prober) Probe(ctx context.Context, mh multihash.Multihash, pr model.ProviderResult) []Result {
+	protos, err := metaproto.Codes(pr.Metadata)
+	if err != nil {
+		return []Result{{Err: fmt.Errorf("cannot decode metadata: %w", err)}}
+	}
+	if pr.Provider == nil {
+		return []Result{{Err: ErrNoProvider}}
+	}
+
+	c := cid.NewCidV1(cid.Raw, mh)
+	results := make([]Result, 0, len(protos))
+	for _, proto := range protos {
+		var res Result
+		switch proto {
+		case multicodec.TransportBitswap:
+			res = p.ProbeBitswap(ctx, *pr.Provider, c)
+		case multicodec.TransportIpfsGatewayHttp:
+			res = p.ProbeHTTP(ctx, *pr.Provider, c)
+		default:
+			res = Result{
+				Protocol: proto,
+				Err:      ErrNotSupported,
+			}
+		}
+		results = append(results, res)
+	}
+	return results
+}
+
+// ProbeHTTP sends a trustless gateway HEAD request for the block identified by
+// the CID, to each HTTP address of the provider until one succeeds.
+func (p *Prober) ProbeHTTP(ctx context.Context, provider peer.AddrInfo, c cid.Cid) Result {
+	res := Result{
+		Protocol: multicodec.TransportIpfsGatewayHttp,
+		Err:      ErrNoHTTPAddrs,
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	start := time.Now()
+	for _, addr := range provider.Addrs {
+		if !isHTTPAddr(addr) {
+			continue
+		}
+		u, err := maurl.ToURL(addr)
+		if err != nil {
+			res.Err = err
+			continue
+		}
+		u = u.JoinPath("ipfs", c.String())
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, u.String(), nil)
+		if err != nil {
+			res.Err = err
+			continue
+		}
+		req.Header.Set("Accept", "application/vnd.ipld.raw")
+
+		rsp, err := p.httpClient.Do(req)
+		if err != nil {
+			res.Err = err
+			continue
+		}
+		io.Copy(io.Discard, rsp.Body)
+		rsp.Body.Close()
+		res.Latency = time.Since(start)
+
+		if rsp.StatusCode != http.StatusOK {
+			res.Err = fmt.Errorf("%w: %s", ErrBadStatusCode, rsp.Status)
+			continue
+		}
+		res.OK = true
+		res.Err = nil
+		break
+	}
+	return res
+}
+
+func isHTTPAddr(addr multiaddr.Multiaddr) bool {
+	for _, c := range addr {
+		switch c.Code() {
+		case multiaddr.P_HTTP, multiaddr.P_HTTPS:
+			return true
+		}
+	}
+	return false
+}
+
+// ProbeBitswap sends a Bitswap want-have for the CID to the provider and waits
+// for the provider to respond with have or dont-have.
+func (p *Prober) ProbeBitswap(ctx context.Context, provider peer.AddrInfo, c cid.Cid) (res Result) {
+	res.Protocol = multicodec.TransportBitswap
+	if len(provider.Addrs) == 0 && len(p.p2pHost.Peerstore().Addrs(provider.ID)) == 0 {
+		res.Err = ErrNoAddrs
+		return res
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	key := waitKey{
+		peerID: provider.ID,
+		mh:     string(c.Hash()),
+	}
+	waiter := make(chan bool, 1)
+	p.mutex.Lock()
+	p.waiters[key] = append(p.waiters[key], waiter)
+	p.mutex.Unlock()
+	defer func() {
+		p.mutex.Lock()
+		waiters := slices.DeleteFunc(p.waiters[key], func(w chan bool) bool { return w == waiter })
+		if len(waiters) == 0 {
+			delete(p.waiters, key)
+		} else {
+			p.waiters[key] = waiters
+		}
+		p.mutex.Unlock()
+	}()
+
+	start := time.Now()
+	defer func() {
+		res.Latency = time.Since(start)
+	}()
+
+	if err := p.p2pHost.Connect(ctx, provider); err != nil {
+		res.Err = fmt.Errorf("cannot connect: %w", err)
+		return res
+	}
+	s, err := p.p2pHost.NewStream(ctx, provider.ID, BitswapProtocol)
+	if err != nil {
+		res.Err = fmt.Errorf("cannot open bitswap stream: %w", err)
+		return res
+	}
+	msg := bsmsg.New(false)
+	msg.AddEntry(c, 1, bspb.Message_Wantlist_Have, true)
+	err = msg.ToNetV1(s)
+	s.Close()
+	if err != nil {
+		res.Err = fmt.Errorf("cannot send want-have: %w", err)
+		return res
+	}
+
+	select {
+	case have := <-waiter:
+		if !have {
+			res.Err = ErrDontHave
+			return res
+		}
+		res.OK = true
+	case <-ctx.Done():
+		res.Err = fmt.Errorf("no bitswap response: %w", ctx.Err())
+	}
+	return res
+}
+
+// handleBitswap reads Bitswap messages from a remote peer and delivers block
+// presence responses to the waiting probes.
+func (p *Prober) handleBitswap(s network.Stream) {
+	defer s.Close()
+	from := s.Conn().RemotePeer()
+	reader := msgio.NewVarintReaderSize(s, maxMessageSize)
+	for {
+		msg, _, err := bsmsg.FromMsgReader(reader)
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				s.Reset()
+			}
+			return
+		}
+		for _, c := range msg.Haves() {
+			p.notify(from, c, true)
+		}
+		for _, blk := range msg.Blocks() {
+			p.notify(from, blk.Cid(), true)
+		}
+		for _, c := range msg.DontHaves() {
+			p.notify(from, c, false)
+		}
+	}
+}
+
+func (p *Prober) notify(from peer.ID, c cid.Cid, have bool) {
+	key := waitKey{
+		peerID: from,
+		mh:     string(c.Hash()),
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for _, waiter := range p.waiters[key] {
+		select {
+		case waiter <- have:
+		default:
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package probe_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	bsmsg "github.com/ipfs/boxo/bitswap/message"
+	"github.com/ipfs/go-cid"
+	"github.com/ipni/go-libipni/find/model"
+	"github.com/ipni/go-libipni/maurl"
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/ipni/ipni-cli/pkg/probe"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func newHost(t *testing.T) host.Host {
+	h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	t.Cleanup(func() { h.Close() })
+	return h
+}
+
+func testMultihash(t *testing.T, data string) multihash.Multihash {
+	mh, err := multihash.Sum([]byte(data), multihash.SHA2_256, -1)
+	require.NoError(t, err)
+	return mh
+}
+
+// newBitswapResponder starts a host that answers each want-have with have, if
+// the block is in blocks, or dont-have otherwise.
+func newBitswapResponder(t *testing.T, blocks ...multihash.Multihash) host.Host {
+	h := newHost(t)
+	have := make(map[string]struct{}, len(blocks))
+	for _, mh := range blocks {
+		have[string(mh)] = struct{}{}
+	}
+	h.SetStreamHandler(probe.BitswapProtocol, func(s network.Stream) {
+		defer s.Close()
+		msg, _, err := bsmsg.FromNet(s)
+		if err != nil {
+			return
+		}
+		rsp := bsmsg.New(false)
+		for _, entry := range msg.Wantlist() {
+			if _, ok := have[string(entry.Cid.Hash())]; ok {
+				rsp.AddHave(entry.Cid)
+			} else {
+				rsp.AddDontHave(entry.Cid)
+			}
+		}
+		out, err := h.NewStream(t.Context(), s.Conn().RemotePeer(), probe.BitswapProtocol)
+		if err != nil {
+			return
+		}
+		defer out.Close()
+		rsp.ToNetV1(out)
+	})
+	return h
+}
+
+func TestProbeHTTP(t *testing.T) {
+	mhHave := testMultihash(t, "have")
+	mhMissing := testMultihash(t, "missing")
+	cidHave := cid.NewCidV1(cid.Raw, mhHave)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead || r.URL.Path != "/ipfs/"+cidHave.String() {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+	httpAddr, err := maurl.FromURL(u)
+	require.NoError(t, err)
+
+	prober, err := probe.New(probe.WithP2pHost(newHost(t)))
+	require.NoError(t, err)
+	defer prober.Close()
+
+	provider := peer.AddrInfo{
+		ID:    newHost(t).ID(),
+		Addrs: []multiaddr.Multiaddr{httpAddr},
+	}
+	res := prober.ProbeHTTP(t.Context(), provider, cidHave)
+	require.NoError(t, res.Err)
+	require.True(t, res.OK)
+	require.Equal(t, multicodec.TransportIpfsGatewayHttp, res.Protocol)
+	require.NotZero(t, res.Latency)
+
+	res = prober.ProbeHTTP(t.Context(), provider, cid.NewCidV1(cid.Raw, mhMissing))
+	require.ErrorIs(t, res.Err, probe.ErrBadStatusCode)
+	require.False(t, res.OK)
+
+	provider.Addrs = nil
+	res = prober.ProbeHTTP(t.Context(), provider, cidHave)
+	require.ErrorIs(t, res.Err, probe.ErrNoHTTPAddrs)
+}
+
+func TestProbeBitswap(t *testing.T) {
+	mhHave := testMultihash(t, "have")
+	mhMissing := testMultihash(t, "missing")
+	responder := newBitswapResponder(t, mhHave)
+
+	prober, err := probe.New(probe.WithP2pHost(newHost(t)), probe.WithTimeout(5*time.Second))
+	require.NoError(t, err)
+	defer prober.Close()
+
+	provider := peer.AddrInfo{
+		ID:    responder.ID(),
+		Addrs: responder.Addrs(),
+	}
+	res := prober.ProbeBitswap(t.Context(), provider, cid.NewCidV1(cid.Raw, mhHave))
+	require.NoError(t, res.Err)
+	require.True(t, res.OK)
+	require.Equal(t, multicodec.TransportBitswap, res.Protocol)
+
+	res = prober.ProbeBitswap(t.Context(), provider, cid.NewCidV1(cid.Raw, mhMissing))
+	require.ErrorIs(t, res.Err, probe.ErrDontHave)
+	require.False(t, res.OK)
+
+	// Provider that does not respond.
+	silent := newHost(t)
+	timeoutProber, err := probe.New(probe.WithP2pHost(newHost(t)), probe.WithTimeout(time.Second))
+	require.NoError(t, err)
+	defer timeoutProber.Close()
+	res = timeoutProber.ProbeBitswap(t.Context(), peer.AddrInfo{ID: silent.ID(), Addrs: silent.Addrs()}, cid.NewCidV1(cid.Raw, mhHave))
+	require.Error(t, res.Err)
+	require.False(t, res.OK)
+}
+
+func TestProbe(t *testing.T) {
+	mhHave := testMultihash(t, "have")
+	responder := newBitswapResponder(t, mhHave)
+
+	md := metadata.Default.New(metadata.Bitswap{}, metadata.IpfsGatewayHttp{})
+	meta, err := md.MarshalBinary()
+	require.NoError(t, err)
+
+	prober, err := probe.New(probe.WithP2pHost(newHost(t)))
+	require.NoError(t, err)
+	defer prober.Close()
+
+	results := prober.Probe(t.Context(), mhHave, model.ProviderResult{
+		Metadata: meta,
+		Provider: &peer.AddrInfo{
+			ID:    responder.ID(),
+			Addrs: responder.Addrs(),
+		},
+	})
+	require.Len(t, results, 2)
+	for _, res := range results {
+		switch res.Protocol {
+		case multicodec.TransportBitswap:
+			require.True(t, res.OK)
+		case multicodec.TransportIpfsGatewayHttp:
+			require.ErrorIs(t, res.Err, probe.ErrNoHTTPAddrs)
+		default:
+			t.Fatal("unexpected protocol", res.Protocol)
+		}
+	}
+}
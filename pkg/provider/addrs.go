@@ -0,0 +1,345 @@
+package provider
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipni/go-libipni/find/model"
+	"github.com/ipni/go-libipni/ingest/schema"
+	"github.com/ipni/go-libipni/pcache"
+	"github.com/ipni/ipni-cli/pkg/adpub"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"github.com/urfave/cli/v3"
+)
+
+const (
+	addrStatusOK      = "ok"
+	addrStatusMissing = "missing"
+	addrStatusStale   = "stale"
+)
+
+var providerAddrsSubCmd = &cli.Command{
+	Name:  "addrs",
+	Usage: "Compare the addresses in a provider's head advertisement with the indexer's",
+	Description: `Get the provider's head advertisement from its publisher, and compare the addresses and extended providers in the advertisement with the provider information from the indexer. Each address is reported as:
+
+    ok       in both the advertisement and the indexer's provider information
+    missing  in the advertisement, but not yet in the indexer's provider information
+    stale    in the indexer's provider information, but no longer in the advertisement
+
+Addresses that cannot be reached from the internet, such as private or loopback IP addresses, or addresses without a tcp, quic, or http transport, are flagged as unreachable.
+
+Extended providers are only compared when the head advertisement has extended providers, since other advertisements leave the extended providers unchanged.
+
+A publisher may publish advertisements for more than one provider. If the head advertisement is for a different provider, then its addresses and extended providers are not this provider's, and the comparison is reported as not applicable.`,
+	Flags:  providerAddrsFlags,
+	Action: providerAddrsAction,
+}
+
+var providerAddrsFlags = []cli.Flag{
+	&cli.StringSliceFlag{
+		Name:    "indexer",
+		Usage:   "Indexer URL. Specifying multiple results in a unified view of providers across all.",
+		Aliases: []string{"i"},
+		Value:   []string{"https://cid.contact"},
+	},
+	&cli.StringSliceFlag{
+		Name:     "pid",
+		Usage:    "Provider's peer ID, multiple allowed",
+		Required: true,
+	},
+	&cli.DurationFlag{
+		Name:  "timeout",
+		Usage: "Timeout for http connections to the publisher",
+		Value: 10 * time.Second,
+	},
+	&cli.StringFlag{
+		Name:    "output",
+		Usage:   "Output format: text or json",
+		Aliases: []string{"o"},
+		Value:   outputText,
+	},
+}
+
+// addrCheck is the result of comparing the addresses in a provider's head
+// advertisement with the indexer's provider information.
+type addrCheck struct {
+	ID     peer.ID `json:"id"`
+	HeadAd string  `json:"head_ad,omitempty"`
+	// AdProvider is the provider of the head advertisement, when it is
+	// different from the checked provider. The addresses are not compared,
+	// and NotApplicable says why.
+	AdProvider        peer.ID      `json:"ad_provider,omitempty"`
+	NotApplicable     string       `json:"not_applicable,omitempty"`
+	Addrs             []addrStatus `json:"addrs,omitempty"`
+	ExtendedProviders []epStatus   `json:"extended_providers,omitempty"`
+	Error             string       `json:"error,omitempty"`
+}
+
+// addrStatus is the status of one address.
+type addrStatus struct {
+	Addr        string `json:"addr"`
+	Status      string `json:"status"`
+	Unreachable string `json:"unreachable,omitempty"`
+}
+
+// epStatus is the status of one extended provider.
+type epStatus struct {
+	ID     string       `json:"id"`
+	Status string       `json:"status"`
+	Addrs  []addrStatus `json:"addrs"`
+}
+
+func providerAddrsAction(ctx context.Context, cmd *cli.Command) error {
+	format := cmd.String("output")
+	if format != outputText && format != outputJSON {
+		return cli.Exit(fmt.Sprintf("unsupported output format %q: must be text or json", format), 1)
+	}
+
+	pids := cmd.StringSlice("pid")
+	peerIDs := make([]peer.ID, len(pids))
+	for i, pid := range pids {
+		var err error
+		peerIDs[i], err = peer.Decode(pid)
+		if err != nil {
+			return fmt.Errorf("invalid peer ID %s: %s", pid, err)
+		}
+	}
+
+	pc, err := pcache.New(pcache.WithPreload(false), pcache.WithRefreshInterval(0),
+		pcache.WithSourceURL(cmd.StringSlice("indexer")...))
+	if err != nil {
+		return err
+	}
+	p2pHost, err := libp2p.New()
+	if err != nil {
+		return err
+	}
+	defer p2pHost.Close()
+
+	checks := make([]addrCheck, len(peerIDs))
+	for i, peerID := range peerIDs {
+		checks[i] = checkAddrs(ctx, pc, peerID, p2pHost, cmd.Duration("timeout"))
+	}
+
+	if format == outputJSON {
+		data, err := json.MarshalIndent(checks, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+	for _, check := range checks {
+		check.print()
+	}
+	return nil
+}
+
+func checkAddrs(ctx context.Context, pc *pcache.ProviderCache, peerID peer.ID, p2pHost host.Host, timeout time.Duration) addrCheck {
+	ad, pinfo, err := getHeadAd(ctx, pc, peerID, p2pHost, timeout)
+	if err != nil {
+		return addrCheck{
+			ID:    peerID,
+			Error: err.Error(),
+		}
+	}
+	return compareHeadAd(peerID, ad, pinfo)
+}
+
+// compareHeadAd compares the addresses and extended providers in the head
+// advertisement with the indexer's provider information for the provider.
+func compareHeadAd(peerID peer.ID, ad *adpub.Advertisement, pinfo *model.ProviderInfo) addrCheck {
+	check := addrCheck{
+		ID:     peerID,
+		HeadAd: ad.ID.String(),
+	}
+	if ad.ProviderID != peerID {
+		check.AdProvider = ad.ProviderID
+		check.NotApplicable = "head advertisement is for another provider of the same publisher"
+		return check
+	}
+
+	check.Addrs = compareAddrs(ad.Addresses, addrStrings(pinfo.AddrInfo.Addrs))
+
+	if ad.ExtendedProvider != nil {
+		indexerEPs := indexerExtendedProviders(pinfo, ad.ContextID)
+		check.ExtendedProviders = compareExtendedProviders(ad.ExtendedProvider.Providers, indexerEPs)
+	}
+	return check
+}
+
+// indexerExtendedProviders returns the indexer's extended providers for the
+// context ID, or the provider's extended providers if the context ID is empty.
+func indexerExtendedProviders(pinfo *model.ProviderInfo, contextID []byte) []peer.AddrInfo {
+	if pinfo.ExtendedProviders == nil {
+		return nil
+	}
+	if len(contextID) == 0 {
+		return pinfo.ExtendedProviders.Providers
+	}
+	ctxID := base64.StdEncoding.EncodeToString(contextID)
+	for _, cep := range pinfo.ExtendedProviders.Contextual {
+		if cep.ContextID == ctxID {
+			return cep.Providers
+		}
+	}
+	return nil
+}
+
+func getHeadAd(ctx context.Context, pc *pcache.ProviderCache, peerID peer.ID, p2pHost host.Host, timeout time.Duration) (*adpub.Advertisement, *model.ProviderInfo, error) {
+	pinfo, err := pc.Get(ctx, peerID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if pinfo == nil {
+		return nil, nil, errors.New("provider not found on indexer")
+	}
+	if pinfo.Publisher == nil {
+		return nil, nil, errors.New("provider has no publisher")
+	}
+
+	pubClient, err := adpub.NewClient(*pinfo.Publisher,
+		adpub.WithLibp2pHost(p2pHost),
+		adpub.WithHttpTimeout(timeout),
+		adpub.WithEntriesDepthLimit(1))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer pubClient.Close()
+
+	ad, err := pubClient.GetAdvertisement(ctx, cid.Undef)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot get head advertisement: %w", err)
+	}
+	return ad, pinfo, nil
+}
+
+// compareAddrs returns the status of each address in the advertisement and in
+// the indexer's provider information.
+func compareAddrs(adAddrs, indexerAddrs []string) []addrStatus {
+	statuses := make([]addrStatus, 0, len(adAddrs)+len(indexerAddrs))
+	for _, addr := range adAddrs {
+		status := addrStatusMissing
+		if slices.Contains(indexerAddrs, addr) {
+			status = addrStatusOK
+		}
+		statuses = append(statuses, addrStatus{
+			Addr:        addr,
+			Status:      status,
+			Unreachable: unreachableAddr(addr),
+		})
+	}
+	for _, addr := range indexerAddrs {
+		if !slices.Contains(adAddrs, addr) {
+			statuses = append(statuses, addrStatus{
+				Addr:        addr,
+				Status:      addrStatusStale,
+				Unreachable: unreachableAddr(addr),
+			})
+		}
+	}
+	return statuses
+}
+
+func compareExtendedProviders(adEPs []schema.Provider, indexerEPs []peer.AddrInfo) []epStatus {
+	statuses := make([]epStatus, 0, len(adEPs)+len(indexerEPs))
+	for _, ep := range adEPs {
+		status := epStatus{
+			ID:     ep.ID,
+			Status: addrStatusMissing,
+		}
+		var indexerAddrs []string
+		i := slices.IndexFunc(indexerEPs, func(ai peer.AddrInfo) bool { return ai.ID.String() == ep.ID })
+		if i != -1 {
+			status.Status = addrStatusOK
+			indexerAddrs = addrStrings(indexerEPs[i].Addrs)
+		}
+		status.Addrs = compareAddrs(ep.Addresses, indexerAddrs)
+		statuses = append(statuses, status)
+	}
+	for _, ai := range indexerEPs {
+		id := ai.ID.String()
+		if slices.ContainsFunc(adEPs, func(ep schema.Provider) bool { return ep.ID == id }) {
+			continue
+		}
+		statuses = append(statuses, epStatus{
+			ID:     id,
+			Status: addrStatusStale,
+			Addrs:  compareAddrs(nil, addrStrings(ai.Addrs)),
+		})
+	}
+	return statuses
+}
+
+// unreachableAddr returns the reason that an address cannot be reached from
+// the internet, or an empty string if it may be reachable.
+func unreachableAddr(addr string) string {
+	maddr, err := multiaddr.NewMultiaddr(addr)
+	if err != nil {
+		return fmt.Sprintf("invalid multiaddr: %s", err)
+	}
+	switch {
+	case manet.IsIPLoopback(maddr):
+		return "loopback address"
+	case manet.IsIPUnspecified(maddr):
+		return "unspecified address"
+	case manet.IsPrivateAddr(maddr):
+		return "private address"
+	case addrProto(maddr) == "":
+		return "no tcp, quic, or http transport"
+	}
+	return ""
+}
+
+func (c addrCheck) print() {
+	fmt.Println("Provider", c.ID)
+	if c.Error != "" {
+		fmt.Println("    Error:", c.Error)
+		fmt.Println()
+		return
+	}
+	fmt.Println("    Head advertisement:", c.HeadAd)
+	if c.AdProvider != "" {
+		fmt.Println("    Head advertisement is for provider:", c.AdProvider)
+	}
+	if c.NotApplicable != "" {
+		fmt.Println("    Not applicable:", c.NotApplicable)
+		fmt.Println()
+		return
+	}
+	fmt.Println("    Addresses:")
+	printAddrStatuses(c.Addrs, "        ")
+	if c.ExtendedProviders != nil {
+		fmt.Println("    Extended providers:")
+		for _, ep := range c.ExtendedProviders {
+			fmt.Printf("        %-8s %s\n", ep.Status, ep.ID)
+			printAddrStatuses(ep.Addrs, "            ")
+		}
+	}
+	fmt.Println()
+}
+
+func printAddrStatuses(statuses []addrStatus, indent string) {
+	if len(statuses) == 0 {
+		fmt.Println(indent + "none")
+		return
+	}
+	for _, as := range statuses {
+		fmt.Printf("%s%-8s %s", indent, as.Status, as.Addr)
+		if as.Unreachable != "" {
+			fmt.Printf("  (unreachable: %s)", as.Unreachable)
+		}
+		fmt.Println()
+	}
+}
@@ -0,0 +1,232 @@
+package provider
+
+import (
+	"testing"
+
+	"github.com/ipni/go-libipni/find/model"
+	"github.com/ipni/go-libipni/ingest/schema"
+	"github.com/ipni/ipni-cli/pkg/adpub"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	publicAddr  = "/ip4/1.2.3.4/tcp/1"
+	publicAddr2 = "/dns/a.example/tcp/443/https"
+	privateAddr = "/ip4/192.168.1.2/tcp/1"
+)
+
+func TestUnreachableAddr(t *testing.T) {
+	tests := []struct {
+		addr string
+		want string
+	}{
+		{addr: publicAddr},
+		{addr: "/ip4/1.2.3.4/udp/1/quic-v1"},
+		{addr: publicAddr2},
+		{addr: "/ip6/2001:db8::1/tcp/1"},
+		{addr: "/ip4/127.0.0.1/tcp/1", want: "loopback address"},
+		{addr: "/ip6/::1/tcp/1", want: "loopback address"},
+		{addr: "/ip4/0.0.0.0/tcp/1", want: "unspecified address"},
+		{addr: privateAddr, want: "private address"},
+		{addr: "/ip4/10.0.0.1/udp/1/quic-v1", want: "private address"},
+		{addr: "/ip4/1.2.3.4/udp/1", want: "no tcp, quic, or http transport"},
+		{addr: "not a multiaddr", want: "invalid multiaddr: "},
+	}
+	for _, tc := range tests {
+		got := unreachableAddr(tc.addr)
+		if tc.want == "" {
+			require.Empty(t, got, "addr: %s", tc.addr)
+			continue
+		}
+		require.Contains(t, got, tc.want, "addr: %s", tc.addr)
+	}
+}
+
+func TestCompareAddrs(t *testing.T) {
+	tests := []struct {
+		name    string
+		ad      []string
+		indexer []string
+		want    []addrStatus
+	}{
+		{
+			name: "none",
+			want: []addrStatus{},
+		},
+		{
+			name:    "same",
+			ad:      []string{publicAddr, publicAddr2},
+			indexer: []string{publicAddr2, publicAddr},
+			want: []addrStatus{
+				{Addr: publicAddr, Status: addrStatusOK},
+				{Addr: publicAddr2, Status: addrStatusOK},
+			},
+		},
+		{
+			name:    "missing and stale",
+			ad:      []string{publicAddr, privateAddr},
+			indexer: []string{publicAddr, publicAddr2},
+			want: []addrStatus{
+				{Addr: publicAddr, Status: addrStatusOK},
+				{Addr: privateAddr, Status: addrStatusMissing, Unreachable: "private address"},
+				{Addr: publicAddr2, Status: addrStatusStale},
+			},
+		},
+		{
+			name: "not in indexer",
+			ad:   []string{publicAddr},
+			want: []addrStatus{
+				{Addr: publicAddr, Status: addrStatusMissing},
+			},
+		},
+		{
+			name:    "not in advertisement",
+			indexer: []string{publicAddr},
+			want: []addrStatus{
+				{Addr: publicAddr, Status: addrStatusStale},
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.want, compareAddrs(tc.ad, tc.indexer))
+		})
+	}
+}
+
+func TestCompareExtendedProviders(t *testing.T) {
+	tests := []struct {
+		name    string
+		ad      []schema.Provider
+		indexer []peer.AddrInfo
+		want    []epStatus
+	}{
+		{
+			name: "none",
+			want: []epStatus{},
+		},
+		{
+			name:    "same",
+			ad:      []schema.Provider{{ID: pidB, Addresses: []string{publicAddr}}},
+			indexer: []peer.AddrInfo{addrInfo(t, pidB, publicAddr)},
+			want: []epStatus{
+				{ID: pidB, Status: addrStatusOK, Addrs: []addrStatus{{Addr: publicAddr, Status: addrStatusOK}}},
+			},
+		},
+		{
+			name:    "changed addrs",
+			ad:      []schema.Provider{{ID: pidB, Addresses: []string{publicAddr2}}},
+			indexer: []peer.AddrInfo{addrInfo(t, pidB, publicAddr)},
+			want: []epStatus{
+				{ID: pidB, Status: addrStatusOK, Addrs: []addrStatus{
+					{Addr: publicAddr2, Status: addrStatusMissing},
+					{Addr: publicAddr, Status: addrStatusStale},
+				}},
+			},
+		},
+		{
+			name:    "missing and stale",
+			ad:      []schema.Provider{{ID: pidB, Addresses: []string{publicAddr}}},
+			indexer: []peer.AddrInfo{addrInfo(t, pidC, privateAddr)},
+			want: []epStatus{
+				{ID: pidB, Status: addrStatusMissing, Addrs: []addrStatus{{Addr: publicAddr, Status: addrStatusMissing}}},
+				{ID: pidC, Status: addrStatusStale, Addrs: []addrStatus{{Addr: privateAddr, Status: addrStatusStale, Unreachable: "private address"}}},
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.want, compareExtendedProviders(tc.ad, tc.indexer))
+		})
+	}
+}
+
+func TestCompareHeadAd(t *testing.T) {
+	idA := decodePeerID(t, pidA)
+	pinfo := &model.ProviderInfo{
+		AddrInfo: addrInfo(t, pidA, publicAddr),
+		ExtendedProviders: &model.ExtendedProviders{
+			Providers: []peer.AddrInfo{addrInfo(t, pidB, publicAddr)},
+			Contextual: []model.ContextualExtendedProviders{{
+				ContextID: "Y3R4", // base64 of "ctx"
+				Providers: []peer.AddrInfo{addrInfo(t, pidC, publicAddr2)},
+			}},
+		},
+	}
+
+	tests := []struct {
+		name string
+		ad   *adpub.Advertisement
+		want addrCheck
+	}{
+		{
+			name: "addrs",
+			ad:   &adpub.Advertisement{ID: adCid(t, adCid1), ProviderID: idA, Addresses: []string{publicAddr, publicAddr2}},
+			want: addrCheck{
+				ID:     idA,
+				HeadAd: adCid1,
+				Addrs: []addrStatus{
+					{Addr: publicAddr, Status: addrStatusOK},
+					{Addr: publicAddr2, Status: addrStatusMissing},
+				},
+			},
+		},
+		{
+			name: "other provider",
+			// A publisher's head advertisement may be for another provider,
+			// whose addresses would all look missing or stale.
+			ad: &adpub.Advertisement{ID: adCid(t, adCid1), ProviderID: decodePeerID(t, pidB), Addresses: []string{publicAddr2}},
+			want: addrCheck{
+				ID:            idA,
+				HeadAd:        adCid1,
+				AdProvider:    decodePeerID(t, pidB),
+				NotApplicable: "head advertisement is for another provider of the same publisher",
+			},
+		},
+		{
+			name: "chain extended providers",
+			ad: &adpub.Advertisement{ID: adCid(t, adCid1), ProviderID: idA, Addresses: []string{publicAddr},
+				ExtendedProvider: &schema.ExtendedProvider{Providers: []schema.Provider{{ID: pidB, Addresses: []string{publicAddr}}}}},
+			want: addrCheck{
+				ID:     idA,
+				HeadAd: adCid1,
+				Addrs:  []addrStatus{{Addr: publicAddr, Status: addrStatusOK}},
+				ExtendedProviders: []epStatus{
+					{ID: pidB, Status: addrStatusOK, Addrs: []addrStatus{{Addr: publicAddr, Status: addrStatusOK}}},
+				},
+			},
+		},
+		{
+			name: "contextual extended providers",
+			ad: &adpub.Advertisement{ID: adCid(t, adCid1), ProviderID: idA, Addresses: []string{publicAddr}, ContextID: []byte("ctx"),
+				ExtendedProvider: &schema.ExtendedProvider{Providers: []schema.Provider{{ID: pidC, Addresses: []string{publicAddr2}}}}},
+			want: addrCheck{
+				ID:     idA,
+				HeadAd: adCid1,
+				Addrs:  []addrStatus{{Addr: publicAddr, Status: addrStatusOK}},
+				ExtendedProviders: []epStatus{
+					{ID: pidC, Status: addrStatusOK, Addrs: []addrStatus{{Addr: publicAddr2, Status: addrStatusOK}}},
+				},
+			},
+		},
+		{
+			name: "unknown context",
+			ad: &adpub.Advertisement{ID: adCid(t, adCid1), ProviderID: idA, Addresses: []string{publicAddr}, ContextID: []byte("other"),
+				ExtendedProvider: &schema.ExtendedProvider{Providers: []schema.Provider{{ID: pidC, Addresses: []string{publicAddr2}}}}},
+			want: addrCheck{
+				ID:     idA,
+				HeadAd: adCid1,
+				Addrs:  []addrStatus{{Addr: publicAddr, Status: addrStatusOK}},
+				ExtendedProviders: []epStatus{
+					{ID: pidC, Status: addrStatusMissing, Addrs: []addrStatus{{Addr: publicAddr2, Status: addrStatusMissing}}},
+				},
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.want, compareHeadAd(idA, tc.ad, pinfo))
+		})
+	}
+}
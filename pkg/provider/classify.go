@@ -0,0 +1,89 @@
+package provider
+
+import (
+	"regexp"
+)
+
+const errCategoryUnknown = "unknown"
+
+// errorClass describes a known kind of LastError.
+type errorClass struct {
+	Category    string `json:"category"`
+	Explanation string `json:"explanation"`
+	Fix         string `json:"fix,omitempty"`
+}
+
+// errorClasses are the known kinds of LastError, in the order they are
+// checked. The first pattern that matches the error classifies it. An error
+// often names the sync stage it happened in as well as the cause, such as
+// "failed to sync entries: context deadline exceeded", so the classes that
+// describe a cause come before the classes that describe a stage. A publisher
+// whose host name does not resolve or that has no addresses fails to be dialed,
+// so those errors are checked before other dial failures.
+var errorClasses = []struct {
+	pattern *regexp.Regexp
+	class   errorClass
+}{
+	{
+		pattern: regexp.MustCompile(`(?i)rate.?limit|too many requests|\b429\b`),
+		class: errorClass{
+			Category:    "rate limited",
+			Explanation: "The publisher refused requests from the indexer because too many were made.",
+			Fix:         "Raise or remove the publisher's rate limit for indexer requests.",
+		},
+	},
+	{
+		pattern: regexp.MustCompile(`(?i)signature|signed by|cannot verify|invalid envelope`),
+		class: errorClass{
+			Category:    "bad signature",
+			Explanation: "An advertisement or head was not signed by the expected key, so the indexer rejected it.",
+			Fix:         "Sign advertisements with the provider's identity key, and check that the publisher's peer ID matches the key used to sign the head.",
+		},
+	},
+	{
+		pattern: regexp.MustCompile(`(?i)no such host|publisher not found|no peer addrs|no addresses|unknown publisher`),
+		class: errorClass{
+			Category:    "publisher not found",
+			Explanation: "The indexer could not find an address for the publisher, or the publisher's host name does not resolve.",
+			Fix:         "Check that the publisher's DNS name resolves, and announce the publisher's current addresses.",
+		},
+	},
+	{
+		pattern: regexp.MustCompile(`(?i)dial|connection refused|connection reset|no route to host|network is unreachable|no good addresses`),
+		class: errorClass{
+			Category:    "dial failure",
+			Explanation: "The indexer could not connect to the publisher.",
+			Fix:         "Check that the publisher is running, and that its announced addresses are publicly reachable through any firewall or NAT.",
+		},
+	},
+	{
+		pattern: regexp.MustCompile(`(?i)timeout|timed out|deadline exceeded`),
+		class: errorClass{
+			Category:    "sync timeout",
+			Explanation: "The publisher did not respond to the indexer in time.",
+			Fix:         "Check the publisher's load and the latency of its storage, and that it serves advertisements without long delays.",
+		},
+	},
+	{
+		pattern: regexp.MustCompile(`(?i)entr(y|ies)`),
+		class: errorClass{
+			Category:    "missing entries",
+			Explanation: "The indexer got an advertisement but could not fetch its multihash entries.",
+			Fix:         "Keep the entries of all advertisements available from the publisher until the indexer has synced them.",
+		},
+	},
+}
+
+// classifyError returns the kind of a LastError. If the error does not match
+// a known kind, its category is unknown.
+func classifyError(msg string) errorClass {
+	for _, ec := range errorClasses {
+		if ec.pattern.MatchString(msg) {
+			return ec.class
+		}
+	}
+	return errorClass{
+		Category:    errCategoryUnknown,
+		Explanation: "The error does not match a known kind of error.",
+	}
+}
@@ -0,0 +1,43 @@
+package provider
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		msg  string
+		want string
+	}{
+		{"cannot sync: 429 Too Many Requests", "rate limited"},
+		{"publisher rate limit exceeded", "rate limited"},
+		{"failed to sync entries: rate-limited", "rate limited"},
+		{"invalid signature on head", "bad signature"},
+		{"advertisement not signed by provider", "bad signature"},
+		{"cannot verify envelope: invalid envelope", "bad signature"},
+		{"failed to dial 12D3KooWE8yt84RVwW3sFcd6WMjbUdWrZer2YtT4dmtj3dHdahSZ: connection refused", "dial failure"},
+		{"failed to dial: context deadline exceeded", "dial failure"},
+		{"read tcp 1.2.3.4:1: connection reset by peer", "dial failure"},
+		{"no good addresses", "dial failure"},
+		{"cannot sync with publisher: timed out waiting for head", "sync timeout"},
+		{"failed to sync entries: context deadline exceeded", "sync timeout"},
+		{"failed to sync advertisement: i/o timeout", "sync timeout"},
+		{"publisher not found", "publisher not found"},
+		{"no peer addrs for publisher", "publisher not found"},
+		{"publisher has no addresses", "publisher not found"},
+		{"lookup pub.example: no such host", "publisher not found"},
+		// Dial errors caused by the publisher not being found.
+		{"failed to dial: dial tcp: lookup pub.example: no such host", "publisher not found"},
+		{"failed to dial 12D3KooWE8yt84RVwW3sFcd6WMjbUdWrZer2YtT4dmtj3dHdahSZ: no addresses", "publisher not found"},
+		{"unknown publisher", "publisher not found"},
+		{"failed to sync entries: not found", "missing entries"},
+		{"cannot get entry chunk: 404", "missing entries"},
+		{"storage full", "unknown"},
+		{"", "unknown"},
+	}
+	for _, tc := range tests {
+		require.Equal(t, tc.want, classifyError(tc.msg).Category, "message: %s", tc.msg)
+	}
+}
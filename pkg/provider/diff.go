@@ -0,0 +1,283 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipni/go-libipni/find/model"
+	"github.com/ipni/go-libipni/pcache"
+	"github.com/ipni/ipni-cli/pkg/dtrack"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/urfave/cli/v3"
+)
+
+var providerDiffSubCmd = &cli.Command{
+	Name:  "diff",
+	Usage: "Compare the providers known to two indexers",
+	Description: `Get all providers from indexers A and B, and report the providers that only one indexer knows about. For providers known to both, report differences in the last advertisement, publisher, addresses, frozen state, and last error.
+
+The --distance flag finds how far apart the last advertisements seen by each indexer are on the provider's advertisement chain. This requires fetching advertisements from the publisher.`,
+	Flags:  providerDiffFlags,
+	Action: providerDiffAction,
+}
+
+var providerDiffFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:     "a",
+		Usage:    "URL of first indexer",
+		Required: true,
+	},
+	&cli.StringFlag{
+		Name:     "b",
+		Usage:    "URL of second indexer",
+		Required: true,
+	},
+	&cli.BoolFlag{
+		Name:    "distance",
+		Usage:   "Find the chain distance between the last advertisements seen by each indexer",
+		Aliases: []string{"dist"},
+	},
+	&cli.Int64Flag{
+		Name:    "ad-depth-limit",
+		Aliases: []string{"adl"},
+		Usage:   "Limit on number of advertisements when finding distance. 0 for unlimited.",
+		Value:   5000,
+	},
+	&cli.StringFlag{
+		Name:    "output",
+		Usage:   "Output format: text or json",
+		Aliases: []string{"o"},
+		Value:   outputText,
+	},
+}
+
+// providerDiff describes how one provider differs between indexers.
+type providerDiff struct {
+	ID       peer.ID     `json:"id"`
+	Fields   []fieldDiff `json:"fields,omitempty"`
+	Distance string      `json:"distance,omitempty"`
+}
+
+// fieldDiff is a field that has different values on each indexer.
+type fieldDiff struct {
+	Field string `json:"field"`
+	A     string `json:"a"`
+	B     string `json:"b"`
+}
+
+// diffReport is the result of comparing the providers of two indexers.
+type diffReport struct {
+	A       string         `json:"a"`
+	B       string         `json:"b"`
+	OnlyA   []peer.ID      `json:"only_a"`
+	OnlyB   []peer.ID      `json:"only_b"`
+	Shared  int            `json:"shared"`
+	Changed []providerDiff `json:"changed"`
+}
+
+func providerDiffAction(ctx context.Context, cmd *cli.Command) error {
+	format := cmd.String("output")
+	if format != outputText && format != outputJSON {
+		return cli.Exit(fmt.Sprintf("unsupported output format %q: must be text or json", format), 1)
+	}
+
+	urlA := cmd.String("a")
+	urlB := cmd.String("b")
+	pcA, err := pcache.New(pcache.WithSourceURL(urlA), pcache.WithRefreshInterval(0))
+	if err != nil {
+		return fmt.Errorf("cannot get providers from %s: %w", urlA, err)
+	}
+	pcB, err := pcache.New(pcache.WithSourceURL(urlB), pcache.WithRefreshInterval(0))
+	if err != nil {
+		return fmt.Errorf("cannot get providers from %s: %w", urlB, err)
+	}
+
+	var adDist *dtrack.AdDistance
+	if cmd.Bool("distance") {
+		adDist, err = dtrack.NewAdDistance(dtrack.WithDepthLimit(cmd.Int64("ad-depth-limit")))
+		if err != nil {
+			return err
+		}
+		defer adDist.Close()
+	}
+
+	report := diffProviders(ctx, pcA.List(), pcB.List(), adDist)
+	report.A = urlA
+	report.B = urlB
+
+	if format == outputJSON {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+	report.print()
+	return nil
+}
+
+// diffProviders compares the providers from two indexers. If adDist is not
+// nil, then the chain distance between differing last advertisements is found.
+func diffProviders(ctx context.Context, provsA, provsB []*model.ProviderInfo, adDist *dtrack.AdDistance) diffReport {
+	byID := make(map[peer.ID]*model.ProviderInfo, len(provsB))
+	for _, pinfo := range provsB {
+		byID[pinfo.AddrInfo.ID] = pinfo
+	}
+
+	report := diffReport{
+		OnlyA:   []peer.ID{},
+		OnlyB:   []peer.ID{},
+		Changed: []providerDiff{},
+	}
+	for _, pinfoA := range provsA {
+		pinfoB, ok := byID[pinfoA.AddrInfo.ID]
+		if !ok {
+			report.OnlyA = append(report.OnlyA, pinfoA.AddrInfo.ID)
+			continue
+		}
+		delete(byID, pinfoA.AddrInfo.ID)
+		report.Shared++
+
+		pd := providerDiff{
+			ID:     pinfoA.AddrInfo.ID,
+			Fields: diffFields(pinfoA, pinfoB),
+		}
+		if len(pd.Fields) == 0 {
+			continue
+		}
+		if adDist != nil && pinfoA.LastAdvertisement != pinfoB.LastAdvertisement {
+			pd.Distance = lastAdDistance(ctx, adDist, pinfoA, pinfoB)
+		}
+		report.Changed = append(report.Changed, pd)
+	}
+	for id := range byID {
+		report.OnlyB = append(report.OnlyB, id)
+	}
+
+	slices.Sort(report.OnlyA)
+	slices.Sort(report.OnlyB)
+	slices.SortFunc(report.Changed, func(a, b providerDiff) int {
+		return strings.Compare(string(a.ID), string(b.ID))
+	})
+	return report
+}
+
+// diffFields returns the fields that differ between two indexers' information
+// about the same provider.
+func diffFields(a, b *model.ProviderInfo) []fieldDiff {
+	var diffs []fieldDiff
+	add := func(field, valA, valB string) {
+		if valA != valB {
+			diffs = append(diffs, fieldDiff{Field: field, A: valA, B: valB})
+		}
+	}
+
+	add("last_ad", cidString(a.LastAdvertisement), cidString(b.LastAdvertisement))
+	add("publisher", publisherID(a), publisherID(b))
+	add("publisher_addrs", publisherAddrs(a), publisherAddrs(b))
+	add("addrs", sortedAddrs(addrStrings(a.AddrInfo.Addrs)), sortedAddrs(addrStrings(b.AddrInfo.Addrs)))
+	add("frozen", frozenState(a), frozenState(b))
+	add("last_error", a.LastError, b.LastError)
+	return diffs
+}
+
+// lastAdDistance describes how far apart the last advertisements seen by each
+// indexer are on the provider's advertisement chain.
+func lastAdDistance(ctx context.Context, adDist *dtrack.AdDistance, a, b *model.ProviderInfo) string {
+	if !a.LastAdvertisement.Defined() || !b.LastAdvertisement.Defined() {
+		return "unknown: no last advertisement on one indexer"
+	}
+	publisher := a.Publisher
+	if publisher == nil {
+		publisher = b.Publisher
+	}
+	if publisher == nil {
+		return "unknown: no publisher"
+	}
+
+	fork, err := adDist.CommonAncestor(ctx, *publisher, a.LastAdvertisement, b.LastAdvertisement)
+	if err != nil {
+		return "unknown: " + err.Error()
+	}
+	switch {
+	case fork.Ancestor == a.LastAdvertisement:
+		return fmt.Sprintf("B is %d advertisements ahead of A", fork.DistB)
+	case fork.Ancestor == b.LastAdvertisement:
+		return fmt.Sprintf("A is %d advertisements ahead of B", fork.DistA)
+	case fork.Ancestor.Defined():
+		return fmt.Sprintf("chains fork at %s, %d advertisements before A and %d before B", fork.Ancestor, fork.DistA, fork.DistB)
+	case fork.Disjoint:
+		return "no common advertisement, chains are disjoint"
+	}
+	return "unknown: no common advertisement within depth limit"
+}
+
+func (r diffReport) print() {
+	fmt.Println("A:", r.A)
+	fmt.Println("B:", r.B)
+	fmt.Printf("Only on A: %d\n", len(r.OnlyA))
+	for _, id := range r.OnlyA {
+		fmt.Println("   ", id)
+	}
+	fmt.Printf("Only on B: %d\n", len(r.OnlyB))
+	for _, id := range r.OnlyB {
+		fmt.Println("   ", id)
+	}
+	fmt.Printf("Different on A and B: %d of %d shared\n", len(r.Changed), r.Shared)
+	for _, pd := range r.Changed {
+		fmt.Println("   ", pd.ID)
+		for _, fd := range pd.Fields {
+			fmt.Printf("        %s:\n", fd.Field)
+			fmt.Println("            A:", noneIfEmpty(fd.A))
+			fmt.Println("            B:", noneIfEmpty(fd.B))
+		}
+		if pd.Distance != "" {
+			fmt.Println("        Distance:", pd.Distance)
+		}
+	}
+}
+
+func cidString(c cid.Cid) string {
+	if !c.Defined() {
+		return ""
+	}
+	return c.String()
+}
+
+func publisherID(pinfo *model.ProviderInfo) string {
+	if pinfo.Publisher == nil {
+		return ""
+	}
+	return pinfo.Publisher.ID.String()
+}
+
+func publisherAddrs(pinfo *model.ProviderInfo) string {
+	if pinfo.Publisher == nil {
+		return ""
+	}
+	return sortedAddrs(addrStrings(pinfo.Publisher.Addrs))
+}
+
+func sortedAddrs(addrs []string) string {
+	slices.Sort(addrs)
+	return strings.Join(addrs, " ")
+}
+
+func frozenState(pinfo *model.ProviderInfo) string {
+	if pinfo.FrozenAtTime == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s at %s", cidString(pinfo.FrozenAt), pinfo.FrozenAtTime)
+}
+
+func noneIfEmpty(s string) string {
+	if s == "" {
+		return "none"
+	}
+	return s
+}
@@ -0,0 +1,157 @@
+package provider
+
+import (
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipni/go-libipni/find/model"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffFields(t *testing.T) {
+	newInfo := func() *model.ProviderInfo {
+		pub := addrInfo(t, pidB, "/ip4/1.2.3.4/tcp/2", "/ip4/1.2.3.4/udp/3/quic-v1")
+		return &model.ProviderInfo{
+			AddrInfo:          addrInfo(t, pidA, "/ip4/1.2.3.4/tcp/1", "/dns/a.example/tcp/443/https"),
+			LastAdvertisement: adCid(t, adCid1),
+			Publisher:         &pub,
+		}
+	}
+
+	tests := []struct {
+		name   string
+		change func(*model.ProviderInfo)
+		want   []fieldDiff
+	}{
+		{
+			name:   "same",
+			change: func(*model.ProviderInfo) {},
+		},
+		{
+			name: "last ad",
+			change: func(p *model.ProviderInfo) {
+				p.LastAdvertisement = adCid(t, adCid2)
+			},
+			want: []fieldDiff{{Field: "last_ad", A: adCid1, B: adCid2}},
+		},
+		{
+			name: "no last ad",
+			change: func(p *model.ProviderInfo) {
+				p.LastAdvertisement = cid.Undef
+			},
+			want: []fieldDiff{{Field: "last_ad", A: adCid1, B: ""}},
+		},
+		{
+			name: "publisher",
+			change: func(p *model.ProviderInfo) {
+				p.Publisher.ID = decodePeerID(t, pidC)
+			},
+			want: []fieldDiff{{Field: "publisher", A: pidB, B: pidC}},
+		},
+		{
+			name: "publisher addrs",
+			change: func(p *model.ProviderInfo) {
+				p.Publisher.Addrs = p.Publisher.Addrs[:1]
+			},
+			want: []fieldDiff{{
+				Field: "publisher_addrs",
+				A:     "/ip4/1.2.3.4/tcp/2 /ip4/1.2.3.4/udp/3/quic-v1",
+				B:     "/ip4/1.2.3.4/tcp/2",
+			}},
+		},
+		{
+			name: "no publisher",
+			change: func(p *model.ProviderInfo) {
+				p.Publisher = nil
+			},
+			want: []fieldDiff{
+				{Field: "publisher", A: pidB, B: ""},
+				{Field: "publisher_addrs", A: "/ip4/1.2.3.4/tcp/2 /ip4/1.2.3.4/udp/3/quic-v1", B: ""},
+			},
+		},
+		{
+			name: "addrs in different order",
+			change: func(p *model.ProviderInfo) {
+				p.AddrInfo.Addrs[0], p.AddrInfo.Addrs[1] = p.AddrInfo.Addrs[1], p.AddrInfo.Addrs[0]
+			},
+		},
+		{
+			name: "addrs",
+			change: func(p *model.ProviderInfo) {
+				p.AddrInfo.Addrs = p.AddrInfo.Addrs[1:]
+			},
+			want: []fieldDiff{{
+				Field: "addrs",
+				A:     "/dns/a.example/tcp/443/https /ip4/1.2.3.4/tcp/1",
+				B:     "/dns/a.example/tcp/443/https",
+			}},
+		},
+		{
+			name: "frozen",
+			change: func(p *model.ProviderInfo) {
+				p.FrozenAt = adCid(t, adCid2)
+				p.FrozenAtTime = "2026-02-01T10:00:00Z"
+			},
+			want: []fieldDiff{{Field: "frozen", A: "", B: adCid2 + " at 2026-02-01T10:00:00Z"}},
+		},
+		{
+			name: "last error",
+			change: func(p *model.ProviderInfo) {
+				p.LastError = "failed to dial"
+			},
+			want: []fieldDiff{{Field: "last_error", A: "", B: "failed to dial"}},
+		},
+		{
+			name: "several",
+			change: func(p *model.ProviderInfo) {
+				p.LastAdvertisement = adCid(t, adCid2)
+				p.LastError = "failed to dial"
+			},
+			want: []fieldDiff{
+				{Field: "last_ad", A: adCid1, B: adCid2},
+				{Field: "last_error", A: "", B: "failed to dial"},
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			b := newInfo()
+			tc.change(b)
+			require.Equal(t, tc.want, diffFields(newInfo(), b))
+		})
+	}
+}
+
+func TestDiffProviders(t *testing.T) {
+	provA := &model.ProviderInfo{
+		AddrInfo:          addrInfo(t, pidA),
+		LastAdvertisement: adCid(t, adCid1),
+	}
+	provB := &model.ProviderInfo{AddrInfo: addrInfo(t, pidB)}
+	provC := &model.ProviderInfo{AddrInfo: addrInfo(t, pidC)}
+	changedA := &model.ProviderInfo{
+		AddrInfo:          addrInfo(t, pidA),
+		LastAdvertisement: adCid(t, adCid2),
+	}
+
+	report := diffProviders(t.Context(),
+		[]*model.ProviderInfo{provC, provA, provB},
+		[]*model.ProviderInfo{changedA, provB}, nil)
+	require.Equal(t, []peer.ID{decodePeerID(t, pidC)}, report.OnlyA)
+	require.Empty(t, report.OnlyB)
+	require.Equal(t, 2, report.Shared)
+	require.Len(t, report.Changed, 1)
+	require.Equal(t, decodePeerID(t, pidA), report.Changed[0].ID)
+	require.Equal(t, []fieldDiff{{Field: "last_ad", A: adCid1, B: adCid2}}, report.Changed[0].Fields)
+	require.Empty(t, report.Changed[0].Distance)
+
+	report = diffProviders(t.Context(),
+		[]*model.ProviderInfo{provB},
+		[]*model.ProviderInfo{provC, provA, provB}, nil)
+	require.Empty(t, report.OnlyA)
+	// Providers only on B are sorted.
+	require.Equal(t, []peer.ID{decodePeerID(t, pidA), decodePeerID(t, pidC)}, report.OnlyB)
+	require.Equal(t, 1, report.Shared)
+	require.Empty(t, report.Changed)
+}
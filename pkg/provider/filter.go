@@ -0,0 +1,212 @@
+package provider
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ipni/go-libipni/find/model"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/urfave/cli/v3"
+)
+
+const (
+	addrProtoHTTP = "http"
+	addrProtoQUIC = "quic"
+	addrProtoTCP  = "tcp"
+)
+
+var filterFlags = []cli.Flag{
+	&cli.BoolFlag{
+		Name:  "inactive",
+		Usage: "Only show providers that are inactive",
+	},
+	&cli.BoolFlag{
+		Name:  "frozen",
+		Usage: "Only show providers that are frozen",
+	},
+	&cli.DurationFlag{
+		Name:  "last-ad-older-than",
+		Usage: "Only show providers whose last advertisement was received longer ago than this, such as 48h, or that have no last advertisement",
+	},
+	&cli.BoolFlag{
+		Name:  "no-publisher",
+		Usage: "Only show providers that have no publisher",
+	},
+	&cli.BoolFlag{
+		Name:  "has-extended-providers",
+		Usage: "Only show providers that have extended providers",
+	},
+	&cli.StringSliceFlag{
+		Name:  "addr-proto",
+		Usage: "Only show providers whose publisher addresses all use one of these protocols: http, tcp, quic. Multiple OK",
+	},
+	&cli.IntFlag{
+		Name:  "lag-above",
+		Usage: "Only show providers with a sync-in-progress lag greater than this",
+	},
+	&cli.StringFlag{
+		Name:  "error-contains",
+		Usage: "Only show providers with a LastError that contains this text, ignoring case",
+	},
+	&cli.StringFlag{
+		Name:  "error-regex",
+		Usage: "Only show providers with a LastError that matches this regular expression",
+	},
+}
+
+// providerFilter selects providers using the information from the indexer.
+type providerFilter struct {
+	exclude       map[peer.ID]struct{}
+	errFilter     bool
+	onlyWithError bool
+	diffPub       bool
+
+	inactive        bool
+	frozen          bool
+	noPublisher     bool
+	hasExtProviders bool
+	lastAdOlderThan time.Duration
+	addrProtos      []string
+	lagAbove        int
+	lagSet          bool
+	errorContains   string
+	errorRegex      *regexp.Regexp
+
+	now time.Time
+}
+
+// newProviderFilter creates a providerFilter from the command flags. Providers
+// in exclude are not selected.
+func newProviderFilter(cmd *cli.Command, exclude map[peer.ID]struct{}) (*providerFilter, error) {
+	f := &providerFilter{
+		exclude:         exclude,
+		diffPub:         cmd.Bool("diff-pub"),
+		inactive:        cmd.Bool("inactive"),
+		frozen:          cmd.Bool("frozen"),
+		noPublisher:     cmd.Bool("no-publisher"),
+		hasExtProviders: cmd.Bool("has-extended-providers"),
+		lastAdOlderThan: cmd.Duration("last-ad-older-than"),
+		lagAbove:        cmd.Int("lag-above"),
+		lagSet:          cmd.IsSet("lag-above"),
+		errorContains:   strings.ToLower(cmd.String("error-contains")),
+		now:             time.Now(),
+	}
+	if cmd.Bool("error") {
+		f.errFilter = true
+		f.onlyWithError = !cmd.Bool("invert")
+	}
+
+	for _, proto := range cmd.StringSlice("addr-proto") {
+		switch proto {
+		case addrProtoHTTP, addrProtoQUIC, addrProtoTCP:
+			f.addrProtos = append(f.addrProtos, proto)
+		default:
+			return nil, fmt.Errorf("unknown address protocol %q: must be http, tcp, or quic", proto)
+		}
+	}
+
+	if expr := cmd.String("error-regex"); expr != "" {
+		var err error
+		f.errorRegex, err = regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("bad --error-regex: %w", err)
+		}
+	}
+	if f.noPublisher && (f.diffPub || f.addrProtos != nil) {
+		return nil, errors.New("--no-publisher cannot be used with --diff-pub or --addr-proto")
+	}
+	return f, nil
+}
+
+// match returns true if the provider passes all filters.
+func (f *providerFilter) match(pinfo *model.ProviderInfo) bool {
+	if _, ok := f.exclude[pinfo.AddrInfo.ID]; ok {
+		return false
+	}
+	if f.errFilter && (f.onlyWithError == (pinfo.LastError == "")) {
+		return false
+	}
+	if f.diffPub && (pinfo.Publisher == nil || pinfo.AddrInfo.ID == pinfo.Publisher.ID) {
+		return false
+	}
+	if f.inactive && !pinfo.Inactive {
+		return false
+	}
+	if f.frozen && pinfo.FrozenAtTime == "" {
+		return false
+	}
+	if f.noPublisher && pinfo.Publisher != nil {
+		return false
+	}
+	if f.hasExtProviders && !hasExtendedProviders(pinfo) {
+		return false
+	}
+	if f.lastAdOlderThan != 0 && !f.lastAdOlder(pinfo) {
+		return false
+	}
+	if f.lagSet && pinfo.Lag <= f.lagAbove {
+		return false
+	}
+	if f.addrProtos != nil && !f.publisherAddrsMatch(pinfo) {
+		return false
+	}
+	if f.errorContains != "" && !strings.Contains(strings.ToLower(pinfo.LastError), f.errorContains) {
+		return false
+	}
+	if f.errorRegex != nil && (pinfo.LastError == "" || !f.errorRegex.MatchString(pinfo.LastError)) {
+		return false
+	}
+	return true
+}
+
+// lastAdOlder returns true if the provider's last advertisement was received
+// before the --last-ad-older-than duration, or if there is no last
+// advertisement time.
+func (f *providerFilter) lastAdOlder(pinfo *model.ProviderInfo) bool {
+	if pinfo.LastAdvertisementTime == "" {
+		return true
+	}
+	adTime, err := time.Parse(time.RFC3339, pinfo.LastAdvertisementTime)
+	if err != nil {
+		return true
+	}
+	return f.now.Sub(adTime) > f.lastAdOlderThan
+}
+
+// publisherAddrsMatch returns true if the provider has a publisher with
+// addresses that all use one of the selected protocols.
+func (f *providerFilter) publisherAddrsMatch(pinfo *model.ProviderInfo) bool {
+	if pinfo.Publisher == nil || len(pinfo.Publisher.Addrs) == 0 {
+		return false
+	}
+	for _, addr := range pinfo.Publisher.Addrs {
+		if !slices.Contains(f.addrProtos, addrProto(addr)) {
+			return false
+		}
+	}
+	return true
+}
+
+// addrProto returns the transport protocol of an address, as http, quic, or
+// tcp. An empty string is returned for any other address.
+func addrProto(addr multiaddr.Multiaddr) string {
+	var proto string
+	for _, c := range addr {
+		switch c.Code() {
+		case multiaddr.P_HTTP, multiaddr.P_HTTPS:
+			return addrProtoHTTP
+		case multiaddr.P_QUIC, multiaddr.P_QUIC_V1:
+			proto = addrProtoQUIC
+		case multiaddr.P_TCP:
+			if proto == "" {
+				proto = addrProtoTCP
+			}
+		}
+	}
+	return proto
+}
@@ -0,0 +1,99 @@
+package provider
+
+import (
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v3"
+)
+
+// parseProviderFilter creates the providerFilter given by the filter flags in
+// args. Providers in exclude are not selected.
+func parseProviderFilter(t *testing.T, exclude map[peer.ID]struct{}, args ...string) (*providerFilter, error) {
+	flags := []cli.Flag{
+		&cli.BoolFlag{Name: "error"},
+		&cli.BoolFlag{Name: "invert"},
+		&cli.BoolFlag{Name: "diff-pub"},
+		&cli.BoolFlag{Name: "inactive"},
+		&cli.BoolFlag{Name: "frozen"},
+		&cli.DurationFlag{Name: "last-ad-older-than"},
+		&cli.BoolFlag{Name: "no-publisher"},
+		&cli.BoolFlag{Name: "has-extended-providers"},
+		&cli.StringSliceFlag{Name: "addr-proto"},
+		&cli.IntFlag{Name: "lag-above"},
+		&cli.StringFlag{Name: "error-contains"},
+		&cli.StringFlag{Name: "error-regex"},
+	}
+	var filter *providerFilter
+	err := runCommand(t, flags, args, func(cmd *cli.Command) error {
+		var err error
+		filter, err = newProviderFilter(cmd, exclude)
+		return err
+	})
+	return filter, err
+}
+
+func TestProviderFilter(t *testing.T) {
+	provs := testProviders(t)
+	tests := []struct {
+		name    string
+		args    []string
+		exclude []string
+		want    []string
+	}{
+		{name: "none", want: []string{pidA, pidB, pidC}},
+		{name: "inactive", args: []string{"--inactive"}, want: []string{pidB}},
+		{name: "frozen", args: []string{"--frozen"}, want: []string{pidB}},
+		{name: "no publisher", args: []string{"--no-publisher"}, want: []string{pidC}},
+		{name: "diff publisher", args: []string{"--diff-pub"}, want: []string{pidB}},
+		{name: "extended providers", args: []string{"--has-extended-providers"}, want: []string{pidC}},
+		{name: "last ad older than", args: []string{"--last-ad-older-than", "24h"}, want: []string{pidB, pidC}},
+		{name: "last ad older than all", args: []string{"--last-ad-older-than", "30m"}, want: []string{pidA, pidB, pidC}},
+		{name: "lag above", args: []string{"--lag-above", "5"}, want: []string{pidB}},
+		{name: "lag above zero", args: []string{"--lag-above", "0"}, want: []string{pidB}},
+		{name: "lag above max", args: []string{"--lag-above", "10"}, want: nil},
+		{name: "addr proto http", args: []string{"--addr-proto", "http"}, want: []string{pidA}},
+		{name: "addr proto tcp", args: []string{"--addr-proto", "tcp"}, want: nil},
+		{name: "addr proto tcp and quic", args: []string{"--addr-proto", "tcp,quic"}, want: []string{pidB}},
+		{name: "error contains ignores case", args: []string{"--error-contains", "DIAL"}, want: []string{pidB}},
+		{name: "error regex", args: []string{"--error-regex", "^Rate"}, want: []string{pidC}},
+		{name: "error regex case sensitive", args: []string{"--error-regex", "^rate"}, want: nil},
+		{name: "error", args: []string{"--error"}, want: []string{pidB, pidC}},
+		{name: "error inverted", args: []string{"--error", "--invert"}, want: []string{pidA}},
+		{name: "combined", args: []string{"--frozen", "--error-contains", "dial", "--lag-above", "1"}, want: []string{pidB}},
+		{name: "combined no match", args: []string{"--frozen", "--has-extended-providers"}, want: nil},
+		{name: "exclude", args: []string{"--error"}, exclude: []string{pidB}, want: []string{pidC}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var exclude map[peer.ID]struct{}
+			if tc.exclude != nil {
+				exclude = make(map[peer.ID]struct{})
+				for _, pid := range tc.exclude {
+					exclude[decodePeerID(t, pid)] = struct{}{}
+				}
+			}
+			filter, err := parseProviderFilter(t, exclude, tc.args...)
+			require.NoError(t, err)
+			var got []string
+			for _, pinfo := range provs {
+				if filter.match(pinfo) {
+					got = append(got, pinfo.AddrInfo.ID.String())
+				}
+			}
+			require.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestProviderFilterErrors(t *testing.T) {
+	_, err := parseProviderFilter(t, nil, "--addr-proto", "ftp")
+	require.ErrorContains(t, err, "unknown address protocol")
+
+	_, err = parseProviderFilter(t, nil, "--error-regex", "(")
+	require.ErrorContains(t, err, "bad --error-regex")
+
+	_, err = parseProviderFilter(t, nil, "--no-publisher", "--addr-proto", "http")
+	require.ErrorContains(t, err, "--no-publisher cannot be used")
+}
@@ -0,0 +1,408 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ipni/go-libipni/find/model"
+	"github.com/ipni/ipni-cli/pkg/extprov"
+	"github.com/ipni/ipni-cli/pkg/spaddr/spinfo"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/urfave/cli/v3"
+)
+
+const (
+	outputText   = "text"
+	outputJSON   = "json"
+	outputNDJSON = "ndjson"
+	outputCSV    = "csv"
+	outputTable  = "table"
+)
+
+// providerField is a column of structured provider output.
+type providerField struct {
+	name string
+	// computed fields require additional lookups, so are only output when
+	// requested.
+	computed bool
+	value    func(context.Context, *providerPrinter, *model.ProviderInfo) (any, error)
+}
+
+// providerFields lists all fields in output order.
+var providerFields = []providerField{
+	{name: "id", value: func(_ context.Context, _ *providerPrinter, pinfo *model.ProviderInfo) (any, error) {
+		return pinfo.AddrInfo.ID.String(), nil
+	}},
+	{name: "addrs", value: func(_ context.Context, _ *providerPrinter, pinfo *model.ProviderInfo) (any, error) {
+		return addrStrings(pinfo.AddrInfo.Addrs), nil
+	}},
+	{name: "last_ad", value: func(_ context.Context, _ *providerPrinter, pinfo *model.ProviderInfo) (any, error) {
+		if !pinfo.LastAdvertisement.Defined() {
+			return "", nil
+		}
+		return pinfo.LastAdvertisement.String(), nil
+	}},
+	{name: "last_ad_time", value: func(_ context.Context, _ *providerPrinter, pinfo *model.ProviderInfo) (any, error) {
+		return pinfo.LastAdvertisementTime, nil
+	}},
+	{name: "lag", value: func(_ context.Context, _ *providerPrinter, pinfo *model.ProviderInfo) (any, error) {
+		return pinfo.Lag, nil
+	}},
+	{name: "publisher", value: func(_ context.Context, _ *providerPrinter, pinfo *model.ProviderInfo) (any, error) {
+		if pinfo.Publisher == nil {
+			return "", nil
+		}
+		return pinfo.Publisher.ID.String(), nil
+	}},
+	{name: "publisher_addrs", value: func(_ context.Context, _ *providerPrinter, pinfo *model.ProviderInfo) (any, error) {
+		if pinfo.Publisher == nil {
+			return []string{}, nil
+		}
+		return addrStrings(pinfo.Publisher.Addrs), nil
+	}},
+	{name: "frozen", value: func(_ context.Context, _ *providerPrinter, pinfo *model.ProviderInfo) (any, error) {
+		return pinfo.FrozenAtTime != "", nil
+	}},
+	{name: "frozen_at", value: func(_ context.Context, _ *providerPrinter, pinfo *model.ProviderInfo) (any, error) {
+		if !pinfo.FrozenAt.Defined() {
+			return "", nil
+		}
+		return pinfo.FrozenAt.String(), nil
+	}},
+	{name: "frozen_at_time", value: func(_ context.Context, _ *providerPrinter, pinfo *model.ProviderInfo) (any, error) {
+		return pinfo.FrozenAtTime, nil
+	}},
+	{name: "inactive", value: func(_ context.Context, _ *providerPrinter, pinfo *model.ProviderInfo) (any, error) {
+		return pinfo.Inactive, nil
+	}},
+	{name: "last_error", value: func(_ context.Context, _ *providerPrinter, pinfo *model.ProviderInfo) (any, error) {
+		return pinfo.LastError, nil
+	}},
+	{name: "last_error_time", value: func(_ context.Context, _ *providerPrinter, pinfo *model.ProviderInfo) (any, error) {
+		return pinfo.LastErrorTime, nil
+	}},
+	{name: "last_error_class", value: func(_ context.Context, _ *providerPrinter, pinfo *model.ProviderInfo) (any, error) {
+		if pinfo.LastError == "" {
+			return nil, nil
+		}
+		return classifyError(pinfo.LastError), nil
+	}},
+	{name: "extended_providers", value: func(_ context.Context, _ *providerPrinter, pinfo *model.ProviderInfo) (any, error) {
+		sets := extprov.Sets(pinfo.ExtendedProviders)
+		if sets == nil {
+			return []extprov.Set{}, nil
+		}
+		return sets, nil
+	}},
+	{name: "distance", computed: true, value: func(ctx context.Context, p *providerPrinter, pinfo *model.ProviderInfo) (any, error) {
+		p2pHost, err := p.host()
+		if err != nil {
+			return nil, err
+		}
+		dist, err := getLastSeenDistance(ctx, p.cmd, pinfo, p2pHost)
+		if err != nil {
+			return nil, err
+		}
+		if dist.Exceeded {
+			return fmt.Sprintf(">=%d", dist.Count), nil
+		}
+		return dist.Count, nil
+	}},
+	{name: "protocol", computed: true, value: func(ctx context.Context, p *providerPrinter, pinfo *model.ProviderInfo) (any, error) {
+		if pinfo.Publisher == nil {
+			return nil, errors.New("no publisher")
+		}
+		p2pHost, err := p.host()
+		if err != nil {
+			return nil, err
+		}
+		return getProtocol(ctx, *pinfo.Publisher, p2pHost)
+	}},
+	{name: "spid", computed: true, value: func(ctx context.Context, p *providerPrinter, pinfo *model.ProviderInfo) (any, error) {
+		return p.getSPID(ctx, pinfo.AddrInfo.ID)
+	}},
+}
+
+// defaultTableFields are the fields shown by table output when no fields are
+// specified. Other structured formats show all fields that are not computed.
+var defaultTableFields = []string{"id", "last_ad_time", "lag", "publisher", "frozen", "inactive", "last_error"}
+
+// providerRecord is a provider's selected field values, in field order.
+type providerRecord struct {
+	names  []string
+	values []any
+	errs   map[string]string
+}
+
+// MarshalJSON writes the fields in order, followed by any errors from
+// computing field values.
+func (r providerRecord) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, name := range r.names {
+		if i != 0 {
+			buf.WriteByte(',')
+		}
+		k, _ := json.Marshal(name)
+		v, err := json.Marshal(r.values[i])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+	if len(r.errs) != 0 {
+		errs, err := json.Marshal(r.errs)
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteString(`,"errors":`)
+		buf.Write(errs)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// cells returns the field values as strings, for csv and table output.
+func (r providerRecord) cells() []string {
+	cells := make([]string, len(r.values))
+	for i, v := range r.values {
+		if errStr, ok := r.errs[r.names[i]]; ok {
+			cells[i] = "error: " + errStr
+			continue
+		}
+		switch val := v.(type) {
+		case nil:
+		case string:
+			cells[i] = val
+		case []string:
+			cells[i] = strings.Join(val, " ")
+		case int:
+			cells[i] = strconv.Itoa(val)
+		case bool:
+			cells[i] = strconv.FormatBool(val)
+		case errorClass:
+			cells[i] = val.Category
+		case []extprov.Set:
+			cells[i] = strings.Join(extprov.IDs(val), " ")
+		default:
+			cells[i] = fmt.Sprint(val)
+		}
+	}
+	return cells
+}
+
+// providerPrinter prints provider information in the selected output format.
+type providerPrinter struct {
+	cmd     *cli.Command
+	format  string
+	fields  []providerField
+	p2pHost host.Host
+	records []providerRecord
+	jsonEnc *json.Encoder
+	csvw    *csv.Writer
+	tabw    *tabwriter.Writer
+
+	spidResolver spinfo.Resolver
+	spids        map[peer.ID][]string
+	spidErr      error
+}
+
+func newProviderPrinter(cmd *cli.Command) (*providerPrinter, error) {
+	p := &providerPrinter{
+		cmd:    cmd,
+		format: cmd.String("output"),
+	}
+	switch p.format {
+	case outputText:
+		if len(cmd.StringSlice("fields")) != 0 {
+			return nil, errors.New("--fields can only be used with json, ndjson, csv, or table output")
+		}
+		if err := p.initSPIDResolver(cmd.Bool("spid")); err != nil {
+			return nil, err
+		}
+		return p, nil
+	case outputJSON, outputNDJSON, outputCSV, outputTable:
+	default:
+		return nil, fmt.Errorf("unsupported output format %q: must be one of text, json, ndjson, csv, table", p.format)
+	}
+	if cmd.Bool("id-only") || cmd.Bool("publisher") {
+		return nil, errors.New("--id-only and --publisher can only be used with text output")
+	}
+
+	var err error
+	p.fields, err = selectFields(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, len(p.fields))
+	for i := range p.fields {
+		names[i] = p.fields[i].name
+	}
+	if err = p.initSPIDResolver(slices.Contains(names, "spid")); err != nil {
+		return nil, err
+	}
+	switch p.format {
+	case outputNDJSON:
+		p.jsonEnc = json.NewEncoder(os.Stdout)
+	case outputCSV:
+		p.csvw = csv.NewWriter(os.Stdout)
+		p.csvw.Write(names)
+	case outputTable:
+		p.tabw = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(p.tabw, strings.ToUpper(strings.Join(names, "\t")))
+	}
+	return p, nil
+}
+
+// selectFields returns the fields named by --fields. If none are named, the
+// default fields are returned, along with any computed fields requested by
+// --distance, --protocol, or --spid.
+func selectFields(cmd *cli.Command) ([]providerField, error) {
+	names := cmd.StringSlice("fields")
+	if len(names) == 0 {
+		if cmd.String("output") == outputTable {
+			names = slices.Clone(defaultTableFields)
+		} else {
+			for _, field := range providerFields {
+				if !field.computed {
+					names = append(names, field.name)
+				}
+			}
+		}
+		for _, name := range []string{"distance", "protocol", "spid"} {
+			if cmd.Bool(name) {
+				names = append(names, name)
+			}
+		}
+	}
+
+	fields := make([]providerField, 0, len(names))
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		i := slices.IndexFunc(providerFields, func(f providerField) bool { return f.name == name })
+		if i == -1 {
+			return nil, fmt.Errorf("unknown field %q, must be one of: %s", name, fieldNames())
+		}
+		fields = append(fields, providerFields[i])
+	}
+	return fields, nil
+}
+
+func fieldNames() string {
+	names := make([]string, len(providerFields))
+	for i := range providerFields {
+		names[i] = providerFields[i].name
+	}
+	return strings.Join(names, ", ")
+}
+
+// initSPIDResolver creates the storage provider ID resolver if storage
+// provider IDs are output.
+func (p *providerPrinter) initSPIDResolver(needed bool) error {
+	if !needed {
+		return nil
+	}
+	var err error
+	p.spidResolver, err = newSPIDResolver(p.cmd)
+	if err != nil {
+		return err
+	}
+	p.spids = make(map[peer.ID][]string)
+	return nil
+}
+
+// host returns the libp2p host shared by all lookups that need one, creating
+// it when first needed.
+func (p *providerPrinter) host() (host.Host, error) {
+	if p.p2pHost == nil {
+		var err error
+		p.p2pHost, err = libp2p.New()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return p.p2pHost, nil
+}
+
+func (p *providerPrinter) print(ctx context.Context, pinfo *model.ProviderInfo) {
+	if p.format == outputText {
+		showProviderInfo(ctx, p, pinfo)
+		return
+	}
+
+	rec := providerRecord{
+		names:  make([]string, len(p.fields)),
+		values: make([]any, len(p.fields)),
+	}
+	for i, field := range p.fields {
+		rec.names[i] = field.name
+		v, err := field.value(ctx, p, pinfo)
+		if err != nil {
+			if rec.errs == nil {
+				rec.errs = make(map[string]string)
+			}
+			rec.errs[field.name] = err.Error()
+			v = nil
+		}
+		rec.values[i] = v
+	}
+
+	switch p.format {
+	case outputJSON:
+		p.records = append(p.records, rec)
+	case outputNDJSON:
+		p.jsonEnc.Encode(rec)
+	case outputCSV:
+		p.csvw.Write(rec.cells())
+	case outputTable:
+		fmt.Fprintln(p.tabw, strings.Join(rec.cells(), "\t"))
+	}
+}
+
+// finish writes any buffered output, and closes the libp2p host if one was
+// created.
+func (p *providerPrinter) finish() error {
+	if p.p2pHost != nil {
+		p.p2pHost.Close()
+	}
+	switch p.format {
+	case outputJSON:
+		if p.records == nil {
+			p.records = []providerRecord{}
+		}
+		data, err := json.MarshalIndent(p.records, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+	case outputCSV:
+		p.csvw.Flush()
+		return p.csvw.Error()
+	case outputTable:
+		return p.tabw.Flush()
+	}
+	return nil
+}
+
+func addrStrings(addrs []multiaddr.Multiaddr) []string {
+	strs := make([]string, len(addrs))
+	for i, a := range addrs {
+		strs[i] = a.String()
+	}
+	return strs
+}
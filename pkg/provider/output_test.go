@@ -0,0 +1,288 @@
+package provider
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipni/go-libipni/find/model"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v3"
+)
+
+const (
+	pidA = "12D3KooWE8yt84RVwW3sFcd6WMjbUdWrZer2YtT4dmtj3dHdahSZ"
+	pidB = "12D3KooWLjeDyvuv7rbfG2wWNvWn7ybmmU88PirmSckuqCgXBAph"
+	pidC = "12D3KooWPNbkEgjdBNeaCGpsgCrPRETe4uBZf1ShFXStobdN18ys"
+
+	adCid1 = "baguqeeraqsvqabzwqh3ijpk3mwmqaufqh5bgmbvemzmtu6hvdzljfxrkxh2q"
+	adCid2 = "baguqeeraexhlr7iaktlpny35r6h4pjjbiflcokjgpffrfex7ckttdbazvapa"
+)
+
+func decodePeerID(t *testing.T, pid string) peer.ID {
+	id, err := peer.Decode(pid)
+	require.NoError(t, err)
+	return id
+}
+
+func addrInfo(t *testing.T, pid string, addrs ...string) peer.AddrInfo {
+	ai := peer.AddrInfo{
+		ID:    decodePeerID(t, pid),
+		Addrs: make([]multiaddr.Multiaddr, len(addrs)),
+	}
+	for i, addr := range addrs {
+		ai.Addrs[i] = multiaddr.StringCast(addr)
+	}
+	return ai
+}
+
+func adCid(t *testing.T, s string) cid.Cid {
+	c, err := cid.Decode(s)
+	require.NoError(t, err)
+	return c
+}
+
+// testProviders returns providers with different states:
+//
+//	A is active with an HTTP publisher and an advertisement an hour old.
+//	B is frozen and inactive, with a different TCP publisher, a lag, a dial
+//	  error, and no advertisement time.
+//	C has no publisher, has extended providers, and a rate limit error.
+func testProviders(t *testing.T) []*model.ProviderInfo {
+	pubA := addrInfo(t, pidA, "/dns/a.example/tcp/443/https")
+	pubB := addrInfo(t, pidC, "/ip4/1.2.3.4/tcp/2", "/ip4/1.2.3.4/udp/3/quic-v1")
+	return []*model.ProviderInfo{
+		{
+			AddrInfo:              addrInfo(t, pidA, "/ip4/1.2.3.4/tcp/1"),
+			LastAdvertisement:     adCid(t, adCid1),
+			LastAdvertisementTime: time.Now().Add(-time.Hour).Format(time.RFC3339),
+			Publisher:             &pubA,
+		},
+		{
+			AddrInfo:          addrInfo(t, pidB, "/ip4/5.6.7.8/tcp/1"),
+			LastAdvertisement: adCid(t, adCid2),
+			Publisher:         &pubB,
+			FrozenAtTime:      "2026-02-01T10:00:00Z",
+			Inactive:          true,
+			Lag:               10,
+			LastError:         "cannot sync with publisher: failed to dial: connection refused",
+		},
+		{
+			AddrInfo:              addrInfo(t, pidC),
+			LastAdvertisementTime: time.Now().Add(-72 * time.Hour).Format(time.RFC3339),
+			LastError:             "Rate limit exceeded",
+			ExtendedProviders: &model.ExtendedProviders{
+				Providers: []peer.AddrInfo{addrInfo(t, pidA, "/dns/ep.example/tcp/443/https")},
+			},
+		},
+	}
+}
+
+// runCommand runs a command with the flags and args, and calls action with
+// the command. Flags must be created for each run, since cli flags keep their
+// values after a command runs.
+func runCommand(t *testing.T, flags []cli.Flag, args []string, action func(*cli.Command) error) error {
+	cmd := &cli.Command{
+		Name:  "provider",
+		Flags: flags,
+		Action: func(_ context.Context, cmd *cli.Command) error {
+			return action(cmd)
+		},
+	}
+	return cmd.Run(t.Context(), append([]string{"provider"}, args...))
+}
+
+// outputFlags returns new flags used to select the output format and fields.
+func outputFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{Name: "output", Value: outputText},
+		&cli.StringSliceFlag{Name: "fields"},
+		&cli.BoolFlag{Name: "id-only"},
+		&cli.BoolFlag{Name: "publisher"},
+		&cli.BoolFlag{Name: "distance"},
+		&cli.BoolFlag{Name: "protocol"},
+		&cli.BoolFlag{Name: "spid"},
+	}
+}
+
+// captureStdout returns everything written to stdout while running f.
+func captureStdout(t *testing.T, f func()) string {
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	out := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		out <- string(data)
+	}()
+	f()
+	w.Close()
+	return <-out
+}
+
+// printProviders prints the providers using the output flags in args.
+func printProviders(t *testing.T, provs []*model.ProviderInfo, args ...string) string {
+	return captureStdout(t, func() {
+		err := runCommand(t, outputFlags(), args, func(cmd *cli.Command) error {
+			p, err := newProviderPrinter(cmd)
+			if err != nil {
+				return err
+			}
+			for _, pinfo := range provs {
+				p.print(t.Context(), pinfo)
+			}
+			return p.finish()
+		})
+		require.NoError(t, err)
+	})
+}
+
+func TestSelectFields(t *testing.T) {
+	var defaultFields []string
+	for _, field := range providerFields {
+		if !field.computed {
+			defaultFields = append(defaultFields, field.name)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		args    []string
+		want    []string
+		wantErr string
+	}{
+		{name: "json default", args: []string{"--output", "json"}, want: defaultFields},
+		{name: "table default", args: []string{"--output", "table"}, want: defaultTableFields},
+		{name: "computed flags", args: []string{"--output", "table", "--distance", "--spid"}, want: append(defaultTableFields[:len(defaultTableFields):len(defaultTableFields)], "distance", "spid")},
+		{name: "fields", args: []string{"--output", "csv", "--fields", "lag, id,protocol"}, want: []string{"lag", "id", "protocol"}},
+		// Computed flags do not add fields when fields are named.
+		{name: "fields with computed flag", args: []string{"--output", "csv", "--fields", "id", "--distance"}, want: []string{"id"}},
+		{name: "unknown field", args: []string{"--output", "json", "--fields", "id,height"}, wantErr: `unknown field "height"`},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := runCommand(t, outputFlags(), tc.args, func(cmd *cli.Command) error {
+				fields, err := selectFields(cmd)
+				if err != nil {
+					return err
+				}
+				names := make([]string, len(fields))
+				for i := range fields {
+					names[i] = fields[i].name
+				}
+				require.Equal(t, tc.want, names)
+				return nil
+			})
+			if tc.wantErr != "" {
+				require.ErrorContains(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestNewProviderPrinterErrors(t *testing.T) {
+	tests := []struct {
+		args    []string
+		wantErr string
+	}{
+		{args: []string{"--fields", "id"}, wantErr: "--fields can only be used with"},
+		{args: []string{"--output", "xml"}, wantErr: "unsupported output format"},
+		{args: []string{"--output", "json", "--id-only"}, wantErr: "--id-only and --publisher can only be used with text output"},
+		{args: []string{"--output", "csv", "--publisher"}, wantErr: "--id-only and --publisher can only be used with text output"},
+	}
+	for _, tc := range tests {
+		err := runCommand(t, outputFlags(), tc.args, func(cmd *cli.Command) error {
+			_, err := newProviderPrinter(cmd)
+			return err
+		})
+		require.ErrorContains(t, err, tc.wantErr, "args: %v", tc.args)
+	}
+}
+
+func TestPrintProvidersJSON(t *testing.T) {
+	provs := testProviders(t)
+	out := printProviders(t, provs, "--output", "json", "--fields", "id,lag,frozen,last_error_class,extended_providers")
+
+	// Fields are written in the selected order.
+	require.True(t, strings.HasPrefix(strings.TrimSpace(out), `[
+  {
+    "id": "`+pidA+`",
+    "lag": 0,
+    "frozen": false,
+    "last_error_class": null,
+    "extended_providers": []
+  },`), out)
+
+	var recs []map[string]any
+	require.NoError(t, json.Unmarshal([]byte(out), &recs))
+	require.Len(t, recs, 3)
+	require.Equal(t, pidB, recs[1]["id"])
+	require.Equal(t, float64(10), recs[1]["lag"])
+	require.Equal(t, true, recs[1]["frozen"])
+	require.Equal(t, "dial failure", recs[1]["last_error_class"].(map[string]any)["category"])
+	require.Len(t, recs[2]["extended_providers"], 1)
+
+	// Nothing printed is an empty array.
+	out = printProviders(t, nil, "--output", "json")
+	require.Equal(t, "[]\n", out)
+}
+
+func TestPrintProvidersNDJSON(t *testing.T) {
+	out := printProviders(t, testProviders(t), "--output", "ndjson", "--fields", "id,publisher")
+	require.Equal(t, strings.Join([]string{
+		`{"id":"` + pidA + `","publisher":"` + pidA + `"}`,
+		`{"id":"` + pidB + `","publisher":"` + pidC + `"}`,
+		`{"id":"` + pidC + `","publisher":""}`,
+	}, "\n")+"\n", out)
+}
+
+func TestPrintProvidersCSV(t *testing.T) {
+	out := printProviders(t, testProviders(t), "--output", "csv", "--fields", "id,addrs,publisher_addrs,inactive,last_error_class,extended_providers")
+	rows, err := csv.NewReader(strings.NewReader(out)).ReadAll()
+	require.NoError(t, err)
+	require.Equal(t, [][]string{
+		{"id", "addrs", "publisher_addrs", "inactive", "last_error_class", "extended_providers"},
+		{pidA, "/ip4/1.2.3.4/tcp/1", "/dns/a.example/tcp/443/https", "false", "", ""},
+		{pidB, "/ip4/5.6.7.8/tcp/1", "/ip4/1.2.3.4/tcp/2 /ip4/1.2.3.4/udp/3/quic-v1", "true", "dial failure", ""},
+		{pidC, "", "", "false", "rate limited", pidA},
+	}, rows)
+}
+
+func TestPrintProvidersTable(t *testing.T) {
+	out := printProviders(t, testProviders(t), "--output", "table", "--fields", "id,lag,last_error")
+	lines := strings.Split(strings.TrimRight(out, "\n"), "\n")
+	require.Len(t, lines, 4)
+	require.Equal(t, []string{"ID", "LAG", "LAST_ERROR"}, strings.Fields(lines[0]))
+	require.Equal(t, []string{pidA, "0"}, strings.Fields(lines[1]))
+	require.True(t, strings.HasPrefix(lines[2], pidB))
+	require.True(t, strings.HasSuffix(lines[2], "cannot sync with publisher: failed to dial: connection refused"))
+	// Columns are aligned.
+	lagCol := strings.Index(lines[0], "LAG")
+	require.Equal(t, "10", strings.Fields(lines[2][lagCol:])[0])
+	require.Equal(t, lagCol, strings.Index(lines[1], " 0 ")+1)
+}
+
+func TestProviderRecordErrors(t *testing.T) {
+	rec := providerRecord{
+		names:  []string{"id", "protocol"},
+		values: []any{pidA, nil},
+		errs:   map[string]string{"protocol": "no publisher"},
+	}
+	data, err := json.Marshal(rec)
+	require.NoError(t, err)
+	require.Equal(t, `{"id":"`+pidA+`","protocol":null,"errors":{"protocol":"no publisher"}}`, string(data))
+	require.Equal(t, []string{pidA, "error: no publisher"}, rec.cells())
+}
@@ -0,0 +1,363 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipni/go-libipni/dagsync/ipnisync"
+	"github.com/ipni/go-libipni/find/model"
+	"github.com/ipni/go-libipni/pcache"
+	"github.com/ipni/ipni-cli/pkg/dtrack"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/urfave/cli/v3"
+)
+
+var providerProbeSubCmd = &cli.Command{
+	Name:  "probe",
+	Usage: "Check that the publishers of providers are reachable and up to date",
+	Description: `Probe the publisher of each provider to get its head advertisement, and compare that to the last advertisement seen by the indexer. Publishers are probed concurrently using a single libp2p host.
+
+For each provider, the probe reports whether its publisher is reachable, the protocol used to publish advertisements, the head advertisement CID and whether it is the indexer's last advertisement, the time taken to get the head, and the distance from the last advertisement to the head. A summary follows the results.`,
+	Flags:  providerProbeFlags,
+	Action: providerProbeAction,
+}
+
+var providerProbeFlags = []cli.Flag{
+	&cli.StringSliceFlag{
+		Name:    "indexer",
+		Usage:   "Indexer URL. Specifying multiple results in a unified view of providers across all.",
+		Aliases: []string{"i"},
+		Value:   []string{"https://cid.contact"},
+	},
+	&cli.StringSliceFlag{
+		Name:  "pid",
+		Usage: "Provider's peer ID, multiple allowed",
+	},
+	&cli.BoolFlag{
+		Name:    "all",
+		Usage:   "Probe the publishers of all providers. Ignores any specified provider IDs",
+		Aliases: []string{"a"},
+	},
+	&cli.IntFlag{
+		Name:    "concurrency",
+		Usage:   "Number of publishers to probe at the same time",
+		Aliases: []string{"c"},
+		Value:   16,
+	},
+	&cli.DurationFlag{
+		Name:  "timeout",
+		Usage: "Time limit for probing each publisher",
+		Value: time.Minute,
+	},
+	&cli.BoolFlag{
+		Name:    "distance",
+		Usage:   "Find the distance from the indexer's last advertisement to the head advertisement. Use --distance=false to skip",
+		Aliases: []string{"dist"},
+		Value:   true,
+	},
+	&cli.Int64Flag{
+		Name:    "ad-depth-limit",
+		Aliases: []string{"adl"},
+		Usage:   "Limit on number of advertisements when finding distance. 0 for unlimited.",
+		Value:   5000,
+	},
+	&cli.StringFlag{
+		Name:    "output",
+		Usage:   "Output format: table or json",
+		Aliases: []string{"o"},
+		Value:   outputTable,
+	},
+}
+
+// probeResult is the result of probing a provider's publisher.
+type probeResult struct {
+	ID           peer.ID `json:"id"`
+	Publisher    string  `json:"publisher"`
+	Reachable    bool    `json:"reachable"`
+	Protocol     string  `json:"protocol,omitempty"`
+	Head         string  `json:"head,omitempty"`
+	LastAd       string  `json:"last_ad,omitempty"`
+	HeadIsLastAd bool    `json:"head_is_last_ad"`
+	LatencyMs    int64   `json:"latency_ms,omitempty"`
+	Distance     string  `json:"distance,omitempty"`
+	Error        string  `json:"error,omitempty"`
+}
+
+// probeSummary counts the results of probing all publishers.
+type probeSummary struct {
+	Probed      int            `json:"probed"`
+	Reachable   int            `json:"reachable"`
+	Unreachable int            `json:"unreachable"`
+	NoPublisher int            `json:"no_publisher"`
+	UpToDate    int            `json:"up_to_date"`
+	Behind      int            `json:"behind"`
+	Protocols   map[string]int `json:"protocols"`
+	// MedianLatencyMs is the median time to get the head advertisement from
+	// reachable publishers.
+	MedianLatencyMs int64 `json:"median_latency_ms"`
+}
+
+// publisherProber probes publishers using one shared libp2p host.
+type publisherProber struct {
+	p2pHost host.Host
+	sync    *ipnisync.Sync
+	timeout time.Duration
+}
+
+func providerProbeAction(ctx context.Context, cmd *cli.Command) error {
+	format := cmd.String("output")
+	if format != outputTable && format != outputJSON {
+		return cli.Exit(fmt.Sprintf("unsupported output format %q: must be table or json", format), 1)
+	}
+	pids := cmd.StringSlice("pid")
+	if !cmd.Bool("all") && len(pids) == 0 {
+		return cli.Exit("must specify --all or at least one --pid", 1)
+	}
+	concurrency := max(cmd.Int("concurrency"), 1)
+
+	pc, err := pcache.New(pcache.WithSourceURL(cmd.StringSlice("indexer")...), pcache.WithRefreshInterval(0))
+	if err != nil {
+		return err
+	}
+	var provs []*model.ProviderInfo
+	if cmd.Bool("all") {
+		provs = pc.List()
+	} else {
+		for _, pid := range pids {
+			peerID, err := peer.Decode(pid)
+			if err != nil {
+				return fmt.Errorf("invalid peer ID %s: %s", pid, err)
+			}
+			pinfo, err := pc.Get(ctx, peerID)
+			if err != nil {
+				return fmt.Errorf("cannot get provider %s: %w", peerID, err)
+			}
+			if pinfo == nil {
+				return fmt.Errorf("provider %s not found on indexer", peerID)
+			}
+			provs = append(provs, pinfo)
+		}
+	}
+	if len(provs) == 0 {
+		fmt.Fprintln(os.Stderr, "No providers registered with indexer")
+		return nil
+	}
+
+	p2pHost, err := libp2p.New()
+	if err != nil {
+		return err
+	}
+	defer p2pHost.Close()
+
+	prober := newPublisherProber(p2pHost, cmd.Duration("timeout"))
+	defer prober.sync.Close()
+
+	// Each worker reuses one AdDistance for all of its probes. An AdDistance
+	// walks one chain at a time, so workers cannot share one.
+	workers := min(concurrency, len(provs))
+	adDists := make([]*dtrack.AdDistance, workers)
+	if cmd.Bool("distance") {
+		for i := range adDists {
+			adDists[i], err = dtrack.NewAdDistance(
+				dtrack.WithDepthLimit(cmd.Int64("ad-depth-limit")),
+				dtrack.WithP2pHost(p2pHost))
+			if err != nil {
+				return err
+			}
+			defer adDists[i].Close()
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "Probing publishers of %d providers...\n", len(provs))
+	results := make([]probeResult, len(provs))
+	provChan := make(chan int)
+	var wg sync.WaitGroup
+	for _, adDist := range adDists {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range provChan {
+				results[i] = prober.probe(ctx, provs[i], adDist)
+			}
+		}()
+	}
+	for i := range provs {
+		provChan <- i
+	}
+	close(provChan)
+	wg.Wait()
+
+	summary := summarizeProbes(results)
+	if format == outputJSON {
+		data, err := json.MarshalIndent(struct {
+			Summary probeSummary  `json:"summary"`
+			Results []probeResult `json:"results"`
+		}{summary, results}, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+	return printProbeTable(results, summary)
+}
+
+func newPublisherProber(p2pHost host.Host, timeout time.Duration) *publisherProber {
+	return &publisherProber{
+		p2pHost: p2pHost,
+		sync: ipnisync.NewSync(cidlink.DefaultLinkSystem(), nil,
+			ipnisync.ClientStreamHost(p2pHost), ipnisync.ClientHTTPTimeout(timeout)),
+		timeout: timeout,
+	}
+}
+
+// probe checks the provider's publisher. The distance from the last
+// advertisement to the head is found using adDist, unless it is nil.
+//
+// The publisher is reachable if its head advertisement is read. Failing to
+// detect the publisher's protocol does not make it unreachable, and the error
+// is reported along with any error getting the head.
+func (p *publisherProber) probe(ctx context.Context, pinfo *model.ProviderInfo, adDist *dtrack.AdDistance) probeResult {
+	result := probeResult{
+		ID:     pinfo.AddrInfo.ID,
+		LastAd: cidString(pinfo.LastAdvertisement),
+	}
+	if pinfo.Publisher == nil {
+		result.Error = "no publisher"
+		return result
+	}
+	result.Publisher = pinfo.Publisher.ID.String()
+
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	var errs []string
+	protocol, err := getProtocol(ctx, *pinfo.Publisher, p.p2pHost)
+	if err != nil {
+		errs = append(errs, fmt.Sprintf("cannot detect protocol: %s", err))
+	}
+	result.Protocol = protocol
+
+	syncer, err := p.sync.NewSyncer(*pinfo.Publisher)
+	if err != nil {
+		errs = append(errs, fmt.Sprintf("cannot connect to publisher: %s", err))
+		result.Error = strings.Join(errs, "; ")
+		return result
+	}
+	start := time.Now()
+	head, err := syncer.GetHead(ctx)
+	if err != nil {
+		errs = append(errs, fmt.Sprintf("cannot get head advertisement: %s", err))
+		result.Error = strings.Join(errs, "; ")
+		return result
+	}
+	result.LatencyMs = time.Since(start).Milliseconds()
+	result.Reachable = true
+	result.Head = cidString(head)
+	result.HeadIsLastAd = head == pinfo.LastAdvertisement
+
+	switch {
+	case result.HeadIsLastAd:
+		result.Distance = "0"
+	case adDist == nil:
+	case head == cid.Undef:
+		result.Distance = "publisher has no advertisements"
+	case !pinfo.LastAdvertisement.Defined():
+		result.Distance = "no last advertisement"
+	default:
+		result.Distance, err = getDistance(ctx, adDist, *pinfo.Publisher, pinfo.LastAdvertisement, head)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("cannot get distance: %s", err))
+		}
+	}
+	result.Error = strings.Join(errs, "; ")
+	return result
+}
+
+func getDistance(ctx context.Context, adDist *dtrack.AdDistance, publisher peer.AddrInfo, lastAd, head cid.Cid) (string, error) {
+	dist, err := adDist.Get(ctx, publisher, lastAd, head)
+	if err != nil && !errors.Is(err, dtrack.ErrNotAncestor) {
+		return "", err
+	}
+	if dist.Exceeded {
+		return fmt.Sprintf(">=%d", dist.Count), nil
+	}
+	return dist.String(), nil
+}
+
+func summarizeProbes(results []probeResult) probeSummary {
+	summary := probeSummary{
+		Probed:    len(results),
+		Protocols: make(map[string]int),
+	}
+	var latencies []int64
+	for _, r := range results {
+		switch {
+		case r.Publisher == "":
+			summary.NoPublisher++
+		case r.Reachable:
+			summary.Reachable++
+			latencies = append(latencies, r.LatencyMs)
+			if r.HeadIsLastAd {
+				summary.UpToDate++
+			} else {
+				summary.Behind++
+			}
+		default:
+			summary.Unreachable++
+		}
+		if r.Protocol != "" {
+			summary.Protocols[r.Protocol]++
+		}
+	}
+	if len(latencies) != 0 {
+		slices.Sort(latencies)
+		summary.MedianLatencyMs = latencies[len(latencies)/2]
+	}
+	return summary
+}
+
+func printProbeTable(results []probeResult, summary probeSummary) error {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tREACHABLE\tPROTOCOL\tHEAD\tHEAD_IS_LAST_AD\tLATENCY\tDISTANCE\tERROR")
+	for _, r := range results {
+		var latency string
+		if r.Reachable {
+			latency = (time.Duration(r.LatencyMs) * time.Millisecond).String()
+		}
+		fmt.Fprintf(tw, "%s\t%t\t%s\t%s\t%t\t%s\t%s\t%s\n", r.ID, r.Reachable, r.Protocol, r.Head,
+			r.HeadIsLastAd, latency, r.Distance, r.Error)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	protocols := make([]string, 0, len(summary.Protocols))
+	for proto, count := range summary.Protocols {
+		protocols = append(protocols, fmt.Sprintf("%s=%d", proto, count))
+	}
+	slices.Sort(protocols)
+
+	fmt.Println()
+	fmt.Println("Probed:        ", summary.Probed)
+	fmt.Println("Reachable:     ", summary.Reachable)
+	fmt.Println("Unreachable:   ", summary.Unreachable)
+	fmt.Println("No publisher:  ", summary.NoPublisher)
+	fmt.Println("Up to date:    ", summary.UpToDate)
+	fmt.Println("Behind:        ", summary.Behind)
+	fmt.Println("Protocols:     ", strings.Join(protocols, " "))
+	fmt.Println("Median latency:", time.Duration(summary.MedianLatencyMs)*time.Millisecond)
+	return nil
+}
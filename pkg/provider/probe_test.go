@@ -0,0 +1,139 @@
+package provider
+
+import (
+	"crypto/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ipni/go-libipni/dagsync/ipnisync"
+	"github.com/ipni/go-libipni/dagsync/ipnisync/head"
+	"github.com/ipni/go-libipni/find/model"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"github.com/stretchr/testify/require"
+)
+
+// newHTTPPublisher starts an HTTP publisher whose head advertisement is
+// headCid, and returns its address info.
+func newHTTPPublisher(t *testing.T, headCid string) peer.AddrInfo {
+	privKey, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	pid, err := peer.IDFromPrivateKey(privKey)
+	require.NoError(t, err)
+	signed, err := head.NewSignedHead(adCid(t, headCid), "", privKey)
+	require.NoError(t, err)
+	data, err := signed.Encode()
+	require.NoError(t, err)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(ipnisync.IPNIPath+"/head", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	maddr, err := manet.FromNetAddr(srv.Listener.Addr().(*net.TCPAddr))
+	require.NoError(t, err)
+	return peer.AddrInfo{
+		ID:    pid,
+		Addrs: []multiaddr.Multiaddr{maddr.Encapsulate(multiaddr.StringCast("/http"))},
+	}
+}
+
+func newTestProber(t *testing.T) *publisherProber {
+	p2pHost, err := libp2p.New(libp2p.NoListenAddrs)
+	require.NoError(t, err)
+	t.Cleanup(func() { p2pHost.Close() })
+	prober := newPublisherProber(p2pHost, 5*time.Second)
+	t.Cleanup(func() { prober.sync.Close() })
+	return prober
+}
+
+func TestProbe(t *testing.T) {
+	prober := newTestProber(t)
+	publisher := newHTTPPublisher(t, adCid1)
+
+	t.Run("up to date", func(t *testing.T) {
+		pinfo := &model.ProviderInfo{
+			AddrInfo:          addrInfo(t, pidA),
+			LastAdvertisement: adCid(t, adCid1),
+			Publisher:         &publisher,
+		}
+		result := prober.probe(t.Context(), pinfo, nil)
+		require.Empty(t, result.Error)
+		require.True(t, result.Reachable)
+		require.Equal(t, "http", result.Protocol)
+		require.Equal(t, adCid1, result.Head)
+		require.True(t, result.HeadIsLastAd)
+		require.Equal(t, "0", result.Distance)
+	})
+
+	t.Run("behind", func(t *testing.T) {
+		// Without an AdDistance, the distance is not found.
+		pinfo := &model.ProviderInfo{
+			AddrInfo:          addrInfo(t, pidA),
+			LastAdvertisement: adCid(t, adCid2),
+			Publisher:         &publisher,
+		}
+		result := prober.probe(t.Context(), pinfo, nil)
+		require.Empty(t, result.Error)
+		require.True(t, result.Reachable)
+		require.Equal(t, adCid1, result.Head)
+		require.Equal(t, adCid2, result.LastAd)
+		require.False(t, result.HeadIsLastAd)
+		require.Empty(t, result.Distance)
+	})
+
+	t.Run("no publisher", func(t *testing.T) {
+		result := prober.probe(t.Context(), &model.ProviderInfo{AddrInfo: addrInfo(t, pidA)}, nil)
+		require.Equal(t, probeResult{ID: decodePeerID(t, pidA), Error: "no publisher"}, result)
+	})
+
+	t.Run("no publisher addrs", func(t *testing.T) {
+		// The protocol error is reported along with the error getting the
+		// head, which is what makes the publisher unreachable.
+		noAddrs := addrInfo(t, pidB)
+		pinfo := &model.ProviderInfo{
+			AddrInfo:  addrInfo(t, pidA),
+			Publisher: &noAddrs,
+		}
+		result := prober.probe(t.Context(), pinfo, nil)
+		require.False(t, result.Reachable)
+		require.Equal(t, pidB, result.Publisher)
+		require.Empty(t, result.Protocol)
+		require.Regexp(t, `^cannot detect protocol: no peer addrs .*; cannot (connect to publisher|get head advertisement): `, result.Error)
+	})
+}
+
+func TestSummarizeProbes(t *testing.T) {
+	results := []probeResult{
+		{Publisher: pidA, Reachable: true, Protocol: "http", HeadIsLastAd: true, LatencyMs: 30},
+		{Publisher: pidA, Reachable: true, Protocol: "http", LatencyMs: 10},
+		{Publisher: pidB, Reachable: true, Protocol: "libp2phttp", HeadIsLastAd: true, LatencyMs: 20},
+		{Publisher: pidB, Protocol: "data-transfer/graphsync", Error: "cannot get head advertisement: timeout"},
+		{Publisher: pidC, Error: "cannot detect protocol: no peer addrs; cannot connect to publisher: no addrs"},
+		{Error: "no publisher"},
+	}
+	require.Equal(t, probeSummary{
+		Probed:      6,
+		Reachable:   3,
+		Unreachable: 2,
+		NoPublisher: 1,
+		UpToDate:    2,
+		Behind:      1,
+		Protocols: map[string]int{
+			"http":                    2,
+			"libp2phttp":              1,
+			"data-transfer/graphsync": 1,
+		},
+		MedianLatencyMs: 20,
+	}, summarizeProbes(results))
+
+	require.Equal(t, probeSummary{Protocols: map[string]int{}}, summarizeProbes(nil))
+}
@@ -3,13 +3,12 @@ package provider
 import (
 	"bufio"
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
+	"maps"
 	"net/http"
-	"net/url"
 	"os"
+	"slices"
 	"strings"
 	"time"
 
@@ -20,6 +19,7 @@ import (
 	"github.com/ipni/go-libipni/mautil"
 	"github.com/ipni/go-libipni/pcache"
 	"github.com/ipni/ipni-cli/pkg/dtrack"
+	"github.com/ipni/ipni-cli/pkg/extprov"
 	"github.com/libp2p/go-libp2p"
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/peer"
@@ -28,8 +28,6 @@ import (
 	"github.com/urfave/cli/v3"
 )
 
-const filfoxPeerAPI = "https://filfox.info/api/v1/peer"
-
 var ProviderCmd = &cli.Command{
 	Name:  "provider",
 	Usage: "Show information about providers known to an indexer",
@@ -40,9 +38,49 @@ The --invert flag inverts the selection of providers, and shows all that are not
 Here is an example that shows using the output of one provider command to filter the output of another, to see which providers cid.contact knows about that dev.cid.contact does not:
 
     provider --all -i https://dev.cid.contact -id | provider -invert -i https://cid.contact -id
+
+The diff subcommand compares all providers of two indexers, showing those that are only on one indexer and how shared providers differ:
+
+    provider diff -a https://dev.cid.contact -b https://cid.contact
+
+The probe subcommand checks the publishers of many providers concurrently, using one libp2p host, and reports which are reachable and up to date:
+
+    provider probe --all
+
+The snapshot subcommand saves all providers to a timestamped file, and the history subcommand shows what changed between snapshots:
+
+    provider snapshot --dir snapshots
+    provider history --dir snapshots
+
+The addrs subcommand gets a provider's head advertisement from its publisher, and compares the advertisement's addresses and extended providers with the indexer's, flagging those that are stale, missing, or unreachable:
+
+    provider addrs --pid 12D3KooWE8yt84RVwW3sFcd6WMjbUdWrZer2YtT4dmtj3dHdahSZ
+
+The --output flag selects json, ndjson, csv, or table output instead of text. The --fields flag selects which fields are output, from: id, addrs, last_ad, last_ad_time, lag, publisher, publisher_addrs, frozen, frozen_at, frozen_at_time, inactive, last_error, last_error_time, last_error_class, extended_providers. The last_error_class field is the category of the LastError, with an explanation and suggested fix in json output. The computed fields distance, protocol, and spid are only looked up when requested, either by --fields or by the --distance, --protocol, and --spid flags. For example, to get a table of each provider's distance and publisher protocol:
+
+    provider --all -o table --fields id,last_ad_time,distance,protocol
+
+Providers can be filtered by --inactive, --frozen, --no-publisher, --has-extended-providers, --last-ad-older-than, --lag-above, --addr-proto, --error-contains, and --error-regex. All filters must match for a provider to be shown, and they are applied when counting providers with --count. For example, to count the providers with HTTP-only publishers that have not had an advertisement in 2 days:
+
+    provider --count --addr-proto http --last-ad-older-than 48h
+
+The --watch flag polls the indexer and outputs an NDJSON event each time a provider gets a new advertisement, gets or clears a LastError, is frozen or unfrozen, becomes inactive, or changes publisher addresses:
+
+    provider --all --watch --update-interval 1m
+
+With --watch, the filter flags select which providers events are output for. Events are output for a provider that matches the filters before or after a change, so that a change that takes a provider out of the filters, such as a cleared LastError with --error, is still output.
+
+The --summary flag outputs counts of providers that are frozen, inactive, have errors, and have extended providers, along with counts by publisher address protocol, by age of the last advertisement, and by category of LastError. Filters also apply to the summary.
 `,
-	Flags:  providerFlags,
+	Flags:  slices.Concat(providerFlags, filterFlags),
 	Action: providerAction,
+	Commands: []*cli.Command{
+		providerDiffSubCmd,
+		providerProbeSubCmd,
+		providerSnapshotSubCmd,
+		providerHistorySubCmd,
+		providerAddrsSubCmd,
+	},
 }
 
 var providerFlags = []cli.Flag{
@@ -65,6 +103,10 @@ var providerFlags = []cli.Flag{
 		Name:  "count",
 		Usage: "Count all providers and output only the count. Implies --all",
 	},
+	&cli.BoolFlag{
+		Name:  "summary",
+		Usage: "Output counts of providers by state, publisher address protocol, last advertisement age, and LastError category. Implies --all",
+	},
 	&cli.BoolFlag{
 		Name:    "distance",
 		Usage:   "Calculate distance from last seen advertisement to provider's current head advertisement",
@@ -78,6 +120,10 @@ var providerFlags = []cli.Flag{
 		Name:  "error",
 		Usage: "Only show providers that have a LastError. If --count then show count of providers with LastError.",
 	},
+	&cli.BoolFlag{
+		Name:  "watch",
+		Usage: "Poll the indexer at the --update-interval, and output an NDJSON event whenever a provider's record changes",
+	},
 	&cli.BoolFlag{
 		Name:    "follow-dist",
 		Aliases: []string{"fd"},
@@ -94,7 +140,7 @@ var providerFlags = []cli.Flag{
 	&cli.StringFlag{
 		Name:    "update-interval",
 		Aliases: []string{"uin"},
-		Usage:   "Time to wait between distance update checks when using --follow-dist. The value is an integer string ending in s, m, h for seconds. minutes, hours. Updates will only be seen as fast as they become visible at the upstream location.",
+		Usage:   "Time to wait between distance update checks when using --follow-dist, or between polls when using --watch. The value is an integer string ending in s, m, h for seconds. minutes, hours. Updates will only be seen as fast as they become visible at the upstream location.",
 		Value:   "2m",
 	},
 	&cli.StringFlag{
@@ -123,15 +169,62 @@ var providerFlags = []cli.Flag{
 		Name:  "spid",
 		Usage: "Print the provider's Filecoin storage provider ID. Optionally usable with --id-only.",
 	},
+	&cli.StringFlag{
+		Name:  "spid-resolver",
+		Usage: "How to look up storage provider IDs: filfox, with one request per provider, or lotus, which first reads the info of every storage provider from the gateway, tens of thousands of requests",
+		Value: spidResolverFilfox,
+	},
+	&cli.StringFlag{
+		Name:    "gateway",
+		Usage:   "Lotus gateway host or URL used to look up storage provider IDs with --spid-resolver lotus",
+		Aliases: []string{"g"},
+		Value:   "api.chain.love",
+	},
+	&cli.StringFlag{
+		Name:  "spid-cache",
+		Usage: "File to cache storage provider IDs in. Empty to disable caching.",
+		Value: defaultSPIDCachePath(),
+	},
+	&cli.DurationFlag{
+		Name:  "spid-cache-ttl",
+		Usage: "Time to keep cached storage provider IDs",
+		Value: 24 * time.Hour,
+	},
+	&cli.StringFlag{
+		Name:    "output",
+		Usage:   "Output format: text, json, ndjson, csv, or table",
+		Aliases: []string{"o"},
+		Value:   outputText,
+	},
+	&cli.StringSliceFlag{
+		Name:  "fields",
+		Usage: "Comma separated fields to include in structured output. Computed fields distance, protocol, and spid are looked up for each provider.",
+	},
 }
 
 func providerAction(ctx context.Context, cmd *cli.Command) error {
-	if cmd.Bool("count") {
-		return countProviders(cmd)
+	if cmd.Bool("count") || cmd.Bool("summary") {
+		filter, err := newProviderFilter(cmd, nil)
+		if err != nil {
+			return cli.Exit(err.Error(), 1)
+		}
+		if cmd.Bool("summary") {
+			return showSummary(cmd, filter)
+		}
+		return countProviders(cmd, filter)
+	}
+
+	printer, err := newProviderPrinter(cmd)
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
 	}
 
 	if cmd.Bool("all") {
-		return listProviders(ctx, cmd, nil)
+		filter, err := newProviderFilter(cmd, nil)
+		if err != nil {
+			return cli.Exit(err.Error(), 1)
+		}
+		return listProviders(ctx, cmd, filter, printer)
 	}
 
 	pids := cmd.StringSlice("pid")
@@ -163,12 +256,20 @@ func providerAction(ctx context.Context, cmd *cli.Command) error {
 		peerIDs[peerID] = struct{}{}
 	}
 
+	var filter *providerFilter
 	if cmd.Bool("invert") {
-		return listProviders(ctx, cmd, peerIDs)
+		filter, err = newProviderFilter(cmd, peerIDs)
+		if err != nil {
+			return cli.Exit(err.Error(), 1)
+		}
+		return listProviders(ctx, cmd, filter, printer)
+	}
+	filter, err = newProviderFilter(cmd, nil)
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
 	}
 
 	var pc *pcache.ProviderCache
-	var err error
 	if len(peerIDs) > 1 {
 		pc, err = pcache.New(pcache.WithRefreshInterval(0),
 			pcache.WithSourceURL(cmd.StringSlice("indexer")...))
@@ -183,15 +284,23 @@ func providerAction(ctx context.Context, cmd *cli.Command) error {
 	if cmd.Bool("follow-dist") {
 		return followDistance(ctx, cmd, peerIDs, nil, pc)
 	}
+	if cmd.Bool("watch") {
+		return watchProviders(ctx, cmd, peerIDs, filter)
+	}
+
+	printer.resolveSPIDs(ctx, slices.Collect(maps.Keys(peerIDs)))
 
 	var errCount int
 	for peerID := range peerIDs {
-		err = getProvider(ctx, cmd, pc, peerID)
+		err = getProvider(ctx, pc, peerID, filter, printer)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error getting provider %s: %s\n", peerID, err)
 			errCount++
 		}
 	}
+	if err = printer.finish(); err != nil {
+		return err
+	}
 
 	if errCount != 0 {
 		return fmt.Errorf("failed to get %d providers", errCount)
@@ -200,7 +309,7 @@ func providerAction(ctx context.Context, cmd *cli.Command) error {
 	return nil
 }
 
-func getProvider(ctx context.Context, cmd *cli.Command, pc *pcache.ProviderCache, peerID peer.ID) error {
+func getProvider(ctx context.Context, pc *pcache.ProviderCache, peerID peer.ID, filter *providerFilter, printer *providerPrinter) error {
 	prov, err := pc.Get(ctx, peerID)
 	if err != nil {
 		return err
@@ -209,83 +318,64 @@ func getProvider(ctx context.Context, cmd *cli.Command, pc *pcache.ProviderCache
 		return errors.New("provider not found on indexer")
 	}
 
-	if cmd.Bool("diff-pub") && prov.AddrInfo.ID == prov.Publisher.ID {
+	if !filter.match(prov) {
 		return nil
 	}
 
-	showProviderInfo(ctx, cmd, prov)
+	printer.print(ctx, prov)
 	return nil
 }
 
-func countProviders(cmd *cli.Command) error {
+func countProviders(cmd *cli.Command, filter *providerFilter) error {
 	pcache, err := pcache.New(pcache.WithRefreshInterval(0),
 		pcache.WithSourceURL(cmd.StringSlice("indexer")...))
 	if err != nil {
 		return err
 	}
-	provs := pcache.List()
 
-	if cmd.Bool("error") {
-		var count int
-		if cmd.Bool("invert") {
-			for _, pinfo := range provs {
-				if pinfo.LastError == "" {
-					count++
-				}
-			}
-		} else {
-			for _, pinfo := range provs {
-				if pinfo.LastError != "" {
-					count++
-				}
-			}
+	var count int
+	for _, pinfo := range pcache.List() {
+		if filter.match(pinfo) {
+			count++
 		}
-		fmt.Println(count)
-		return nil
 	}
-
-	fmt.Println(len(provs))
+	fmt.Println(count)
 	return nil
 }
 
-func listProviders(ctx context.Context, cmd *cli.Command, exclude map[peer.ID]struct{}) error {
+func listProviders(ctx context.Context, cmd *cli.Command, filter *providerFilter, printer *providerPrinter) error {
 	pc, err := pcache.New(pcache.WithSourceURL(cmd.StringSlice("indexer")...), pcache.WithRefreshInterval(0))
 	if err != nil {
 		return err
 	}
 
 	if cmd.Bool("follow-dist") {
-		return followDistance(ctx, cmd, nil, exclude, pc)
+		return followDistance(ctx, cmd, nil, filter.exclude, pc)
+	}
+	if cmd.Bool("watch") {
+		return watchProviders(ctx, cmd, nil, filter)
 	}
 
 	provs := pc.List()
-	if len(provs) == 0 {
+	if len(provs) == 0 && printer.format == outputText {
 		fmt.Println("No providers registered with indexer")
 		return nil
 	}
 
-	var errFilter, onlyWithError bool
-	if cmd.Bool("error") {
-		errFilter = true
-		onlyWithError = !cmd.Bool("invert")
+	provs = slices.DeleteFunc(provs, func(pinfo *model.ProviderInfo) bool {
+		return !filter.match(pinfo)
+	})
+	peerIDs := make([]peer.ID, len(provs))
+	for i, pinfo := range provs {
+		peerIDs[i] = pinfo.AddrInfo.ID
 	}
-
-	diffPub := cmd.Bool("diff-pub")
+	printer.resolveSPIDs(ctx, peerIDs)
 
 	for _, pinfo := range provs {
-		if _, ok := exclude[pinfo.AddrInfo.ID]; ok {
-			continue
-		}
-		if errFilter && (onlyWithError == (pinfo.LastError == "")) {
-			continue
-		}
-		if diffPub && pinfo.AddrInfo.ID == pinfo.Publisher.ID {
-			continue
-		}
-		showProviderInfo(ctx, cmd, pinfo)
+		printer.print(ctx, pinfo)
 	}
 
-	return nil
+	return printer.finish()
 }
 
 func followDistance(ctx context.Context, cmd *cli.Command, include, exclude map[peer.ID]struct{}, pc *pcache.ProviderCache) error {
@@ -305,7 +395,11 @@ func followDistance(ctx context.Context, cmd *cli.Command, include, exclude map[
 
 	fmt.Fprintln(os.Stderr, "Showing provider distance updates, ctrl-c to cancel...")
 	limit := cmd.Int64("ad-depth-limit")
-	updates, err := dtrack.RunDistanceTracker(ctx, include, exclude, pc, trackUpdateIn, timeout,
+	updates, err := dtrack.RunDistanceTracker(ctx, pc,
+		dtrack.WithInclude(slices.Collect(maps.Keys(include))...),
+		dtrack.WithExclude(slices.Collect(maps.Keys(exclude))...),
+		dtrack.WithUpdateInterval(trackUpdateIn),
+		dtrack.WithUpdateTimeout(timeout),
 		dtrack.WithDepthLimit(limit))
 	if err != nil {
 		return err
@@ -316,8 +410,8 @@ func followDistance(ctx context.Context, cmd *cli.Command, include, exclude map[
 			continue
 		}
 		var dist string
-		if update.Distance == -1 {
-			dist = fmt.Sprintf("exceeded limit %d+", limit)
+		if update.Exceeded {
+			dist = fmt.Sprintf("at least %d (limit %d reached at %s)", update.Distance, limit, update.StopCid)
 		} else {
 			dist = fmt.Sprintf("%d", update.Distance)
 		}
@@ -326,11 +420,11 @@ func followDistance(ctx context.Context, cmd *cli.Command, include, exclude map[
 	return nil
 }
 
-func showProviderInfo(ctx context.Context, cmd *cli.Command, pinfo *model.ProviderInfo) {
+func showProviderInfo(ctx context.Context, printer *providerPrinter, pinfo *model.ProviderInfo) {
+	cmd := printer.cmd
 	if cmd.Bool("id-only") {
 		if cmd.Bool("spid") {
-			fmt.Print()
-			miners, err := getSPID(ctx, pinfo.AddrInfo.ID)
+			miners, err := printer.getSPID(ctx, pinfo.AddrInfo.ID)
 			if err != nil {
 				miners = err.Error()
 			}
@@ -396,27 +490,38 @@ func showProviderInfo(ctx context.Context, cmd *cli.Command, pinfo *model.Provid
 		fmt.Println("    Inactive: true")
 	}
 
+	if sets := extprov.Sets(pinfo.ExtendedProviders); sets != nil {
+		fmt.Println("    ExtendedProviders:")
+		extprov.Write(os.Stdout, sets, "        ")
+	}
+
 	if pinfo.LastError != "" {
 		fmt.Println("    LastError:", pinfo.LastError)
 		fmt.Println("    LastErrorTime:", pinfo.LastErrorTime)
+		ec := classifyError(pinfo.LastError)
+		fmt.Println("        Category:", ec.Category)
+		fmt.Println("        Explanation:", ec.Explanation)
+		if ec.Fix != "" {
+			fmt.Println("        Suggested fix:", ec.Fix)
+		}
 	}
 
 	if cmd.Bool("distance") {
 		fmt.Print("    Distance to head advertisement: ")
-		dist, _, err := getLastSeenDistance(ctx, cmd, pinfo, p2pHost)
+		dist, err := getLastSeenDistance(ctx, cmd, pinfo, p2pHost)
 		if err != nil {
 			fmt.Println("error:", err)
-		} else if dist == -1 {
-			fmt.Printf("exceeded limit %d+", cmd.Int64("ad-depth-limit"))
+		} else if dist.Exceeded {
+			fmt.Printf("at least %d (limit %d reached at %s)\n", dist.Count, cmd.Int64("ad-depth-limit"), dist.StopCid)
 		} else {
-			fmt.Println(dist)
+			fmt.Println(dist.Count)
 		}
 	}
 
 	if cmd.Bool("spid") {
-		miners, err := getSPID(ctx, pinfo.AddrInfo.ID)
+		miners, err := printer.getSPID(ctx, pinfo.AddrInfo.ID)
 		if err != nil {
-			miners = fmt.Sprint("error:", err)
+			miners = fmt.Sprint("error: ", err)
 		}
 		fmt.Println("    SPID:", miners)
 	}
@@ -470,58 +575,20 @@ func getProtocol(ctx context.Context, peerInfo peer.AddrInfo, p2pHost host.Host)
 	return "libp2phttp", nil
 }
 
-func getLastSeenDistance(ctx context.Context, cmd *cli.Command, pinfo *model.ProviderInfo, p2pHost host.Host) (int, cid.Cid, error) {
+func getLastSeenDistance(ctx context.Context, cmd *cli.Command, pinfo *model.ProviderInfo, p2pHost host.Host) (dtrack.Distance, error) {
 	if pinfo.Publisher == nil {
-		return 0, cid.Undef, errors.New("no publisher listed")
+		return dtrack.Distance{}, errors.New("no publisher listed")
 	}
 	if !pinfo.LastAdvertisement.Defined() {
-		return 0, cid.Undef, errors.New("no last advertisement")
+		return dtrack.Distance{}, errors.New("no last advertisement")
 	}
 	adDist, err := dtrack.NewAdDistance(
 		dtrack.WithDepthLimit(cmd.Int64("ad-depth-limit")),
 		dtrack.WithP2pHost(p2pHost))
 	if err != nil {
-		return 0, cid.Undef, err
+		return dtrack.Distance{}, err
 	}
 	defer adDist.Close()
 
 	return adDist.Get(ctx, *pinfo.Publisher, pinfo.LastAdvertisement, cid.Undef)
 }
-
-func getSPID(ctx context.Context, peerID peer.ID) (string, error) {
-	apiURL, err := url.JoinPath(filfoxPeerAPI, peerID.String())
-	if err != nil {
-		return "", err
-	}
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiURL, nil)
-	if err != nil {
-		return "", err
-	}
-	req.Header.Add("Accept-Encoding", "application/json")
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode == http.StatusNotFound {
-		return "", nil
-	}
-	if resp.StatusCode >= 400 {
-		return "", errors.New(resp.Status)
-	}
-	data, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", err
-	}
-
-	// {"peerId":"12D3KooWFWXbQG9x44JVauFnG7zqzfuR4eDo9iGbXUm9rTLvW7kv","miners":["f0811822"],"multiAddresses":["/ip4/3.140.191.240/tcp/7523"]}
-	var spinfo struct {
-		Miners []string `json:"miners"`
-	}
-	if err = json.Unmarshal(data, &spinfo); err != nil {
-		return "", err
-	}
-
-	return strings.Join(spinfo.Miners, ", "), nil
-}
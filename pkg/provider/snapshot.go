@@ -0,0 +1,439 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ipni/go-libipni/find/model"
+	"github.com/ipni/go-libipni/pcache"
+	"github.com/ipni/ipni-cli/pkg/dtrack"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/urfave/cli/v3"
+)
+
+// snapshotTimeFormat is the format of the time in snapshot file names.
+const snapshotTimeFormat = "20060102T150405Z"
+
+var providerSnapshotSubCmd = &cli.Command{
+	Name:        "snapshot",
+	Usage:       "Save all providers known to an indexer to a timestamped file",
+	Description: `Get all providers from the indexer and write them to a file named providers-<hosts>-<time>.json in the snapshot directory, where hosts are the hosts of all indexers joined by '+'. An existing snapshot file is never overwritten. Use the history subcommand to compare snapshots.`,
+	Flags:       providerSnapshotFlags,
+	Action:      providerSnapshotAction,
+}
+
+var providerSnapshotFlags = []cli.Flag{
+	&cli.StringSliceFlag{
+		Name:    "indexer",
+		Usage:   "Indexer URL. Specifying multiple results in a unified view of providers across all.",
+		Aliases: []string{"i"},
+		Value:   []string{"https://cid.contact"},
+	},
+	&cli.StringFlag{
+		Name:    "dir",
+		Usage:   "Directory to write snapshot file in",
+		Aliases: []string{"d"},
+		Value:   ".",
+	},
+}
+
+var providerHistorySubCmd = &cli.Command{
+	Name:      "history",
+	Usage:     "Show how the providers known to an indexer changed between snapshots",
+	ArgsUsage: "[snapshot-file...]",
+	Description: `Compare each snapshot to the one before it, in time order, and show the providers that were added and removed, that started having errors or became frozen, and whose publisher addresses changed. The snapshots are the files given as arguments, or all snapshots in the directory given by --dir. Snapshots are grouped by the indexers they were taken from, and only snapshots of the same indexers are compared.
+
+Advertisement throughput is shown as the number of providers with a new last advertisement. The --distance flag walks each of those provider's advertisement chain to count the advertisements between snapshots.`,
+	Flags:  providerHistoryFlags,
+	Action: providerHistoryAction,
+}
+
+var providerHistoryFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:    "dir",
+		Usage:   "Directory to read all snapshot files from, when none are given as arguments",
+		Aliases: []string{"d"},
+		Value:   ".",
+	},
+	&cli.BoolFlag{
+		Name:    "distance",
+		Usage:   "Count the advertisements each provider published between snapshots",
+		Aliases: []string{"dist"},
+	},
+	&cli.Int64Flag{
+		Name:    "ad-depth-limit",
+		Aliases: []string{"adl"},
+		Usage:   "Limit on number of advertisements when finding distance. 0 for unlimited.",
+		Value:   5000,
+	},
+	&cli.StringFlag{
+		Name:    "output",
+		Usage:   "Output format: text or json",
+		Aliases: []string{"o"},
+		Value:   outputText,
+	},
+}
+
+// providerSnapshot is the content of a snapshot file.
+type providerSnapshot struct {
+	Indexers  []string              `json:"indexers"`
+	Time      time.Time             `json:"time"`
+	Providers []*model.ProviderInfo `json:"providers"`
+}
+
+// snapshotChange is the difference between two consecutive snapshots.
+type snapshotChange struct {
+	Indexers    []string        `json:"indexers"`
+	From        time.Time       `json:"from"`
+	To          time.Time       `json:"to"`
+	Added       []peer.ID       `json:"added"`
+	Removed     []peer.ID       `json:"removed"`
+	NewErrors   []providerError `json:"new_errors"`
+	NewlyFrozen []peer.ID       `json:"newly_frozen"`
+	PubChanges  []addrChange    `json:"publisher_changes"`
+	NewAds      []adThroughput  `json:"new_ads"`
+}
+
+// addrChange is a change to a provider's publisher addresses.
+type addrChange struct {
+	ID     peer.ID `json:"id"`
+	Before string  `json:"before"`
+	After  string  `json:"after"`
+}
+
+type providerError struct {
+	ID    peer.ID `json:"id"`
+	Error string  `json:"error"`
+}
+
+// adThroughput is the advertisements published by a provider between
+// snapshots. Count is only set if the distance was found.
+type adThroughput struct {
+	ID      peer.ID `json:"id"`
+	Count   *int    `json:"count,omitempty"`
+	PerHour float64 `json:"per_hour,omitempty"`
+	Error   string  `json:"error,omitempty"`
+}
+
+func providerSnapshotAction(ctx context.Context, cmd *cli.Command) error {
+	indexers := cmd.StringSlice("indexer")
+	pc, err := pcache.New(pcache.WithSourceURL(indexers...), pcache.WithRefreshInterval(0))
+	if err != nil {
+		return err
+	}
+
+	snap := providerSnapshot{
+		Indexers:  indexers,
+		Time:      time.Now().UTC().Truncate(time.Second),
+		Providers: pc.List(),
+	}
+	slices.SortFunc(snap.Providers, func(a, b *model.ProviderInfo) int {
+		return strings.Compare(string(a.AddrInfo.ID), string(b.AddrInfo.ID))
+	})
+	name, err := writeSnapshot(cmd.String("dir"), &snap)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Saved %d providers to %s\n", len(snap.Providers), name)
+	return nil
+}
+
+// writeSnapshot writes the snapshot to a new file in dir, and returns the
+// file name. An error is returned if the file already exists.
+func writeSnapshot(dir string, snap *providerSnapshot) (string, error) {
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err = os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("cannot create snapshot directory: %w", err)
+	}
+	name := filepath.Join(dir, snapshotFileName(snap.Indexers, snap.Time))
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			return "", fmt.Errorf("snapshot %s already exists", name)
+		}
+		return "", fmt.Errorf("cannot create snapshot: %w", err)
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(name)
+		return "", fmt.Errorf("cannot write snapshot: %w", err)
+	}
+	return name, nil
+}
+
+// snapshotFileName returns the name of the file for a snapshot of the
+// indexers taken at snapTime. The name has the hosts of all the indexers, in
+// sorted order, so that snapshots of different indexers taken at the same time
+// have different names.
+func snapshotFileName(indexers []string, snapTime time.Time) string {
+	hosts := make([]string, 0, len(indexers))
+	for _, indexer := range indexers {
+		host := "indexer"
+		if u, err := url.Parse(indexer); err == nil && u.Host != "" {
+			host = strings.ReplaceAll(u.Host, ":", "_")
+		}
+		hosts = append(hosts, host)
+	}
+	slices.Sort(hosts)
+	hosts = slices.Compact(hosts)
+	return fmt.Sprintf("providers-%s-%s.json", strings.Join(hosts, "+"), snapTime.Format(snapshotTimeFormat))
+}
+
+func providerHistoryAction(ctx context.Context, cmd *cli.Command) error {
+	format := cmd.String("output")
+	if format != outputText && format != outputJSON {
+		return cli.Exit(fmt.Sprintf("unsupported output format %q: must be text or json", format), 1)
+	}
+
+	files := cmd.Args().Slice()
+	if len(files) == 0 {
+		var err error
+		files, err = filepath.Glob(filepath.Join(cmd.String("dir"), "providers-*.json"))
+		if err != nil {
+			return err
+		}
+	}
+	if len(files) < 2 {
+		return cli.Exit("need at least two snapshots to compare", 1)
+	}
+
+	snaps := make([]*providerSnapshot, len(files))
+	for i, file := range files {
+		snap, err := readSnapshot(file)
+		if err != nil {
+			return err
+		}
+		snaps[i] = snap
+	}
+	groups := groupSnapshots(snaps)
+	if !slices.ContainsFunc(groups, func(group []*providerSnapshot) bool { return len(group) >= 2 }) {
+		return cli.Exit("need at least two snapshots of the same indexers to compare", 1)
+	}
+
+	var adDist *dtrack.AdDistance
+	if cmd.Bool("distance") {
+		var err error
+		adDist, err = dtrack.NewAdDistance(dtrack.WithDepthLimit(cmd.Int64("ad-depth-limit")))
+		if err != nil {
+			return err
+		}
+		defer adDist.Close()
+	}
+
+	changes := historyChanges(ctx, groups, adDist)
+
+	if format == outputJSON {
+		data, err := json.MarshalIndent(changes, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+	for i, change := range changes {
+		if i == 0 || indexersKey(change.Indexers) != indexersKey(changes[i-1].Indexers) {
+			fmt.Println("Indexers:", strings.Join(change.Indexers, ", "))
+			fmt.Println()
+		}
+		change.print()
+	}
+	return nil
+}
+
+// groupSnapshots groups snapshots by the indexers they were taken from, with
+// each group in time order. Groups are ordered by their indexers.
+func groupSnapshots(snaps []*providerSnapshot) [][]*providerSnapshot {
+	byIndexers := make(map[string][]*providerSnapshot)
+	for _, snap := range snaps {
+		key := indexersKey(snap.Indexers)
+		byIndexers[key] = append(byIndexers[key], snap)
+	}
+	keys := slices.Sorted(maps.Keys(byIndexers))
+	groups := make([][]*providerSnapshot, len(keys))
+	for i, key := range keys {
+		group := byIndexers[key]
+		slices.SortFunc(group, func(a, b *providerSnapshot) int {
+			return a.Time.Compare(b.Time)
+		})
+		groups[i] = group
+	}
+	return groups
+}
+
+// historyChanges compares each snapshot to the one before it in the same
+// group.
+func historyChanges(ctx context.Context, groups [][]*providerSnapshot, adDist *dtrack.AdDistance) []snapshotChange {
+	changes := []snapshotChange{}
+	for _, group := range groups {
+		for i := 1; i < len(group); i++ {
+			changes = append(changes, compareSnapshots(ctx, group[i-1], group[i], adDist))
+		}
+	}
+	return changes
+}
+
+// indexersKey identifies the indexers that a snapshot was taken from,
+// regardless of the order they were given in.
+func indexersKey(indexers []string) string {
+	sorted := slices.Clone(indexers)
+	for i := range sorted {
+		sorted[i] = strings.TrimSuffix(sorted[i], "/")
+	}
+	slices.Sort(sorted)
+	return strings.Join(sorted, " ")
+}
+
+func readSnapshot(file string) (*providerSnapshot, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var snap providerSnapshot
+	if err = json.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("cannot decode snapshot %s: %w", file, err)
+	}
+	if snap.Time.IsZero() {
+		return nil, fmt.Errorf("snapshot %s has no time", file)
+	}
+	return &snap, nil
+}
+
+// compareSnapshots finds the changes from the older to the newer snapshot. If
+// adDist is not nil, it is used to count the advertisements published by each
+// provider between snapshots.
+func compareSnapshots(ctx context.Context, older, newer *providerSnapshot, adDist *dtrack.AdDistance) snapshotChange {
+	change := snapshotChange{
+		Indexers:    newer.Indexers,
+		From:        older.Time,
+		To:          newer.Time,
+		Added:       []peer.ID{},
+		Removed:     []peer.ID{},
+		NewErrors:   []providerError{},
+		NewlyFrozen: []peer.ID{},
+		PubChanges:  []addrChange{},
+		NewAds:      []adThroughput{},
+	}
+	hours := newer.Time.Sub(older.Time).Hours()
+
+	byID := make(map[peer.ID]*model.ProviderInfo, len(older.Providers))
+	for _, pinfo := range older.Providers {
+		byID[pinfo.AddrInfo.ID] = pinfo
+	}
+	for _, pinfo := range newer.Providers {
+		id := pinfo.AddrInfo.ID
+		prev, ok := byID[id]
+		if !ok {
+			change.Added = append(change.Added, id)
+			continue
+		}
+		delete(byID, id)
+
+		if pinfo.LastError != "" && prev.LastError == "" {
+			change.NewErrors = append(change.NewErrors, providerError{ID: id, Error: pinfo.LastError})
+		}
+		if pinfo.FrozenAtTime != "" && prev.FrozenAtTime == "" {
+			change.NewlyFrozen = append(change.NewlyFrozen, id)
+		}
+		if oldAddrs, newAddrs := publisherAddrs(prev), publisherAddrs(pinfo); oldAddrs != newAddrs {
+			change.PubChanges = append(change.PubChanges, addrChange{ID: id, Before: oldAddrs, After: newAddrs})
+		}
+		if pinfo.LastAdvertisement.Defined() && pinfo.LastAdvertisement != prev.LastAdvertisement {
+			change.NewAds = append(change.NewAds, countNewAds(ctx, adDist, prev, pinfo, hours))
+		}
+	}
+	for id := range byID {
+		change.Removed = append(change.Removed, id)
+	}
+	slices.Sort(change.Removed)
+	return change
+}
+
+func countNewAds(ctx context.Context, adDist *dtrack.AdDistance, prev, pinfo *model.ProviderInfo, hours float64) adThroughput {
+	tp := adThroughput{
+		ID: pinfo.AddrInfo.ID,
+	}
+	if adDist == nil {
+		return tp
+	}
+	if pinfo.Publisher == nil {
+		tp.Error = "no publisher"
+		return tp
+	}
+	if !prev.LastAdvertisement.Defined() {
+		tp.Error = "no previous advertisement"
+		return tp
+	}
+	dist, err := adDist.Get(ctx, *pinfo.Publisher, prev.LastAdvertisement, pinfo.LastAdvertisement)
+	if err != nil {
+		tp.Error = err.Error()
+		return tp
+	}
+	if dist.Exceeded {
+		tp.Error = fmt.Sprintf("more than %d advertisements", dist.Count)
+		return tp
+	}
+	tp.Count = &dist.Count
+	if hours > 0 {
+		tp.PerHour = float64(dist.Count) / hours
+	}
+	return tp
+}
+
+func (c snapshotChange) print() {
+	fmt.Printf("%s -> %s (%s)\n", c.From.Format(time.RFC3339), c.To.Format(time.RFC3339), c.To.Sub(c.From))
+	fmt.Println("    Added:", len(c.Added))
+	for _, id := range c.Added {
+		fmt.Println("       ", id)
+	}
+	fmt.Println("    Removed:", len(c.Removed))
+	for _, id := range c.Removed {
+		fmt.Println("       ", id)
+	}
+	fmt.Println("    New errors:", len(c.NewErrors))
+	for _, pe := range c.NewErrors {
+		fmt.Printf("        %s: %s\n", pe.ID, pe.Error)
+	}
+	fmt.Println("    Newly frozen:", len(c.NewlyFrozen))
+	for _, id := range c.NewlyFrozen {
+		fmt.Println("       ", id)
+	}
+	fmt.Println("    Publisher address changes:", len(c.PubChanges))
+	for _, ac := range c.PubChanges {
+		fmt.Println("       ", ac.ID)
+		fmt.Println("            Before:", noneIfEmpty(ac.Before))
+		fmt.Println("            After: ", noneIfEmpty(ac.After))
+	}
+	fmt.Println("    Providers with new advertisements:", len(c.NewAds))
+	var total int
+	for _, tp := range c.NewAds {
+		switch {
+		case tp.Count != nil:
+			total += *tp.Count
+			fmt.Printf("        %s: %d advertisements, %.1f per hour\n", tp.ID, *tp.Count, tp.PerHour)
+		case tp.Error != "":
+			fmt.Printf("        %s: %s\n", tp.ID, tp.Error)
+		default:
+			fmt.Println("       ", tp.ID)
+		}
+	}
+	if total != 0 {
+		fmt.Println("    Total advertisements:", total)
+	}
+	fmt.Println()
+}
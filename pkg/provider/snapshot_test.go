@@ -0,0 +1,129 @@
+package provider
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ipni/go-libipni/find/model"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+func saveSnapshot(t *testing.T, dir string, snapTime time.Time, indexers []string, provs ...*model.ProviderInfo) string {
+	name, err := writeSnapshot(dir, &providerSnapshot{
+		Indexers:  indexers,
+		Time:      snapTime,
+		Providers: provs,
+	})
+	require.NoError(t, err)
+	return name
+}
+
+// snapshotHistory reads the snapshot files and returns the changes between
+// snapshots of the same indexers, without counting advertisements.
+func snapshotHistory(t *testing.T, files ...string) []snapshotChange {
+	snaps := make([]*providerSnapshot, len(files))
+	for i, file := range files {
+		var err error
+		snaps[i], err = readSnapshot(file)
+		require.NoError(t, err)
+	}
+	return historyChanges(t.Context(), groupSnapshots(snaps), nil)
+}
+
+func TestSnapshotFileName(t *testing.T) {
+	snapTime := time.Date(2026, 3, 1, 12, 30, 5, 0, time.UTC)
+	tests := []struct {
+		indexers []string
+		want     string
+	}{
+		{indexers: []string{"https://cid.contact"}, want: "providers-cid.contact-20260301T123005Z.json"},
+		{indexers: []string{"http://localhost:3000/"}, want: "providers-localhost_3000-20260301T123005Z.json"},
+		{indexers: []string{"not a url"}, want: "providers-indexer-20260301T123005Z.json"},
+		// All indexers are in the name, in sorted order.
+		{indexers: []string{"https://two.example", "https://one.example"}, want: "providers-one.example+two.example-20260301T123005Z.json"},
+		{indexers: []string{"https://one.example", "https://two.example"}, want: "providers-one.example+two.example-20260301T123005Z.json"},
+		{indexers: []string{"https://one.example", "https://one.example/"}, want: "providers-one.example-20260301T123005Z.json"},
+	}
+	for _, tc := range tests {
+		require.Equal(t, tc.want, snapshotFileName(tc.indexers, snapTime), "indexers: %v", tc.indexers)
+	}
+}
+
+func TestWriteSnapshot(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "snapshots")
+	snapTime := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	provA := &model.ProviderInfo{AddrInfo: addrInfo(t, pidA)}
+	provB := &model.ProviderInfo{AddrInfo: addrInfo(t, pidB)}
+
+	name := saveSnapshot(t, dir, snapTime, []string{"https://one.example"}, provA)
+	require.Equal(t, filepath.Join(dir, "providers-one.example-20260301T000000Z.json"), name)
+	snap, err := readSnapshot(name)
+	require.NoError(t, err)
+	require.Equal(t, []string{"https://one.example"}, snap.Indexers)
+	require.Equal(t, snapTime, snap.Time)
+	require.Len(t, snap.Providers, 1)
+	require.Equal(t, provA.AddrInfo.ID, snap.Providers[0].AddrInfo.ID)
+
+	// A snapshot of the same indexers at the same time is not overwritten.
+	before, err := os.ReadFile(name)
+	require.NoError(t, err)
+	_, err = writeSnapshot(dir, &providerSnapshot{
+		Indexers:  []string{"https://one.example/"},
+		Time:      snapTime,
+		Providers: []*model.ProviderInfo{provB},
+	})
+	require.ErrorContains(t, err, "already exists")
+	after, err := os.ReadFile(name)
+	require.NoError(t, err)
+	require.Equal(t, before, after)
+
+	// A snapshot that includes another indexer has a different name.
+	other := saveSnapshot(t, dir, snapTime, []string{"https://one.example", "https://two.example"}, provB)
+	require.NotEqual(t, name, other)
+}
+
+func TestSnapshotHistoryGroupsIndexers(t *testing.T) {
+	dir := t.TempDir()
+	provA := &model.ProviderInfo{AddrInfo: addrInfo(t, pidA)}
+	provB := &model.ProviderInfo{AddrInfo: addrInfo(t, pidB)}
+	provC := &model.ProviderInfo{AddrInfo: addrInfo(t, pidC)}
+
+	one := []string{"https://one.example"}
+	two := []string{"https://two.example"}
+	start := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	// Snapshots of the two indexers are interleaved in time. The indexers
+	// know different providers, so comparing across indexers would show
+	// providers as added and removed.
+	saveSnapshot(t, dir, start, one, provA)
+	saveSnapshot(t, dir, start.Add(time.Hour), two, provC)
+	saveSnapshot(t, dir, start.Add(2*time.Hour), one, provA, provB)
+	saveSnapshot(t, dir, start.Add(3*time.Hour), two, provC)
+	// Same indexers as one, given with a trailing slash.
+	saveSnapshot(t, dir, start.Add(4*time.Hour), []string{"https://one.example/"}, provB)
+
+	files, err := filepath.Glob(filepath.Join(dir, "providers-*.json"))
+	require.NoError(t, err)
+	changes := snapshotHistory(t, files...)
+	require.Len(t, changes, 3)
+
+	require.Equal(t, one, changes[0].Indexers)
+	require.Equal(t, start, changes[0].From)
+	require.Equal(t, start.Add(2*time.Hour), changes[0].To)
+	require.Equal(t, []peer.ID{decodePeerID(t, pidB)}, changes[0].Added)
+	require.Empty(t, changes[0].Removed)
+
+	require.Equal(t, start.Add(2*time.Hour), changes[1].From)
+	require.Equal(t, start.Add(4*time.Hour), changes[1].To)
+	require.Empty(t, changes[1].Added)
+	require.Equal(t, []peer.ID{decodePeerID(t, pidA)}, changes[1].Removed)
+
+	require.Equal(t, two, changes[2].Indexers)
+	require.Equal(t, start.Add(time.Hour), changes[2].From)
+	require.Equal(t, start.Add(3*time.Hour), changes[2].To)
+	require.Empty(t, changes[2].Added)
+	require.Empty(t, changes[2].Removed)
+}
@@ -0,0 +1,97 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ipni/ipni-cli/pkg/spaddr/spinfo"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/urfave/cli/v3"
+)
+
+const (
+	spidResolverLotus  = "lotus"
+	spidResolverFilfox = "filfox"
+)
+
+// spidConcurrency is the number of concurrent requests made to resolve
+// storage provider IDs.
+const spidConcurrency = 16
+
+func defaultSPIDCachePath() string {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(cacheDir, "ipni-cli", "spid.json")
+}
+
+// newSPIDResolver creates the storage provider ID resolver selected by the
+// command flags.
+func newSPIDResolver(cmd *cli.Command) (spinfo.Resolver, error) {
+	var resolver spinfo.Resolver
+	switch name := cmd.String("spid-resolver"); name {
+	case spidResolverFilfox:
+		resolver = spinfo.NewFilfoxResolver(spinfo.FilfoxPeerAPI, spidConcurrency)
+	case spidResolverLotus:
+		var err error
+		resolver, err = spinfo.NewLotusResolver(cmd.String("gateway"), spidConcurrency)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown spid resolver %q: must be filfox or lotus", name)
+	}
+
+	if cachePath := cmd.String("spid-cache"); cachePath != "" {
+		cachePath = spidCachePath(cachePath, cmd.String("spid-resolver"))
+		resolver = spinfo.NewCachedResolver(resolver, cachePath, cmd.Duration("spid-cache-ttl"))
+	}
+	return resolver, nil
+}
+
+// spidCachePath returns the path of the cache file for the named resolver.
+// Each resolver keeps a separate cache, since they may resolve peers
+// differently. The filfox resolver, which is the default, uses the cache path
+// as given.
+func spidCachePath(cachePath, resolverName string) string {
+	if resolverName == spidResolverFilfox {
+		return cachePath
+	}
+	ext := filepath.Ext(cachePath)
+	return strings.TrimSuffix(cachePath, ext) + "-" + resolverName + ext
+}
+
+// resolveSPIDs looks up the storage provider IDs of all the peers at once, if
+// storage provider IDs are output.
+func (p *providerPrinter) resolveSPIDs(ctx context.Context, peerIDs []peer.ID) {
+	if p.spidResolver == nil || len(peerIDs) == 0 {
+		return
+	}
+	resolved, err := p.spidResolver.Resolve(ctx, peerIDs)
+	if err != nil {
+		p.spidErr = err
+	}
+	for peerID, spIDs := range resolved {
+		p.spids[peerID] = spIDs
+	}
+}
+
+// getSPID returns the storage provider IDs of the peer, looking them up if
+// they were not already resolved.
+func (p *providerPrinter) getSPID(ctx context.Context, peerID peer.ID) (string, error) {
+	spIDs, ok := p.spids[peerID]
+	if !ok {
+		if p.spidErr != nil {
+			return "", p.spidErr
+		}
+		p.resolveSPIDs(ctx, []peer.ID{peerID})
+		if spIDs, ok = p.spids[peerID]; !ok {
+			return "", p.spidErr
+		}
+	}
+	return strings.Join(spIDs, ", "), nil
+}
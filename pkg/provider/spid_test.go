@@ -0,0 +1,16 @@
+package provider
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSPIDCachePath(t *testing.T) {
+	require.Equal(t, "/cache/spid.json", spidCachePath("/cache/spid.json", spidResolverFilfox))
+	require.Equal(t, "/cache/spid-lotus.json", spidCachePath("/cache/spid.json", spidResolverLotus))
+	require.Equal(t, "/cache/spid-lotus", spidCachePath("/cache/spid", spidResolverLotus))
+	require.NotEqual(t,
+		spidCachePath("/cache/spid.json", spidResolverLotus),
+		spidCachePath("/cache/spid.json", spidResolverFilfox))
+}
@@ -0,0 +1,216 @@
+package provider
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ipni/go-libipni/find/model"
+	"github.com/ipni/go-libipni/pcache"
+	"github.com/urfave/cli/v3"
+)
+
+// adAgeBuckets are the upper limits of the last advertisement age buckets.
+var adAgeBuckets = []struct {
+	name  string
+	limit time.Duration
+}{
+	{"under 1h", time.Hour},
+	{"1h to 24h", 24 * time.Hour},
+	{"1d to 7d", 7 * 24 * time.Hour},
+	{"7d to 30d", 30 * 24 * time.Hour},
+	{"over 30d", 0},
+}
+
+const (
+	noLastAdBucket   = "no advertisement"
+	noPublisherProto = "no publisher"
+	otherAddrProto   = "other"
+)
+
+// namedCount is a count with a name, used for output that keeps its order.
+type namedCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// providerSummary is the aggregate information about a set of providers.
+type providerSummary struct {
+	Total                 int          `json:"total"`
+	Frozen                int          `json:"frozen"`
+	Inactive              int          `json:"inactive"`
+	WithError             int          `json:"with_error"`
+	WithExtendedProviders int          `json:"with_extended_providers"`
+	PublisherProtocols    []namedCount `json:"publisher_protocols"`
+	LastAdAge             []namedCount `json:"last_ad_age"`
+	ErrorCategories       []namedCount `json:"error_categories"`
+}
+
+// summarizeProviders counts the providers that pass the filter. Publisher
+// protocols are determined from the publisher's addresses, without contacting
+// the publisher.
+func summarizeProviders(provs []*model.ProviderInfo, filter *providerFilter, now time.Time) providerSummary {
+	var summary providerSummary
+	protos := make(map[string]int)
+	errCats := make(map[string]int)
+	ages := make(map[string]int)
+
+	for _, pinfo := range provs {
+		if !filter.match(pinfo) {
+			continue
+		}
+		summary.Total++
+		if pinfo.FrozenAtTime != "" {
+			summary.Frozen++
+		}
+		if pinfo.Inactive {
+			summary.Inactive++
+		}
+		if hasExtendedProviders(pinfo) {
+			summary.WithExtendedProviders++
+		}
+		if pinfo.LastError != "" {
+			summary.WithError++
+			category := classifyError(pinfo.LastError).Category
+			if category == errCategoryUnknown {
+				category = "unknown: " + errorPrefix(pinfo.LastError)
+			}
+			errCats[category]++
+		}
+		protos[publisherProtocols(pinfo)]++
+		ages[adAgeBucket(pinfo, now)]++
+	}
+
+	summary.PublisherProtocols = sortedCounts(protos)
+	summary.ErrorCategories = sortedCounts(errCats)
+	summary.LastAdAge = make([]namedCount, 0, len(adAgeBuckets)+1)
+	for _, bucket := range adAgeBuckets {
+		summary.LastAdAge = append(summary.LastAdAge, namedCount{bucket.name, ages[bucket.name]})
+	}
+	summary.LastAdAge = append(summary.LastAdAge, namedCount{noLastAdBucket, ages[noLastAdBucket]})
+	return summary
+}
+
+func hasExtendedProviders(pinfo *model.ProviderInfo) bool {
+	ep := pinfo.ExtendedProviders
+	return ep != nil && (len(ep.Providers) != 0 || len(ep.Contextual) != 0)
+}
+
+// publisherProtocols returns the protocols of the publisher's addresses, such
+// as "http" or "http+tcp".
+func publisherProtocols(pinfo *model.ProviderInfo) string {
+	if pinfo.Publisher == nil {
+		return noPublisherProto
+	}
+	var protos []string
+	for _, addr := range pinfo.Publisher.Addrs {
+		proto := addrProto(addr)
+		if proto == "" {
+			proto = otherAddrProto
+		}
+		if !slices.Contains(protos, proto) {
+			protos = append(protos, proto)
+		}
+	}
+	if len(protos) == 0 {
+		return "no addresses"
+	}
+	slices.Sort(protos)
+	return strings.Join(protos, "+")
+}
+
+func adAgeBucket(pinfo *model.ProviderInfo, now time.Time) string {
+	if pinfo.LastAdvertisementTime == "" {
+		return noLastAdBucket
+	}
+	adTime, err := time.Parse(time.RFC3339, pinfo.LastAdvertisementTime)
+	if err != nil {
+		return noLastAdBucket
+	}
+	age := now.Sub(adTime)
+	for _, bucket := range adAgeBuckets {
+		if bucket.limit == 0 || age < bucket.limit {
+			return bucket.name
+		}
+	}
+	return adAgeBuckets[len(adAgeBuckets)-1].name
+}
+
+var errorDetail = regexp.MustCompile(`\b(12D3KooW\w+|Qm\w{44}|bafy\w+|bagu\w+)\b|/(ip4|ip6|dns|dns4|dns6)/\S+|\d+`)
+
+// errorPrefix groups error messages that differ only in details such as peer
+// IDs, CIDs, addresses, and numbers, by returning the start of the message up
+// to the first detail.
+func errorPrefix(msg string) string {
+	msg = errorDetail.ReplaceAllString(msg, "*")
+	if i := strings.Index(msg, "*"); i > 0 {
+		msg = msg[:i]
+	}
+	msg = strings.TrimRight(strings.TrimSpace(msg), ":")
+	if msg == "" || msg == "*" {
+		return "other"
+	}
+	return msg
+}
+
+// sortedCounts returns the counts with the largest first.
+func sortedCounts(counts map[string]int) []namedCount {
+	sorted := make([]namedCount, 0, len(counts))
+	for name, count := range counts {
+		sorted = append(sorted, namedCount{name, count})
+	}
+	slices.SortFunc(sorted, func(a, b namedCount) int {
+		if c := cmp.Compare(b.Count, a.Count); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	return sorted
+}
+
+func showSummary(cmd *cli.Command, filter *providerFilter) error {
+	format := cmd.String("output")
+	if format != outputText && format != outputJSON {
+		return cli.Exit(fmt.Sprintf("unsupported output format %q for summary: must be text or json", format), 1)
+	}
+	pc, err := pcache.New(pcache.WithRefreshInterval(0),
+		pcache.WithSourceURL(cmd.StringSlice("indexer")...))
+	if err != nil {
+		return err
+	}
+	summary := summarizeProviders(pc.List(), filter, time.Now())
+
+	if format == outputJSON {
+		data, err := json.MarshalIndent(summary, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	fmt.Println("Providers:", summary.Total)
+	fmt.Println("    Frozen:", summary.Frozen)
+	fmt.Println("    Inactive:", summary.Inactive)
+	fmt.Println("    With LastError:", summary.WithError)
+	fmt.Println("    With extended providers:", summary.WithExtendedProviders)
+	printCounts("Publisher address protocols", summary.PublisherProtocols)
+	printCounts("Last advertisement age", summary.LastAdAge)
+	printCounts("LastError categories", summary.ErrorCategories)
+	return nil
+}
+
+func printCounts(title string, counts []namedCount) {
+	fmt.Printf("%s:\n", title)
+	if len(counts) == 0 {
+		fmt.Println("    none")
+		return
+	}
+	for _, nc := range counts {
+		fmt.Printf("    %s: %d\n", nc.Name, nc.Count)
+	}
+}
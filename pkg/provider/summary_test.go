@@ -0,0 +1,144 @@
+package provider
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ipni/go-libipni/find/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSummarizeProvidersAdAge(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	ages := []string{
+		now.Add(-30 * time.Minute).Format(time.RFC3339),
+		// The upper limit of a bucket is in the next bucket.
+		now.Add(-time.Hour).Format(time.RFC3339),
+		now.Add(-23 * time.Hour).Format(time.RFC3339),
+		now.Add(-3 * 24 * time.Hour).Format(time.RFC3339),
+		now.Add(-10 * 24 * time.Hour).Format(time.RFC3339),
+		now.Add(-30 * 24 * time.Hour).Format(time.RFC3339),
+		now.Add(-400 * 24 * time.Hour).Format(time.RFC3339),
+		"",
+		"not a time",
+	}
+	provs := make([]*model.ProviderInfo, len(ages))
+	for i, age := range ages {
+		provs[i] = &model.ProviderInfo{
+			AddrInfo:              addrInfo(t, pidA),
+			LastAdvertisementTime: age,
+		}
+	}
+
+	filter, err := parseProviderFilter(t, nil)
+	require.NoError(t, err)
+	summary := summarizeProviders(provs, filter, now)
+	require.Equal(t, len(ages), summary.Total)
+	require.Equal(t, []namedCount{
+		{Name: "under 1h", Count: 1},
+		{Name: "1h to 24h", Count: 2},
+		{Name: "1d to 7d", Count: 1},
+		{Name: "7d to 30d", Count: 1},
+		{Name: "over 30d", Count: 2},
+		{Name: "no advertisement", Count: 2},
+	}, summary.LastAdAge)
+}
+
+func TestSummarizeProviders(t *testing.T) {
+	now := time.Now()
+	provs := testProviders(t)
+	provs = append(provs,
+		&model.ProviderInfo{
+			AddrInfo:  addrInfo(t, pidA),
+			LastError: "storage full on 12D3KooWLjeDyvuv7rbfG2wWNvWn7ybmmU88PirmSckuqCgXBAph",
+		},
+		&model.ProviderInfo{
+			AddrInfo:  addrInfo(t, pidB),
+			LastError: "storage full on 12D3KooWPNbkEgjdBNeaCGpsgCrPRETe4uBZf1ShFXStobdN18ys",
+		},
+	)
+
+	filter, err := parseProviderFilter(t, nil)
+	require.NoError(t, err)
+	summary := summarizeProviders(provs, filter, now)
+	require.Equal(t, 5, summary.Total)
+	require.Equal(t, 1, summary.Frozen)
+	require.Equal(t, 1, summary.Inactive)
+	require.Equal(t, 4, summary.WithError)
+	require.Equal(t, 1, summary.WithExtendedProviders)
+	require.Equal(t, []namedCount{
+		{Name: "no publisher", Count: 3},
+		{Name: "http", Count: 1},
+		{Name: "quic+tcp", Count: 1},
+	}, summary.PublisherProtocols)
+	// Unknown errors that differ only in peer ID are counted together.
+	require.Equal(t, []namedCount{
+		{Name: "unknown: storage full on", Count: 2},
+		{Name: "dial failure", Count: 1},
+		{Name: "rate limited", Count: 1},
+	}, summary.ErrorCategories)
+
+	// Only providers that pass the filter are counted.
+	filter, err = parseProviderFilter(t, nil, "--error", "--invert")
+	require.NoError(t, err)
+	summary = summarizeProviders(provs, filter, now)
+	require.Equal(t, 1, summary.Total)
+	require.Equal(t, 0, summary.WithError)
+	require.Empty(t, summary.ErrorCategories)
+	require.Equal(t, []namedCount{{Name: "http", Count: 1}}, summary.PublisherProtocols)
+}
+
+func TestErrorPrefix(t *testing.T) {
+	tests := []struct {
+		msg  string
+		want string
+	}{
+		{
+			msg:  "storage full",
+			want: "storage full",
+		},
+		{
+			msg:  "cannot process advertisement bafyreihdb57fdysx5h35urvxz64ros7zvywshber7bzwzx5tfsdkbi4mbe: bad schema",
+			want: "cannot process advertisement",
+		},
+		{
+			msg:  "cannot process advertisement baguqeeraqsvqabzwqh3ijpk3mwmqaufqh5bgmbvemzmtu6hvdzljfxrkxh2q: bad schema",
+			want: "cannot process advertisement",
+		},
+		{
+			msg:  "bad head from 12D3KooWE8yt84RVwW3sFcd6WMjbUdWrZer2YtT4dmtj3dHdahSZ",
+			want: "bad head from",
+		},
+		{
+			msg:  "bad head from QmcgwdNjFQVhKt6aWWtSPgdLbNvULRoFMU6CCYwHsN3EEH",
+			want: "bad head from",
+		},
+		{
+			msg:  "unexpected response from /ip4/1.2.3.4/tcp/3104/http",
+			want: "unexpected response from",
+		},
+		{
+			msg:  "unexpected response from /dns/example.com/tcp/443/https",
+			want: "unexpected response from",
+		},
+		{
+			msg:  "storage at 98% capacity",
+			want: "storage at",
+		},
+		{
+			msg:  "cannot store:",
+			want: "cannot store",
+		},
+		{
+			msg:  "12D3KooWE8yt84RVwW3sFcd6WMjbUdWrZer2YtT4dmtj3dHdahSZ",
+			want: "other",
+		},
+		{
+			msg:  "",
+			want: "other",
+		},
+	}
+	for _, tc := range tests {
+		require.Equal(t, tc.want, errorPrefix(tc.msg), "message: %s", tc.msg)
+	}
+}
@@ -0,0 +1,208 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/ipni/go-libipni/find/model"
+	"github.com/ipni/go-libipni/pcache"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/urfave/cli/v3"
+)
+
+const (
+	eventNewAd          = "new_ad"
+	eventError          = "error"
+	eventErrorCleared   = "error_cleared"
+	eventFrozen         = "frozen"
+	eventUnfrozen       = "unfrozen"
+	eventInactive       = "inactive"
+	eventPublisherAddrs = "publisher_addrs"
+)
+
+// watchEvent is a change to a provider's record, output as one line of NDJSON.
+type watchEvent struct {
+	Time     time.Time `json:"time"`
+	ID       peer.ID   `json:"id"`
+	Event    string    `json:"event"`
+	Old      string    `json:"old,omitempty"`
+	New      string    `json:"new,omitempty"`
+	Category string    `json:"category,omitempty"`
+}
+
+// watchProviders polls the indexer for provider records at the update interval,
+// and writes an event for each change to a watched provider. If include is
+// not empty, only those providers are watched. Otherwise, all providers except
+// those excluded by the filter are watched. Events are only written for
+// providers that match the filter before or after the change.
+func watchProviders(ctx context.Context, cmd *cli.Command, include map[peer.ID]struct{}, filter *providerFilter) error {
+	interval, err := time.ParseDuration(cmd.String("update-interval"))
+	if err != nil {
+		return err
+	}
+	if interval <= 0 {
+		return errors.New("update interval must be greater than zero")
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+
+	indexers := cmd.StringSlice("indexer")
+	prev, err := fetchWatched(ctx, indexers, include, filter.exclude)
+	if err != nil {
+		return err
+	}
+	var matched int
+	for _, pinfo := range prev {
+		if filter.match(pinfo) {
+			matched++
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Watching %d providers for changes every %s, ctrl-c to cancel...\n", matched, interval)
+
+	enc := json.NewEncoder(os.Stdout)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+
+		provs, err := fetchWatched(ctx, indexers, include, filter.exclude)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			fmt.Fprintln(os.Stderr, "Cannot get providers:", err)
+			continue
+		}
+		for _, event := range pollEvents(prev, provs, filter, time.Now().UTC()) {
+			if err = enc.Encode(event); err != nil {
+				return err
+			}
+		}
+		// Keep the last known record of providers missing from this poll.
+		for id, pinfo := range provs {
+			prev[id] = pinfo
+		}
+	}
+}
+
+// pollEvents returns the events for the changes between the previous and the
+// current records of providers. A provider's changes are only returned if
+// the provider matches the filter either before or after the changes, so that
+// a change that takes a provider out of the filter, such as a cleared error
+// when filtering by --error, is still seen.
+func pollEvents(prev, provs map[peer.ID]*model.ProviderInfo, filter *providerFilter, now time.Time) []watchEvent {
+	var events []watchEvent
+	for id, pinfo := range provs {
+		old, ok := prev[id]
+		if !ok {
+			continue
+		}
+		if !filter.match(old) && !filter.match(pinfo) {
+			continue
+		}
+		for _, event := range providerEvents(old, pinfo) {
+			event.Time = now
+			event.ID = id
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// fetchWatched gets the current records of the watched providers. A new
+// provider cache is created for each poll, because refreshing an existing
+// cache only updates providers that have a newer advertisement, and would miss
+// changes such as a new LastError.
+func fetchWatched(ctx context.Context, indexers []string, include, exclude map[peer.ID]struct{}) (map[peer.ID]*model.ProviderInfo, error) {
+	provs := make(map[peer.ID]*model.ProviderInfo)
+	if len(include) != 0 {
+		pc, err := pcache.New(pcache.WithPreload(false), pcache.WithRefreshInterval(0),
+			pcache.WithSourceURL(indexers...))
+		if err != nil {
+			return nil, err
+		}
+		for id := range include {
+			pinfo, err := pc.Get(ctx, id)
+			if err != nil {
+				return nil, err
+			}
+			if pinfo != nil {
+				provs[id] = pinfo
+			}
+		}
+		return provs, nil
+	}
+
+	pc, err := pcache.New(pcache.WithRefreshInterval(0), pcache.WithSourceURL(indexers...))
+	if err != nil {
+		return nil, err
+	}
+	for _, pinfo := range pc.List() {
+		if _, ok := exclude[pinfo.AddrInfo.ID]; !ok {
+			provs[pinfo.AddrInfo.ID] = pinfo
+		}
+	}
+	return provs, nil
+}
+
+// providerEvents returns the events for the changes from the old to the new
+// record of a provider.
+func providerEvents(old, pinfo *model.ProviderInfo) []watchEvent {
+	var events []watchEvent
+	if pinfo.LastAdvertisement.Defined() && pinfo.LastAdvertisement != old.LastAdvertisement {
+		events = append(events, watchEvent{
+			Event: eventNewAd,
+			Old:   cidString(old.LastAdvertisement),
+			New:   pinfo.LastAdvertisement.String(),
+		})
+	}
+	switch {
+	case pinfo.LastError != "" && pinfo.LastError != old.LastError:
+		events = append(events, watchEvent{
+			Event:    eventError,
+			Old:      old.LastError,
+			New:      pinfo.LastError,
+			Category: classifyError(pinfo.LastError).Category,
+		})
+	case pinfo.LastError == "" && old.LastError != "":
+		events = append(events, watchEvent{
+			Event: eventErrorCleared,
+			Old:   old.LastError,
+		})
+	}
+	switch {
+	case pinfo.FrozenAtTime != "" && old.FrozenAtTime == "":
+		events = append(events, watchEvent{
+			Event: eventFrozen,
+			New:   pinfo.FrozenAtTime,
+		})
+	case pinfo.FrozenAtTime == "" && old.FrozenAtTime != "":
+		events = append(events, watchEvent{
+			Event: eventUnfrozen,
+			Old:   old.FrozenAtTime,
+		})
+	}
+	if pinfo.Inactive && !old.Inactive {
+		events = append(events, watchEvent{
+			Event: eventInactive,
+		})
+	}
+	if oldAddrs, newAddrs := publisherAddrs(old), publisherAddrs(pinfo); oldAddrs != newAddrs {
+		events = append(events, watchEvent{
+			Event: eventPublisherAddrs,
+			Old:   oldAddrs,
+			New:   newAddrs,
+		})
+	}
+	return events
+}
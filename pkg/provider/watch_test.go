@@ -0,0 +1,66 @@
+package provider
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ipni/go-libipni/find/model"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPollEventsFilter(t *testing.T) {
+	idA := decodePeerID(t, pidA)
+	idB := decodePeerID(t, pidB)
+	idC := decodePeerID(t, pidC)
+
+	prev := map[peer.ID]*model.ProviderInfo{
+		idA: {AddrInfo: addrInfo(t, pidA)},
+		idB: {AddrInfo: addrInfo(t, pidB), LastError: "failed to dial"},
+		idC: {AddrInfo: addrInfo(t, pidC)},
+	}
+	provs := map[peer.ID]*model.ProviderInfo{
+		// Gets an error, so matches --error after the change.
+		idA: {AddrInfo: addrInfo(t, pidA), LastError: "failed to dial"},
+		// Error cleared, so matches --error before the change.
+		idB: {AddrInfo: addrInfo(t, pidB)},
+		// Frozen, but never matches --error.
+		idC: {AddrInfo: addrInfo(t, pidC), FrozenAtTime: "2026-02-01T10:00:00Z"},
+	}
+	now := time.Now()
+
+	eventIDs := func(events []watchEvent) []string {
+		ids := make([]string, len(events))
+		for i, event := range events {
+			require.Equal(t, now, event.Time)
+			ids[i] = event.ID.String() + " " + event.Event
+		}
+		slices.SortFunc(ids, strings.Compare)
+		return ids
+	}
+
+	filter, err := parseProviderFilter(t, nil)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{
+		pidA + " error",
+		pidB + " error_cleared",
+		pidC + " frozen",
+	}, eventIDs(pollEvents(prev, provs, filter, now)))
+
+	filter, err = parseProviderFilter(t, nil, "--error")
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{
+		pidA + " error",
+		pidB + " error_cleared",
+	}, eventIDs(pollEvents(prev, provs, filter, now)))
+
+	filter, err = parseProviderFilter(t, nil, "--frozen")
+	require.NoError(t, err)
+	require.Equal(t, []string{pidC + " frozen"}, eventIDs(pollEvents(prev, provs, filter, now)))
+
+	// Providers that are new in this poll have no events.
+	delete(prev, idC)
+	require.Empty(t, pollEvents(prev, provs, filter, now))
+}
@@ -25,7 +25,7 @@ var spAddrFlags = []cli.Flag{
 	},
 	&cli.StringFlag{
 		Name:     "gateway",
-		Usage:    "Specified lotus gateway host",
+		Usage:    "Lotus gateway host, or URL of its JSON-RPC endpoint",
 		Aliases:  []string{"g"},
 		Required: false,
 		Value:    "api.chain.love",
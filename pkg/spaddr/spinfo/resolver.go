@@ -0,0 +1,385 @@
+package spinfo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/libp2p/go-libp2p/core/peer"
+	jrpc "github.com/ybbus/jsonrpc/v2"
+)
+
+// FilfoxPeerAPI is the filfox API used to look up the storage providers of a
+// peer ID.
+const FilfoxPeerAPI = "https://filfox.info/api/v1/peer"
+
+// Resolver finds the Filecoin storage provider IDs that use a peer ID.
+type Resolver interface {
+	// Resolve returns the storage provider IDs of each of the peers. A peer
+	// without any storage provider IDs maps to an empty slice. Any peer that
+	// could not be looked up is not in the returned map. Resolve may also
+	// return storage provider IDs for peers that were not requested.
+	Resolve(ctx context.Context, peerIDs []peer.ID) (map[peer.ID][]string, error)
+}
+
+// LotusResolver resolves storage provider IDs using a lotus gateway. Lotus
+// cannot look up storage providers by peer ID, so the first Resolve gets the
+// info of every storage provider to build an index of peer IDs.
+type LotusResolver struct {
+	client      jrpc.RPCClient
+	concurrency int
+
+	mutex sync.Mutex
+	index map[peer.ID][]string
+}
+
+// NewLotusResolver creates a resolver that uses the lotus gateway. The gateway
+// is either a host name, which is reached using https at /rpc/v1, or a full
+// URL. Concurrency is the number of storage provider info requests to make at
+// the same time.
+func NewLotusResolver(gateway string, concurrency int) (*LotusResolver, error) {
+	if gateway == "" {
+		return nil, errors.New("empty gateway")
+	}
+	return &LotusResolver{
+		client:      jrpc.NewClient(gatewayURL(gateway)),
+		concurrency: max(concurrency, 1),
+	}, nil
+}
+
+// Resolve implements Resolver. All peers in the index are returned. If the
+// index could only be partly built, then the peers in the partial index are
+// returned along with the error.
+func (r *LotusResolver) Resolve(ctx context.Context, peerIDs []peer.ID) (map[peer.ID][]string, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	index := r.index
+	if index == nil {
+		var err error
+		index, err = r.buildIndex(ctx)
+		if err != nil {
+			if index == nil {
+				return nil, err
+			}
+			// Return the partial index, without keeping it, so that the next
+			// Resolve tries to build the whole index again. Requested peers
+			// that are not in the partial index may belong to a storage
+			// provider whose info could not be read, so they are not
+			// returned.
+			resolved := make(map[peer.ID][]string, len(index))
+			for peerID, spIDs := range index {
+				resolved[peerID] = spIDs
+			}
+			return resolved, err
+		}
+		r.index = index
+	}
+
+	resolved := make(map[peer.ID][]string, len(index)+len(peerIDs))
+	for peerID, spIDs := range index {
+		resolved[peerID] = spIDs
+	}
+	for _, peerID := range peerIDs {
+		if _, ok := resolved[peerID]; !ok {
+			resolved[peerID] = []string{}
+		}
+	}
+	return resolved, nil
+}
+
+// buildIndex gets the info of every storage provider and indexes the storage
+// provider IDs by peer ID. If the info of some storage providers cannot be
+// read, then the index of the others is returned along with the error.
+func (r *LotusResolver) buildIndex(ctx context.Context) (map[peer.ID][]string, error) {
+	var ets ExpTipSet
+	err := r.client.CallFor(&ets, "Filecoin.ChainHead")
+	if err != nil {
+		return nil, fmt.Errorf("cannot get chain head from gateway: %w", err)
+	}
+
+	var miners []address.Address
+	err = r.client.CallFor(&miners, "Filecoin.StateListMiners", []any{ets.Cids})
+	if err != nil {
+		return nil, fmt.Errorf("cannot list miners from gateway: %w", err)
+	}
+
+	index := make(map[peer.ID][]string)
+	var indexMutex sync.Mutex
+	var errCount int
+	var firstErr error
+
+	minerChan := make(chan address.Address)
+	var wg sync.WaitGroup
+	for range min(r.concurrency, len(miners)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for spAddr := range minerChan {
+				var minerInfo MinerInfo
+				err := r.client.CallFor(&minerInfo, "Filecoin.StateMinerInfo", spAddr, ets.Cids)
+				indexMutex.Lock()
+				switch {
+				case err != nil:
+					if errCount == 0 {
+						firstErr = err
+					}
+					errCount++
+				case minerInfo.PeerId != nil:
+					index[*minerInfo.PeerId] = append(index[*minerInfo.PeerId], spAddr.String())
+				}
+				indexMutex.Unlock()
+			}
+		}()
+	}
+	for _, spAddr := range miners {
+		select {
+		case minerChan <- spAddr:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	close(minerChan)
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if errCount != 0 {
+		return index, fmt.Errorf("cannot get miner info for %d of %d miners: %w", errCount, len(miners), firstErr)
+	}
+	return index, nil
+}
+
+// FilfoxResolver resolves storage provider IDs using the filfox API, making
+// one request per peer.
+type FilfoxResolver struct {
+	apiURL      string
+	concurrency int
+}
+
+// NewFilfoxResolver creates a resolver that uses the filfox peer API at
+// apiURL, looking up concurrency peers at the same time.
+func NewFilfoxResolver(apiURL string, concurrency int) *FilfoxResolver {
+	return &FilfoxResolver{
+		apiURL:      apiURL,
+		concurrency: max(concurrency, 1),
+	}
+}
+
+// Resolve implements Resolver. If any peer cannot be looked up, then the
+// resolved peers are returned along with the first error.
+func (r *FilfoxResolver) Resolve(ctx context.Context, peerIDs []peer.ID) (map[peer.ID][]string, error) {
+	resolved := make(map[peer.ID][]string, len(peerIDs))
+	var mutex sync.Mutex
+	var firstErr error
+
+	peerChan := make(chan peer.ID)
+	var wg sync.WaitGroup
+	for range min(r.concurrency, len(peerIDs)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for peerID := range peerChan {
+				spIDs, err := r.resolveOne(ctx, peerID)
+				mutex.Lock()
+				if err != nil {
+					if firstErr == nil {
+						firstErr = fmt.Errorf("cannot resolve %s: %w", peerID, err)
+					}
+				} else {
+					resolved[peerID] = spIDs
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+	for _, peerID := range peerIDs {
+		select {
+		case peerChan <- peerID:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	close(peerChan)
+	wg.Wait()
+
+	if firstErr == nil && ctx.Err() != nil {
+		firstErr = ctx.Err()
+	}
+	return resolved, firstErr
+}
+
+func (r *FilfoxResolver) resolveOne(ctx context.Context, peerID peer.ID) ([]string, error) {
+	apiURL, err := url.JoinPath(r.apiURL, peerID.String())
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Accept", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return []string{}, nil
+	}
+	if resp.StatusCode >= 400 {
+		return nil, errors.New(resp.Status)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	// {"peerId":"12D3KooWFWXbQG9x44JVauFnG7zqzfuR4eDo9iGbXUm9rTLvW7kv","miners":["f0811822"],"multiAddresses":["/ip4/3.140.191.240/tcp/7523"]}
+	var spinfo struct {
+		Miners []string `json:"miners"`
+	}
+	if err = json.Unmarshal(data, &spinfo); err != nil {
+		return nil, err
+	}
+	if spinfo.Miners == nil {
+		return []string{}, nil
+	}
+	return spinfo.Miners, nil
+}
+
+// CachedResolver keeps resolved storage provider IDs in a file, and only uses
+// its resolver for peers that are not cached or whose cache entry is older
+// than the TTL. Nothing is cached when the resolver returns an error, since
+// its results may then be incomplete.
+type CachedResolver struct {
+	resolver Resolver
+	path     string
+	ttl      time.Duration
+}
+
+type cacheEntry struct {
+	SPIDs    []string  `json:"spids"`
+	Resolved time.Time `json:"resolved"`
+}
+
+// NewCachedResolver creates a resolver that caches the results of resolver in
+// the file at path.
+func NewCachedResolver(resolver Resolver, path string, ttl time.Duration) *CachedResolver {
+	return &CachedResolver{
+		resolver: resolver,
+		path:     path,
+		ttl:      ttl,
+	}
+}
+
+// Resolve implements Resolver.
+func (r *CachedResolver) Resolve(ctx context.Context, peerIDs []peer.ID) (map[peer.ID][]string, error) {
+	cache, err := r.load()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	resolved := make(map[peer.ID][]string, len(peerIDs))
+	var missing []peer.ID
+	for _, peerID := range peerIDs {
+		entry, ok := cache[peerID]
+		if ok && now.Sub(entry.Resolved) < r.ttl {
+			resolved[peerID] = entry.SPIDs
+		} else {
+			missing = append(missing, peerID)
+		}
+	}
+	if len(missing) == 0 {
+		return resolved, nil
+	}
+
+	found, err := r.resolver.Resolve(ctx, missing)
+	for _, peerID := range missing {
+		if spIDs, ok := found[peerID]; ok {
+			resolved[peerID] = spIDs
+		}
+	}
+	if err != nil {
+		// A peer missing from partial results, such as a partial lotus index,
+		// is not known to have no storage providers, so do not cache any.
+		return resolved, err
+	}
+	if len(found) == 0 {
+		return resolved, nil
+	}
+
+	for peerID, spIDs := range found {
+		cache[peerID] = cacheEntry{
+			SPIDs:    spIDs,
+			Resolved: now,
+		}
+	}
+	if err = r.save(cache); err != nil {
+		return nil, err
+	}
+	return resolved, nil
+}
+
+func (r *CachedResolver) load() (map[peer.ID]cacheEntry, error) {
+	cache := make(map[peer.ID]cacheEntry)
+	data, err := os.ReadFile(r.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return cache, nil
+		}
+		return nil, fmt.Errorf("cannot read spid cache: %w", err)
+	}
+	if err = json.Unmarshal(data, &cache); err != nil {
+		return nil, fmt.Errorf("cannot decode spid cache %s: %w", r.path, err)
+	}
+	return cache, nil
+}
+
+func (r *CachedResolver) save(cache map[peer.ID]cacheEntry) error {
+	data, err := json.Marshal(cache)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(r.path), 0o755); err != nil {
+		return fmt.Errorf("cannot create spid cache directory: %w", err)
+	}
+	// Write to a temporary file and rename, so that a concurrent reader never
+	// sees a partial cache.
+	tmp := r.path + ".tmp"
+	if err = os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("cannot write spid cache: %w", err)
+	}
+	return os.Rename(tmp, r.path)
+}
+
+// gatewayURL returns the JSON-RPC URL of a lotus gateway given as a host name
+// or as a URL.
+func gatewayURL(gateway string) string {
+	if strings.Contains(gateway, "://") {
+		return gateway
+	}
+	gwURL := url.URL{
+		Host:   gateway,
+		Scheme: "https",
+		Path:   "/rpc/v1",
+	}
+	return gwURL.String()
+}
@@ -0,0 +1,239 @@
+package spinfo_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ipni/ipni-cli/pkg/spaddr/spinfo"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testPeerA = "12D3KooWGuQafP1HDkE2ixXZnX6q6LLygsUG1uoxaQEtfPAt5ygp"
+	testPeerB = "12D3KooWE8yt84RVwW3sFcd6WMjbUdWrZer2YtT4dmtj3dHdahSZ"
+	testPeerC = "12D3KooWLjeDyvuv7rbfG2wWNvWn7ybmmU88PirmSckuqCgXBAph"
+)
+
+// newLotusStandIn starts a JSON-RPC server that answers the lotus gateway
+// methods used to resolve storage provider IDs. StateMinerInfo fails for any
+// of failMiners. The returned counter is the number of StateMinerInfo calls.
+func newLotusStandIn(t *testing.T, failMiners ...string) (*httptest.Server, *atomic.Int32) {
+	minerPeers := map[string]string{
+		"f01000": testPeerA,
+		"f01001": testPeerB,
+		"f01002": testPeerA,
+		"f01003": "",
+	}
+	var infoCalls atomic.Int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID     int               `json:"id"`
+			Method string            `json:"method"`
+			Params []json.RawMessage `json:"params"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+
+		resp := map[string]any{
+			"jsonrpc": "2.0",
+			"id":      req.ID,
+		}
+		var result any
+		switch req.Method {
+		case "Filecoin.ChainHead":
+			result = map[string]any{
+				"Cids":   []map[string]string{{"/": "bafy2bzacea3wsdh6y3a36tb3skempjoxqpuyompjbmfeyf34fi3uy6uue42v4"}},
+				"Blocks": []any{},
+				"Height": 100,
+			}
+		case "Filecoin.StateListMiners":
+			require.Len(t, req.Params, 1)
+			miners := make([]string, 0, len(minerPeers))
+			for miner := range minerPeers {
+				miners = append(miners, miner)
+			}
+			result = miners
+		case "Filecoin.StateMinerInfo":
+			infoCalls.Add(1)
+			require.Len(t, req.Params, 2)
+			var miner string
+			require.NoError(t, json.Unmarshal(req.Params[0], &miner))
+			if slices.Contains(failMiners, miner) {
+				resp["error"] = map[string]any{"code": 1, "message": "actor not found"}
+				break
+			}
+			info := map[string]any{}
+			if pid := minerPeers[miner]; pid != "" {
+				info["PeerId"] = pid
+			}
+			result = info
+		default:
+			t.Fatalf("unexpected method %s", req.Method)
+		}
+
+		if resp["error"] == nil {
+			resp["result"] = result
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &infoCalls
+}
+
+// stubResolver resolves the peers in spids, and records the peers that each
+// Resolve is asked for.
+type stubResolver struct {
+	spids     map[peer.ID][]string
+	err       error
+	requested [][]peer.ID
+}
+
+func (r *stubResolver) Resolve(_ context.Context, peerIDs []peer.ID) (map[peer.ID][]string, error) {
+	r.requested = append(r.requested, peerIDs)
+	resolved := make(map[peer.ID][]string)
+	for _, peerID := range peerIDs {
+		if spIDs, ok := r.spids[peerID]; ok {
+			resolved[peerID] = spIDs
+		}
+	}
+	return resolved, r.err
+}
+
+func decodePeers(t *testing.T, ids ...string) []peer.ID {
+	peerIDs := make([]peer.ID, len(ids))
+	for i, id := range ids {
+		var err error
+		peerIDs[i], err = peer.Decode(id)
+		require.NoError(t, err)
+	}
+	return peerIDs
+}
+
+func TestLotusResolver(t *testing.T) {
+	srv, infoCalls := newLotusStandIn(t)
+	peerIDs := decodePeers(t, testPeerA, testPeerB, testPeerC)
+
+	resolver, err := spinfo.NewLotusResolver(srv.URL, 3)
+	require.NoError(t, err)
+
+	resolved, err := resolver.Resolve(context.Background(), peerIDs)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"f01000", "f01002"}, resolved[peerIDs[0]])
+	require.Equal(t, []string{"f01001"}, resolved[peerIDs[1]])
+	require.Empty(t, resolved[peerIDs[2]])
+	require.Contains(t, resolved, peerIDs[2])
+	require.Equal(t, int32(4), infoCalls.Load())
+
+	// The index is only built once.
+	_, err = resolver.Resolve(context.Background(), peerIDs[:1])
+	require.NoError(t, err)
+	require.Equal(t, int32(4), infoCalls.Load())
+}
+
+func TestCachedResolver(t *testing.T) {
+	srv, infoCalls := newLotusStandIn(t)
+	peerIDs := decodePeers(t, testPeerB, testPeerC)
+	cachePath := filepath.Join(t.TempDir(), "spid", "cache.json")
+
+	newResolver := func(ttl time.Duration) *spinfo.CachedResolver {
+		lotus, err := spinfo.NewLotusResolver(srv.URL, 2)
+		require.NoError(t, err)
+		return spinfo.NewCachedResolver(lotus, cachePath, ttl)
+	}
+
+	resolved, err := newResolver(time.Hour).Resolve(context.Background(), peerIDs)
+	require.NoError(t, err)
+	require.Equal(t, []string{"f01001"}, resolved[peerIDs[0]])
+	require.Empty(t, resolved[peerIDs[1]])
+	require.Equal(t, int32(4), infoCalls.Load())
+
+	// Peers learned while building the index are cached even though they
+	// were not requested, and peers without a storage provider are cached.
+	resolved, err = newResolver(time.Hour).Resolve(context.Background(), decodePeers(t, testPeerA, testPeerC))
+	require.NoError(t, err)
+	require.Len(t, resolved, 2)
+	require.Equal(t, int32(4), infoCalls.Load())
+
+	// Expired entries are resolved again.
+	time.Sleep(time.Millisecond)
+	_, err = newResolver(time.Nanosecond).Resolve(context.Background(), peerIDs)
+	require.NoError(t, err)
+	require.Equal(t, int32(8), infoCalls.Load())
+}
+
+func TestLotusResolverPartial(t *testing.T) {
+	srv, infoCalls := newLotusStandIn(t, "f01001")
+	peerIDs := decodePeers(t, testPeerA, testPeerB, testPeerC)
+
+	resolver, err := spinfo.NewLotusResolver(srv.URL, 2)
+	require.NoError(t, err)
+
+	// The peers in the partial index are returned with the error. Peers that
+	// are not in the partial index are not returned, since they may belong
+	// to the storage provider whose info could not be read.
+	resolved, err := resolver.Resolve(context.Background(), peerIDs)
+	require.ErrorContains(t, err, "cannot get miner info for 1 of 4 miners")
+	require.Len(t, resolved, 1)
+	require.ElementsMatch(t, []string{"f01000", "f01002"}, resolved[peerIDs[0]])
+	require.Equal(t, int32(4), infoCalls.Load())
+
+	// The partial index is not kept, so the next Resolve builds it again.
+	_, err = resolver.Resolve(context.Background(), peerIDs)
+	require.Error(t, err)
+	require.Equal(t, int32(8), infoCalls.Load())
+}
+
+func TestCachedResolverPartial(t *testing.T) {
+	peerIDs := decodePeers(t, testPeerA, testPeerB, testPeerC)
+	cachePath := filepath.Join(t.TempDir(), "cache.json")
+
+	stub := &stubResolver{
+		spids: map[peer.ID][]string{peerIDs[0]: {"f01000"}},
+		err:   errors.New("resolver unavailable"),
+	}
+	resolved, err := spinfo.NewCachedResolver(stub, cachePath, time.Hour).Resolve(context.Background(), peerIDs)
+	require.ErrorContains(t, err, "resolver unavailable")
+	require.Equal(t, map[peer.ID][]string{peerIDs[0]: {"f01000"}}, resolved)
+
+	// Partial results are not cached, so all peers are resolved again.
+	require.NoFileExists(t, cachePath)
+	stub = &stubResolver{
+		spids: map[peer.ID][]string{peerIDs[0]: {"f01000"}, peerIDs[1]: {"f01001"}, peerIDs[2]: {}},
+	}
+	resolved, err = spinfo.NewCachedResolver(stub, cachePath, time.Hour).Resolve(context.Background(), peerIDs)
+	require.NoError(t, err)
+	require.Len(t, resolved, 3)
+	require.Equal(t, []string{"f01000"}, resolved[peerIDs[0]])
+	require.Equal(t, [][]peer.ID{peerIDs}, stub.requested)
+}
+
+func TestCachedResolverTTL(t *testing.T) {
+	peerIDs := decodePeers(t, testPeerA, testPeerB)
+	cachePath := filepath.Join(t.TempDir(), "cache.json")
+	stub := &stubResolver{
+		spids: map[peer.ID][]string{peerIDs[0]: {"f01000"}, peerIDs[1]: {}},
+	}
+
+	_, err := spinfo.NewCachedResolver(stub, cachePath, time.Hour).Resolve(context.Background(), peerIDs)
+	require.NoError(t, err)
+	_, err = spinfo.NewCachedResolver(stub, cachePath, time.Hour).Resolve(context.Background(), peerIDs)
+	require.NoError(t, err)
+	require.Len(t, stub.requested, 1)
+
+	time.Sleep(time.Millisecond)
+	resolved, err := spinfo.NewCachedResolver(stub, cachePath, time.Millisecond).Resolve(context.Background(), peerIDs)
+	require.NoError(t, err)
+	require.Equal(t, []string{"f01000"}, resolved[peerIDs[0]])
+	require.Len(t, stub.requested, 2)
+	require.Equal(t, peerIDs, stub.requested[1])
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"net/url"
 
 	"github.com/filecoin-project/go-address"
 	"github.com/ipfs/go-cid"
@@ -46,12 +45,7 @@ func SPAddrInfo(ctx context.Context, gateway, spID string) (peer.AddrInfo, error
 		return peer.AddrInfo{}, fmt.Errorf("invalid storage provider id: %w", err)
 	}
 
-	gwURL := url.URL{
-		Host:   gateway,
-		Scheme: "https",
-		Path:   "/rpc/v1",
-	}
-	jrpcClient := jrpc.NewClient(gwURL.String())
+	jrpcClient := jrpc.NewClient(gatewayURL(gateway))
 
 	var ets ExpTipSet
 	err = jrpcClient.CallFor(&ets, "Filecoin.ChainHead")